@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"runtime"
 	"sort"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/parsers/kernel"
 	"github.com/docker/go-events"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/networkdb"
 	"github.com/docker/libnetwork/types"
 	"github.com/gogo/protobuf/proto"
@@ -22,6 +26,8 @@ const (
 	subsysGossip = "networking:gossip"
 	subsysIPSec  = "networking:ipsec"
 	keyringSize  = 3
+
+	networkDBSnapshotFile = "networkdb-snapshot.json"
 )
 
 // ByTime implements sort.Interface for []*types.EncryptionKey based on
@@ -235,6 +241,45 @@ func (c *controller) getPrimaryKeyTag(subsys string) ([]byte, uint64, error) {
 	return keys[1].Key, keys[1].LamportTime, nil
 }
 
+// nodeLabels returns the labels this node advertises to the cluster: the
+// configured ones and the ones libnetwork sets, which take precedence
+func nodeLabels(configured map[string]string) map[string]string {
+	labels := make(map[string]string, len(configured)+2)
+	for k, v := range configured {
+		labels[k] = v
+	}
+
+	labels[netlabel.NodeOS] = runtime.GOOS
+	if v, err := kernel.GetKernelVersion(); err == nil {
+		labels[netlabel.NodeKernelVersion] = v.String()
+	} else {
+		logrus.Warnf("Could not get the kernel version to advertise: %v", err)
+	}
+	return labels
+}
+
+// clusterNodes returns the active nodes of the gossip cluster for the
+// drivers
+func (c *controller) clusterNodes() []driverapi.ClusterNode {
+	nDB := c.NetworkDB()
+	if nDB == nil {
+		return nil
+	}
+
+	var nodes []driverapi.ClusterNode
+	for _, n := range nDB.Nodes() {
+		if n.State != networkdb.NodeStateActive {
+			continue
+		}
+		nodes = append(nodes, driverapi.ClusterNode{
+			Name:    n.Name,
+			Address: n.Addr,
+			Labels:  n.Labels,
+		})
+	}
+	return nodes
+}
+
 func (c *controller) agentInit(bindAddrOrInterface, advertiseAddr string) error {
 	if !c.isAgent() {
 		return nil
@@ -247,11 +292,24 @@ func (c *controller) agentInit(bindAddrOrInterface, advertiseAddr string) error
 
 	keys, tags := c.getKeys(subsysGossip)
 	hostname, _ := os.Hostname()
-	nDB, err := networkdb.New(&networkdb.Config{
-		AdvertiseAddr: advertiseAddr,
-		NodeName:      hostname,
-		Keys:          keys,
-	})
+	nDBCfg := c.cfg.NetworkDB
+	nDBConfig := &networkdb.Config{
+		AdvertiseAddr:     advertiseAddr,
+		NodeName:          hostname,
+		Keys:              keys,
+		Profile:           nDBCfg.Profile,
+		GossipInterval:    nDBCfg.GossipInterval,
+		PushPullInterval:  nDBCfg.PushPullInterval,
+		RetransmitMult:    nDBCfg.RetransmitMult,
+		ReapInterval:      nDBCfg.ReapInterval,
+		TombstoneLifetime: nDBCfg.TombstoneLifetime,
+		PacketBufferSize:  nDBCfg.PacketBufferSize,
+		Labels:            nodeLabels(nDBCfg.Labels),
+	}
+	if c.cfg.Daemon.DataDir != "" {
+		nDBConfig.SnapshotPath = filepath.Join(c.cfg.Daemon.DataDir, "network", "files", networkDBSnapshotFile)
+	}
+	nDB, err := networkdb.New(nDBConfig)
 
 	if err != nil {
 		return err
@@ -359,6 +417,23 @@ func (n *network) joinCluster() error {
 	}
 
 	c := n.getController()
+
+	// Before joining, the network only has the service records
+	// restored from the networkdb snapshot, which were not notified
+	// to the watchers. The ones the peers confirm are not notified
+	// again.
+	c.agent.networkDB.WalkTable("endpoint_table", func(nid, key string, value []byte) bool {
+		if nid == n.ID() {
+			c.handleEpTableEvent(networkdb.CreateEvent{
+				Table:     "endpoint_table",
+				NetworkID: nid,
+				Key:       key,
+				Value:     value,
+			})
+		}
+		return false
+	})
+
 	return c.agent.networkDB.JoinNetwork(n.ID())
 }
 
@@ -381,8 +456,10 @@ func (ep *endpoint) addToCluster() error {
 	if !ep.isAnonymous() && ep.Iface().Address() != nil {
 		var ingressPorts []*PortConfig
 		if ep.svcID != "" {
-			// Gossip ingress ports only in ingress network.
-			if n.ingress {
+			// Gossip ingress ports only in ingress network. The DNS
+			// round robin services gossip them in all networks to
+			// serve their SRV records.
+			if n.ingress || ep.svcMode == ServiceModeDNSRR {
 				ingressPorts = ep.ingressPorts
 			}
 
@@ -430,7 +507,7 @@ func (ep *endpoint) deleteFromCluster() error {
 	if !ep.isAnonymous() {
 		if ep.svcID != "" && ep.Iface().Address() != nil {
 			var ingressPorts []*PortConfig
-			if n.ingress {
+			if n.ingress || ep.svcMode == ServiceModeDNSRR {
 				ingressPorts = ep.ingressPorts
 			}
 
@@ -9,8 +9,10 @@ import (
 	"strings"
 
 	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/metrics"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/networkdb"
 	"github.com/docker/libnetwork/types"
 	"github.com/gorilla/mux"
 )
@@ -41,24 +43,47 @@ const (
 	sbPIDQr  = "{" + urlSbPID + ":" + qregx + "}"
 	cnIDQr   = "{" + urlCnID + ":" + qregx + "}"
 	cnPIDQr  = "{" + urlCnPID + ":" + qregx + "}"
+	tblName  = "{" + urlTblName + ":" + regex + "}"
 
 	// Internal URL variable name.They can be anything as
 	// long as they do not collide with query fields.
-	urlNwName = "network-name"
-	urlNwID   = "network-id"
-	urlNwPID  = "network-partial-id"
-	urlEpName = "endpoint-name"
-	urlEpID   = "endpoint-id"
-	urlEpPID  = "endpoint-partial-id"
-	urlSbID   = "sandbox-id"
-	urlSbPID  = "sandbox-partial-id"
-	urlCnID   = "container-id"
-	urlCnPID  = "container-partial-id"
+	urlNwName  = "network-name"
+	urlNwID    = "network-id"
+	urlNwPID   = "network-partial-id"
+	urlEpName  = "endpoint-name"
+	urlEpID    = "endpoint-id"
+	urlEpPID   = "endpoint-partial-id"
+	urlSbID    = "sandbox-id"
+	urlSbPID   = "sandbox-partial-id"
+	urlCnID    = "container-id"
+	urlCnPID   = "container-partial-id"
+	urlTblName = "table-name"
 )
 
+// HandlerOption configures the HTTP handler returned by NewHTTPHandler
+type HandlerOption func(h *httpHandler)
+
+// WithMetrics serves the libnetwork metrics in the Prometheus text format on /metrics
+func WithMetrics() HandlerOption {
+	return func(h *httpHandler) {
+		h.metrics = true
+	}
+}
+
+// WithNetworkDBDebug serves the read-only introspection of the cluster
+// gossip database on /networkdb
+func WithNetworkDBDebug() HandlerOption {
+	return func(h *httpHandler) {
+		h.networkDB = true
+	}
+}
+
 // NewHTTPHandler creates and initialize the HTTP handler to serve the requests for libnetwork
-func NewHTTPHandler(c libnetwork.NetworkController) func(w http.ResponseWriter, req *http.Request) {
+func NewHTTPHandler(c libnetwork.NetworkController, opts ...HandlerOption) func(w http.ResponseWriter, req *http.Request) {
 	h := &httpHandler{c: c}
+	for _, opt := range opts {
+		opt(h)
+	}
 	h.initRouter()
 	return h.handleRequest
 }
@@ -75,8 +100,10 @@ func (r *responseStatus) isOK() bool {
 type processor func(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus)
 
 type httpHandler struct {
-	c libnetwork.NetworkController
-	r *mux.Router
+	c         libnetwork.NetworkController
+	r         *mux.Router
+	metrics   bool
+	networkDB bool
 }
 
 func (h *httpHandler) handleRequest(w http.ResponseWriter, req *http.Request) {
@@ -117,6 +144,7 @@ func (h *httpHandler) initRouter() {
 			{"/sandboxes", []string{"partial-id", sbPIDQr}, procGetSandboxes},
 			{"/sandboxes", nil, procGetSandboxes},
 			{"/sandboxes/" + sbID, nil, procGetSandbox},
+			{"/sandboxes/" + sbID + "/dnslog", nil, procGetDNSQueryLog},
 		},
 		"POST": {
 			{"/networks", nil, procCreateNetwork},
@@ -125,6 +153,7 @@ func (h *httpHandler) initRouter() {
 			{"/services", nil, procPublishService},
 			{"/services/" + epID + "/backend", nil, procAttachBackend},
 			{"/sandboxes", nil, procCreateSandbox},
+			{"/reconcile", nil, procReconcile},
 		},
 		"DELETE": {
 			{"/networks/" + nwID, nil, procDeleteNetwork},
@@ -136,7 +165,29 @@ func (h *httpHandler) initRouter() {
 		},
 	}
 
+	if h.networkDB {
+		m["GET"] = append(m["GET"], []struct {
+			url string
+			qrs []string
+			fct processor
+		}{
+			{"/networkdb/nodes", nil, procGetNetworkDBNodes},
+			{"/networkdb/networks", nil, procGetNetworkDBNetworks},
+			{"/networkdb/networknodes", nil, procGetNetworkDBNetworkNodes},
+			{"/networkdb/tables", nil, procGetNetworkDBTables},
+			{"/networkdb/tables/" + tblName + "/" + nwID, nil, procGetNetworkDBEntries},
+		}...)
+	}
+
 	h.r = mux.NewRouter()
+	if h.metrics {
+		h.r.Path("/metrics").Methods("GET").Handler(metrics.Handler())
+	}
+	// The DNS query log stream writes to the connection as the queries
+	// come, it does not fit the processor model
+	for _, url := range []string{"/{.*}/sandboxes/" + sbID + "/dnslog", "/sandboxes/" + sbID + "/dnslog"} {
+		h.r.Path(url).Methods("GET").Queries("follow", "true").HandlerFunc(makeDNSQueryLogStreamHandler(h.c))
+	}
 	for method, routes := range m {
 		for _, route := range routes {
 			r := h.r.Path("/{.*}" + route.url).Methods(method).HandlerFunc(makeHandler(h.c, route.fct))
@@ -187,6 +238,16 @@ func buildNetworkResource(nw libnetwork.Network) *networkResource {
 		r.Name = nw.Name()
 		r.ID = nw.ID()
 		r.Type = nw.Type()
+		if info := nw.Info(); info != nil {
+			q, u := info.Quota(), info.QuotaUsage()
+			r.Quota = &quotaResource{
+				MaxEndpoints:           q.MaxEndpoints,
+				MaxEndpointsPerSandbox: q.MaxEndpointsPerSandbox,
+				MaxAddresses:           q.MaxAddresses,
+				Endpoints:              u.Endpoints,
+				Addresses:              u.Addresses,
+			}
+		}
 		epl := nw.Endpoints()
 		r.Endpoints = make([]*endpointResource, 0, len(epl))
 		for _, e := range epl {
@@ -217,6 +278,33 @@ func buildSandboxResource(sb libnetwork.Sandbox) *sandboxResource {
 	return r
 }
 
+func buildDNSQueryResource(e libnetwork.DNSQueryLogEntry) *dnsQueryResource {
+	return &dnsQueryResource{
+		Time:     e.Time,
+		Client:   e.Client,
+		Name:     e.Name,
+		Type:     e.Type,
+		Answer:   e.Answer,
+		Upstream: e.Upstream,
+		Rcode:    e.Rcode,
+		Latency:  e.Latency.String(),
+	}
+}
+
+func buildReconcileResource(report *libnetwork.ReconcileReport) *reconcileResource {
+	r := &reconcileResource{DryRun: report.DryRun, Drifts: []*driftResource{}}
+	for _, d := range report.Drifts {
+		r.Drifts = append(r.Drifts, &driftResource{
+			Kind:     string(d.Kind),
+			Resource: d.Resource,
+			Detail:   d.Detail,
+			Repaired: d.Repaired,
+			Error:    d.Error,
+		})
+	}
+	return r
+}
+
 /****************
  Options Parsers
 *****************/
@@ -257,6 +345,9 @@ func (sc *sandboxCreate) parseOptions() []libnetwork.SandboxOption {
 	if sc.PortMapping != nil {
 		setFctList = append(setFctList, libnetwork.OptionPortMapping(sc.PortMapping))
 	}
+	if sc.DNSQueryLog {
+		setFctList = append(setFctList, libnetwork.OptionDNSQueryLog(sc.DNSQueryLogSize))
+	}
 	return setFctList
 }
 
@@ -317,11 +408,28 @@ func procCreateNetwork(c libnetwork.NetworkController, vars map[string]string, b
 		options = append(options, libnetwork.NetworkOptionIpam("default", "", []*libnetwork.IpamConf{ipamV4Conf}, nil, nil))
 	}
 
+	if create.Quota != nil {
+		options = append(options, libnetwork.NetworkOptionQuota(libnetwork.NetworkQuota{
+			MaxEndpoints:           create.Quota.MaxEndpoints,
+			MaxEndpointsPerSandbox: create.Quota.MaxEndpointsPerSandbox,
+			MaxAddresses:           create.Quota.MaxAddresses,
+		}))
+	}
+
+	if create.DryRun {
+		options = append(options, libnetwork.NetworkOptionDryRun())
+	}
+
 	nw, err := c.NewNetwork(create.NetworkType, create.Name, create.ID, options...)
 	if err != nil {
 		return nil, convertNetworkError(err)
 	}
 
+	// A dry run returns the would-be network, nothing was created
+	if create.DryRun {
+		return buildNetworkResource(nw), &successResponse
+	}
+
 	return nw.ID(), &createdResponse
 }
 
@@ -382,6 +490,23 @@ func procCreateSandbox(c libnetwork.NetworkController, vars map[string]string, b
 	return sb.ID(), &createdResponse
 }
 
+func procReconcile(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	var rc reconcile
+
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &rc); err != nil {
+			return nil, &responseStatus{Status: "Invalid body: " + err.Error(), StatusCode: http.StatusBadRequest}
+		}
+	}
+
+	report, err := c.Reconcile(rc.DryRun)
+	if err != nil {
+		return nil, convertNetworkError(err)
+	}
+
+	return buildReconcileResource(report), &successResponse
+}
+
 /******************
  Network interface
 *******************/
@@ -751,6 +876,76 @@ func procGetSandbox(c libnetwork.NetworkController, vars map[string]string, body
 	return buildSandboxResource(sb), &successResponse
 }
 
+func procGetDNSQueryLog(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	sb, errRsp := findSandbox(c, vars[urlSbID], byID)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+
+	entries, err := sb.DNSQueryLog()
+	if err != nil {
+		return nil, convertNetworkError(err)
+	}
+
+	list := make([]*dnsQueryResource, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, buildDNSQueryResource(e))
+	}
+	return list, &successResponse
+}
+
+// makeDNSQueryLogStreamHandler returns the handler streaming the queries of
+// a sandbox as one JSON object per line, till the client goes away or the
+// sandbox is deleted
+func makeDNSQueryLogStreamHandler(c libnetwork.NetworkController) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		sb, errRsp := findSandbox(c, mux.Vars(req)[urlSbID], byID)
+		if !errRsp.isOK() {
+			http.Error(w, errRsp.Status, errRsp.StatusCode)
+			return
+		}
+
+		ch, cancel, err := sb.WatchDNSQueryLog()
+		if err != nil {
+			errRsp = convertNetworkError(err)
+			http.Error(w, errRsp.Status, errRsp.StatusCode)
+			return
+		}
+		defer cancel()
+
+		var closeNotify <-chan bool
+		if cn, ok := w.(http.CloseNotifier); ok {
+			closeNotify = cn.CloseNotify()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		flush(w)
+
+		enc := json.NewEncoder(w)
+		for {
+			select {
+			case e, ok := <-ch:
+				if !ok {
+					return
+				}
+				if err := enc.Encode(buildDNSQueryResource(e)); err != nil {
+					return
+				}
+				flush(w)
+			case <-closeNotify:
+				return
+			}
+		}
+	}
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 type cndFnMkr func(string) cndFn
 type cndFn func(libnetwork.Sandbox) bool
 
@@ -951,6 +1146,57 @@ func endpointToService(rsp *responseStatus) *responseStatus {
 	return rsp
 }
 
+/*******************
+ NetworkDB interface
+********************/
+func networkDB(c libnetwork.NetworkController) (networkdb.Introspector, *responseStatus) {
+	nDB := c.NetworkDB()
+	if nDB == nil {
+		return nil, &responseStatus{Status: "cluster agent is not running", StatusCode: http.StatusServiceUnavailable}
+	}
+	return nDB, &successResponse
+}
+
+func procGetNetworkDBNodes(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	nDB, errRsp := networkDB(c)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	return nDB.Nodes(), &successResponse
+}
+
+func procGetNetworkDBNetworks(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	nDB, errRsp := networkDB(c)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	return nDB.Networks(), &successResponse
+}
+
+func procGetNetworkDBNetworkNodes(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	nDB, errRsp := networkDB(c)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	return nDB.NetworkNodes(), &successResponse
+}
+
+func procGetNetworkDBTables(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	nDB, errRsp := networkDB(c)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	return nDB.Tables(), &successResponse
+}
+
+func procGetNetworkDBEntries(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	nDB, errRsp := networkDB(c)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+	return nDB.Entries(vars[urlTblName], vars[urlNwID]), &successResponse
+}
+
 func convertNetworkError(err error) *responseStatus {
 	var code int
 	switch err.(type) {
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"regexp"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/docker/docker/pkg/reexec"
@@ -1753,6 +1755,72 @@ func TestHttpHandlerUninit(t *testing.T) {
 	}
 }
 
+func TestHttpHandlerMetrics(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	n, err := c.NewNetwork(bridgeNetType, "metricsnet", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp := httptest.NewRecorder()
+	NewHTTPHandler(c)(rsp, req)
+	if rsp.Code != http.StatusNotFound {
+		t.Fatalf("Expected (%d) without metrics enabled. Got (%d)", http.StatusNotFound, rsp.Code)
+	}
+
+	rsp = httptest.NewRecorder()
+	NewHTTPHandler(c, WithMetrics())(rsp, req)
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("Expected (%d). Got (%d): %s", http.StatusOK, rsp.Code, rsp.Body.String())
+	}
+
+	expected := `libnetwork_network_create_duration_seconds_count{driver="bridge"}`
+	if !strings.Contains(rsp.Body.String(), expected) {
+		t.Fatalf("Expected %s in metrics output:\n%s", expected, rsp.Body.String())
+	}
+}
+
+func TestHttpHandlerNetworkDBDebug(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	req, err := http.NewRequest("GET", "/networkdb/tables", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp := httptest.NewRecorder()
+	NewHTTPHandler(c)(rsp, req)
+	if rsp.Code != http.StatusNotFound {
+		t.Fatalf("Expected (%d) without NetworkDB debug enabled. Got (%d)", http.StatusNotFound, rsp.Code)
+	}
+
+	// The controller is not a cluster agent
+	rsp = httptest.NewRecorder()
+	NewHTTPHandler(c, WithNetworkDBDebug())(rsp, req)
+	if rsp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected (%d). Got (%d): %s", http.StatusServiceUnavailable, rsp.Code, rsp.Body.String())
+	}
+}
+
 func TestHttpHandlerBadBody(t *testing.T) {
 	defer testutils.SetupTestOSContext(t)()
 
@@ -1,6 +1,10 @@
 package api
 
-import "github.com/docker/libnetwork/types"
+import (
+	"time"
+
+	"github.com/docker/libnetwork/types"
+)
 
 /***********
  Resources
@@ -12,6 +16,16 @@ type networkResource struct {
 	ID        string              `json:"id"`
 	Type      string              `json:"type"`
 	Endpoints []*endpointResource `json:"endpoints"`
+	Quota     *quotaResource      `json:"quota,omitempty"`
+}
+
+// quotaResource reports the quota of a network and its current usage
+type quotaResource struct {
+	MaxEndpoints           uint64 `json:"max_endpoints"`
+	MaxEndpointsPerSandbox uint64 `json:"max_endpoints_per_sandbox"`
+	MaxAddresses           uint64 `json:"max_addresses"`
+	Endpoints              uint64 `json:"endpoints"`
+	Addresses              uint64 `json:"addresses"`
 }
 
 // endpointResource is the body of the "get endpoint" http response message
@@ -28,6 +42,34 @@ type sandboxResource struct {
 	ContainerID string `json:"container_id"`
 }
 
+// driftResource describes a single inconsistency in the "reconcile" http response message
+type driftResource struct {
+	Kind     string `json:"kind"`
+	Resource string `json:"resource"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
+
+// reconcileResource is the body of the "reconcile" http response message
+type reconcileResource struct {
+	DryRun bool             `json:"dry_run"`
+	Drifts []*driftResource `json:"drifts"`
+}
+
+// dnsQueryResource is an entry of the "get sandbox DNS query log" http
+// response message
+type dnsQueryResource struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Answer   string    `json:"answer"`
+	Upstream string    `json:"upstream,omitempty"`
+	Rcode    string    `json:"rcode,omitempty"`
+	Latency  string    `json:"latency"`
+}
+
 /***********
   Body types
   ************/
@@ -47,6 +89,15 @@ type networkCreate struct {
 	IPv4Conf    []ipamConf        `json:"ipv4_configuration"`
 	DriverOpts  map[string]string `json:"driver_opts"`
 	NetworkOpts map[string]string `json:"network_opts"`
+	Quota       *networkQuota     `json:"quota,omitempty"`
+	DryRun      bool              `json:"dry_run,omitempty"`
+}
+
+// networkQuota is the quota passed in the "create network" http request message
+type networkQuota struct {
+	MaxEndpoints           uint64 `json:"max_endpoints"`
+	MaxEndpointsPerSandbox uint64 `json:"max_endpoints_per_sandbox"`
+	MaxAddresses           uint64 `json:"max_addresses"`
 }
 
 // endpointCreate represents the body of the "create endpoint" http request message
@@ -68,6 +119,8 @@ type sandboxCreate struct {
 	UseExternalKey    bool                  `json:"use_external_key"`
 	ExposedPorts      []types.TransportPort `json:"exposed_ports"`
 	PortMapping       []types.PortBinding   `json:"port_mapping"`
+	DNSQueryLog       bool                  `json:"dns_query_log,omitempty"`
+	DNSQueryLogSize   int                   `json:"dns_query_log_size,omitempty"`
 }
 
 // endpointJoin represents the expected body of the "join endpoint" or "leave endpoint" http request messages
@@ -94,3 +147,8 @@ type extraHost struct {
 	Name    string `json:"name"`
 	Address string `json:"address"`
 }
+
+// reconcile is the expected body of the "reconcile" http request message
+type reconcile struct {
+	DryRun bool `json:"dry_run"`
+}
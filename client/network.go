@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 
@@ -49,6 +50,10 @@ func (cli *NetworkCli) CmdNetworkCreate(chain string, args ...string) error {
 	flIPv6 := cmd.Bool([]string{"-ipv6"}, false, "Enable IPv6 on the network")
 	flSubnet := cmd.String([]string{"-subnet"}, "", "Subnet option")
 	flRange := cmd.String([]string{"-ip-range"}, "", "Range option")
+	flMaxEndpoints := cmd.Uint64([]string{"-max-endpoints"}, 0, "Maximum number of endpoints on the network")
+	flMaxSandboxEndpoints := cmd.Uint64([]string{"-max-endpoints-per-sandbox"}, 0, "Maximum number of network endpoints per container")
+	flMaxAddresses := cmd.Uint64([]string{"-max-addresses"}, 0, "Maximum number of addresses reserved by the network endpoints")
+	flDryRun := cmd.Bool([]string{"-dry-run"}, false, "Validate the network configuration without creating the network")
 
 	cmd.Require(flag.Exact, 1)
 	err := cmd.ParseFlags(args, true)
@@ -85,11 +90,22 @@ func (cli *NetworkCli) CmdNetworkCreate(chain string, args ...string) error {
 	}
 
 	// Construct network create request body
-	nc := networkCreate{Name: cmd.Arg(0), NetworkType: *flDriver, ID: *flID, IPv4Conf: icList, DriverOpts: driverOpts, NetworkOpts: networkOpts}
+	nc := networkCreate{Name: cmd.Arg(0), NetworkType: *flDriver, ID: *flID, IPv4Conf: icList, DriverOpts: driverOpts, NetworkOpts: networkOpts, DryRun: *flDryRun}
+	if *flMaxEndpoints != 0 || *flMaxSandboxEndpoints != 0 || *flMaxAddresses != 0 {
+		nc.Quota = &networkQuota{MaxEndpoints: *flMaxEndpoints, MaxEndpointsPerSandbox: *flMaxSandboxEndpoints, MaxAddresses: *flMaxAddresses}
+	}
 	obj, _, err := readBody(cli.call("POST", "/networks", nc, nil))
 	if err != nil {
 		return err
 	}
+	if *flDryRun {
+		var nwr networkResource
+		if err := json.Unmarshal(obj, &nwr); err != nil {
+			return err
+		}
+		fmt.Fprintf(cli.out, "Network %s (%s) configuration is valid\n", nwr.Name, nwr.Type)
+		return nil
+	}
 	var replyID string
 	err = json.Unmarshal(obj, &replyID)
 	if err != nil {
@@ -196,6 +212,10 @@ func (cli *NetworkCli) CmdNetworkInfo(chain string, args ...string) error {
 	fmt.Fprintf(cli.out, "Network Id: %s\n", networkResource.ID)
 	fmt.Fprintf(cli.out, "Name: %s\n", networkResource.Name)
 	fmt.Fprintf(cli.out, "Type: %s\n", networkResource.Type)
+	if q := networkResource.Quota; q != nil {
+		fmt.Fprintf(cli.out, "Endpoints: %d (max %s, max per container %s)\n", q.Endpoints, quotaLimit(q.MaxEndpoints), quotaLimit(q.MaxEndpointsPerSandbox))
+		fmt.Fprintf(cli.out, "Addresses: %d (max %s)\n", q.Addresses, quotaLimit(q.MaxAddresses))
+	}
 	if networkResource.Services != nil {
 		for _, serviceResource := range networkResource.Services {
 			fmt.Fprintf(cli.out, "  Service Id: %s\n", serviceResource.ID)
@@ -255,6 +275,13 @@ func lookupNetworkID(cli *NetworkCli, nameID string) (string, error) {
 	return list[0].ID, nil
 }
 
+func quotaLimit(max uint64) string {
+	if max == 0 {
+		return "unlimited"
+	}
+	return strconv.FormatUint(max, 10)
+}
+
 func networkUsage(chain string) string {
 	help := "Commands:\n"
 
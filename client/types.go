@@ -12,6 +12,16 @@ type networkResource struct {
 	ID       string             `json:"id"`
 	Type     string             `json:"type"`
 	Services []*serviceResource `json:"services"`
+	Quota    *quotaResource     `json:"quota,omitempty"`
+}
+
+// quotaResource reports the quota of a network and its current usage
+type quotaResource struct {
+	MaxEndpoints           uint64 `json:"max_endpoints"`
+	MaxEndpointsPerSandbox uint64 `json:"max_endpoints_per_sandbox"`
+	MaxAddresses           uint64 `json:"max_addresses"`
+	Endpoints              uint64 `json:"endpoints"`
+	Addresses              uint64 `json:"addresses"`
 }
 
 // serviceResource is the body of the "get service" http response message
@@ -28,6 +38,21 @@ type SandboxResource struct {
 	ContainerID string `json:"container_id"`
 }
 
+// DriftResource describes a single inconsistency in the "reconcile" response message
+type DriftResource struct {
+	Kind     string `json:"kind"`
+	Resource string `json:"resource"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ReconcileResource is the body of the "post /reconcile" response message
+type ReconcileResource struct {
+	DryRun bool             `json:"dry_run"`
+	Drifts []*DriftResource `json:"drifts"`
+}
+
 /***********
   Body types
   ************/
@@ -46,6 +71,15 @@ type networkCreate struct {
 	IPv4Conf    []ipamConf        `json:"ipv4_configuration"`
 	DriverOpts  map[string]string `json:"driver_opts"`
 	NetworkOpts map[string]string `json:"network_opts"`
+	Quota       *networkQuota     `json:"quota,omitempty"`
+	DryRun      bool              `json:"dry_run,omitempty"`
+}
+
+// networkQuota is the quota passed in the "create network" http request message
+type networkQuota struct {
+	MaxEndpoints           uint64 `json:"max_endpoints"`
+	MaxEndpointsPerSandbox uint64 `json:"max_endpoints_per_sandbox"`
+	MaxAddresses           uint64 `json:"max_addresses"`
 }
 
 // serviceCreate represents the body of the "publish service" http request message
@@ -81,6 +115,11 @@ type SandboxCreate struct {
 	PortMapping       []types.PortBinding   `json:"port_mapping"`
 }
 
+// Reconcile is the body of the "post /reconcile" http request message
+type Reconcile struct {
+	DryRun bool `json:"dry_run"`
+}
+
 // extraHost represents the extra host object
 type extraHost struct {
 	Name    string `json:"name"`
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"text/tabwriter"
 
 	"github.com/codegangsta/cli"
 	"github.com/docker/docker/pkg/term"
@@ -31,6 +32,18 @@ var (
 		containerRmCommand,
 	}
 
+	reconcileCommand = cli.Command{
+		Name:  "reconcile",
+		Usage: "Detect and repair drift between the datastore and the kernel state",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only report the drift, do not repair it",
+			},
+		},
+		Action: runReconcile,
+	}
+
 	dnetCommands = []cli.Command{
 		createDockerCommand("network"),
 		createDockerCommand("service"),
@@ -39,6 +52,7 @@ var (
 			Usage:       "Container management commands",
 			Subcommands: containerCommands,
 		},
+		reconcileCommand,
 	}
 )
 
@@ -98,6 +112,36 @@ func runContainerRm(c *cli.Context) {
 	}
 }
 
+func runReconcile(c *cli.Context) {
+	rc := client.Reconcile{DryRun: c.Bool("dry-run")}
+	obj, _, err := readBody(epConn.httpCall("POST", "/reconcile", rc, nil))
+	if err != nil {
+		fmt.Printf("POST failed during reconcile: %v\n", err)
+		os.Exit(1)
+	}
+
+	var report client.ReconcileResource
+	err = json.Unmarshal(obj, &report)
+	if err != nil {
+		fmt.Printf("Unmarshall of reconcile response failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	wr := tabwriter.NewWriter(os.Stdout, 5, 1, 3, ' ', 0)
+	fmt.Fprintln(wr, "KIND\tRESOURCE\tSTATUS\tDETAIL")
+	for _, d := range report.Drifts {
+		status := "found"
+		switch {
+		case d.Repaired:
+			status = "repaired"
+		case d.Error != "":
+			status = "failed: " + d.Error
+		}
+		fmt.Fprintf(wr, "%s\t%s\t%s\t%s\n", d.Kind, d.Resource, status, d.Detail)
+	}
+	wr.Flush()
+}
+
 func runDockerCommand(c *cli.Context, cmd string) {
 	_, stdout, stderr := term.StdStreams()
 	oldcli := client.NewNetworkCli(stdout, stderr, epConn.httpCall)
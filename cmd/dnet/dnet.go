@@ -245,7 +245,7 @@ type NetworkOrchestration struct {
 	Peer    string
 }
 
-func (d *dnetConnection) dnetDaemon(cfgFile string) error {
+func (d *dnetConnection) dnetDaemon(cfgFile string, metricsEnabled, networkDBDebug bool) error {
 	if err := startTestDriver(); err != nil {
 		return fmt.Errorf("failed to start test driver: %v\n", err)
 	}
@@ -279,7 +279,14 @@ func (d *dnetConnection) dnetDaemon(cfgFile string) error {
 	}
 
 	createDefaultNetwork(controller)
-	httpHandler := api.NewHTTPHandler(controller)
+	var apiOptions []api.HandlerOption
+	if metricsEnabled {
+		apiOptions = append(apiOptions, api.WithMetrics())
+	}
+	if networkDBDebug {
+		apiOptions = append(apiOptions, api.WithNetworkDBDebug())
+	}
+	httpHandler := api.NewHTTPHandler(controller, apiOptions...)
 	r := mux.NewRouter().StrictSlash(false)
 	post := r.PathPrefix("/{.*}/networks").Subrouter()
 	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
@@ -293,6 +300,13 @@ func (d *dnetConnection) dnetDaemon(cfgFile string) error {
 	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
 	post = r.PathPrefix("/sandboxes").Subrouter()
 	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
+	r.Path("/reconcile").Methods("POST").HandlerFunc(httpHandler)
+	if metricsEnabled {
+		r.Path("/metrics").Methods("GET").HandlerFunc(httpHandler)
+	}
+	if networkDBDebug {
+		r.PathPrefix("/networkdb").Methods("GET").HandlerFunc(httpHandler)
+	}
 
 	handleSignals(controller)
 	setupDumpStackTrap()
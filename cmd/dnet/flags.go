@@ -28,6 +28,14 @@ var (
 			Name:  "D, -debug",
 			Usage: "Enable debug mode",
 		},
+		cli.BoolFlag{
+			Name:  "m, -metrics",
+			Usage: "Expose metrics on /metrics in daemon mode",
+		},
+		cli.BoolFlag{
+			Name:  "debug-networkdb",
+			Usage: "Expose the NetworkDB introspection on /networkdb in daemon mode",
+		},
 		cli.StringFlag{
 			Name:  "c, -cfg-file",
 			Value: "/etc/default/libnetwork.toml",
@@ -75,7 +83,7 @@ func processFlags(c *cli.Context) error {
 	}
 
 	if c.Bool("d") {
-		err = epConn.dnetDaemon(c.String("c"))
+		err = epConn.dnetDaemon(c.String("c"), c.Bool("m"), c.Bool("debug-networkdb"))
 		if err != nil {
 			logrus.Errorf("dnet Daemon exited with an error : %v", err)
 			os.Exit(1)
@@ -2,6 +2,7 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	log "github.com/Sirupsen/logrus"
@@ -18,6 +19,7 @@ import (
 type Config struct {
 	Daemon          DaemonCfg
 	Cluster         ClusterCfg
+	NetworkDB       NetworkDBCfg
 	Scopes          map[string]*datastore.ScopeCfg
 	ActiveSandboxes map[string]interface{}
 }
@@ -32,6 +34,27 @@ type DaemonCfg struct {
 	DriverCfg       map[string]interface{}
 	ClusterProvider cluster.Provider
 	DisableProvider chan struct{}
+	// ResolverCacheSize is the number of external DNS responses the
+	// embedded resolver caches. Zero selects the default size, a negative
+	// value disables the cache.
+	ResolverCacheSize int
+	// ResolvConfWatchInterval is the interval the host resolv.conf is
+	// polled at for changes when inotify is not available. Zero selects
+	// the default interval, a negative value disables the propagation
+	// of the host resolv.conf changes to the sandboxes.
+	ResolvConfWatchInterval time.Duration
+	// HostResolvConf is the host resolv.conf the nameservers of the
+	// containers are taken from. When not set, it is /etc/resolv.conf or,
+	// if that only points to the systemd-resolved stub listener, the
+	// resolv.conf systemd-resolved maintains.
+	HostResolvConf string
+	// ResolverRateLimit is the number of queries per second each client
+	// may send to the embedded resolver of a sandbox. The queries are not
+	// limited when it is zero, the default, or negative.
+	ResolverRateLimit int
+	// ResolverRateBurst is the number of queries a client may send at once
+	// to the embedded resolver. Zero selects twice the rate.
+	ResolverRateBurst int
 }
 
 // ClusterCfg represents cluster configuration
@@ -42,6 +65,23 @@ type ClusterCfg struct {
 	Heartbeat uint64
 }
 
+// NetworkDBCfg represents the tunables of the NetworkDB gossip cluster.
+// Zero values select the NetworkDB defaults.
+type NetworkDBCfg struct {
+	// Profile is the memberlist profile, "lan" or "wan"
+	Profile           string
+	GossipInterval    time.Duration
+	PushPullInterval  time.Duration
+	RetransmitMult    int
+	ReapInterval      time.Duration
+	TombstoneLifetime time.Duration
+	PacketBufferSize  int
+	// Labels are advertised to the other nodes of the cluster, in
+	// addition to the labels libnetwork sets, as the zone or the rack.
+	// They are fixed once the node joined the cluster.
+	Labels map[string]string
+}
+
 // LoadDefaultScopes loads default scope configs for scopes which
 // doesn't have explicit user specified configs.
 func (c *Config) LoadDefaultScopes(dataDir string) {
@@ -191,6 +231,47 @@ func OptionDiscoveryAddress(address string) Option {
 	}
 }
 
+// OptionNetworkDB function returns an option setter for the tunables of
+// the NetworkDB gossip cluster
+func OptionNetworkDB(cfg NetworkDBCfg) Option {
+	return func(c *Config) {
+		c.NetworkDB = cfg
+	}
+}
+
+// OptionResolverCacheSize function returns an option setter for the size
+// of the embedded DNS resolver response cache
+func OptionResolverCacheSize(size int) Option {
+	return func(c *Config) {
+		c.Daemon.ResolverCacheSize = size
+	}
+}
+
+// OptionResolverRateLimit function returns an option setter for the rate
+// and burst of queries each client may send to the embedded DNS resolver
+func OptionResolverRateLimit(rate, burst int) Option {
+	return func(c *Config) {
+		c.Daemon.ResolverRateLimit = rate
+		c.Daemon.ResolverRateBurst = burst
+	}
+}
+
+// OptionResolvConfWatchInterval function returns an option setter for the
+// interval the host resolv.conf is polled at for changes
+func OptionResolvConfWatchInterval(interval time.Duration) Option {
+	return func(c *Config) {
+		c.Daemon.ResolvConfWatchInterval = interval
+	}
+}
+
+// OptionHostResolvConf function returns an option setter for the host
+// resolv.conf the nameservers of the containers are taken from
+func OptionHostResolvConf(path string) Option {
+	return func(c *Config) {
+		c.Daemon.HostResolvConf = path
+	}
+}
+
 // OptionDataDir function returns an option setter for data folder
 func OptionDataDir(dataDir string) Option {
 	return func(c *Config) {
@@ -49,11 +49,13 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/discovery"
 	"github.com/docker/docker/pkg/plugins"
 	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/go-events"
 	"github.com/docker/libnetwork/cluster"
 	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
@@ -63,8 +65,10 @@ import (
 	"github.com/docker/libnetwork/hostdiscovery"
 	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/networkdb"
 	"github.com/docker/libnetwork/osl"
 	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
 )
 
 // NetworkController provides the interface for controller instance which manages
@@ -79,6 +83,10 @@ type NetworkController interface {
 	// Create a new network. The options parameter carries network specific options.
 	NewNetwork(networkType, name string, id string, options ...NetworkOption) (Network, error)
 
+	// NewNetworkWithContext is like NewNetwork but gives up and rolls back the
+	// network creation once ctx is done.
+	NewNetworkWithContext(ctx context.Context, networkType, name string, id string, options ...NetworkOption) (Network, error)
+
 	// Networks returns the list of Network(s) managed by this controller.
 	Networks() []Network
 
@@ -118,8 +126,21 @@ type NetworkController interface {
 	// Wait for agent initialization complete in libnetwork controller
 	AgentInitWait()
 
+	// NetworkDB returns the introspection interface of the gossip database
+	// of the cluster agent, nil if the agent is not running
+	NetworkDB() networkdb.Introspector
+
 	// SetKeys configures the encryption key for gossip and overlay data path
 	SetKeys(keys []*types.EncryptionKey) error
+
+	// Subscribe returns a channel of network, endpoint and sandbox lifecycle
+	// events matching the passed filter, and a function to cancel the subscription.
+	// The channel is closed once the subscription is canceled.
+	Subscribe(filter EventFilter) (<-chan Event, func())
+
+	// Reconcile compares the state recorded in the datastore with the state
+	// programmed in the kernel, repairing the differences unless dryRun is set
+	Reconcile(dryRun bool) (*ReconcileReport, error)
 }
 
 // NetworkWalker is a client provided function which will be used to walk the Networks.
@@ -136,6 +157,7 @@ type controller struct {
 	id                     string
 	drvRegistry            *drvregistry.DrvRegistry
 	sandboxes              sandboxTable
+	newSandboxKeys         map[string]struct{}
 	cfg                    *config.Config
 	stores                 []datastore.DataStore
 	discovery              hostdiscovery.HostDiscovery
@@ -152,6 +174,10 @@ type controller struct {
 	agentInitDone          chan struct{}
 	keys                   []*types.EncryptionKey
 	clusterConfigAvailable bool
+	eventBroadcaster       *events.Broadcaster
+	subscriptions          map[*eventSink]struct{}
+	dnsCache               *dnsCache
+	resolvConfStop         chan struct{}
 	sync.Mutex
 }
 
@@ -163,14 +189,19 @@ type initializer struct {
 // New creates a new instance of network controller.
 func New(cfgOptions ...config.Option) (NetworkController, error) {
 	c := &controller{
-		id:              stringid.GenerateRandomID(),
-		cfg:             config.ParseConfigOptions(cfgOptions...),
-		sandboxes:       sandboxTable{},
-		svcRecords:      make(map[string]svcInfo),
-		serviceBindings: make(map[serviceKey]*service),
-		agentInitDone:   make(chan struct{}),
+		id:               stringid.GenerateRandomID(),
+		cfg:              config.ParseConfigOptions(cfgOptions...),
+		sandboxes:        sandboxTable{},
+		newSandboxKeys:   make(map[string]struct{}),
+		svcRecords:       make(map[string]svcInfo),
+		serviceBindings:  make(map[serviceKey]*service),
+		agentInitDone:    make(chan struct{}),
+		eventBroadcaster: events.NewBroadcaster(),
+		subscriptions:    make(map[*eventSink]struct{}),
 	}
 
+	c.dnsCache = newDNSCache(c.cfg.Daemon.ResolverCacheSize)
+
 	if err := c.initStores(); err != nil {
 		return nil, err
 	}
@@ -179,6 +210,7 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 	if err != nil {
 		return nil, err
 	}
+	drvRegistry.SetClusterNodesFunc(c.clusterNodes)
 
 	for _, i := range getInitializers() {
 		var dcfg map[string]interface{}
@@ -224,6 +256,8 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 		return nil, err
 	}
 
+	c.startResolvConfWatch()
+
 	return c, nil
 }
 
@@ -356,6 +390,16 @@ func (c *controller) AgentInitWait() {
 	}
 }
 
+func (c *controller) NetworkDB() networkdb.Introspector {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.agent == nil {
+		return nil
+	}
+	return c.agent.networkDB
+}
+
 func (c *controller) makeDriverConfig(ntype string) map[string]interface{} {
 	if c.cfg == nil {
 		return nil
@@ -611,6 +655,25 @@ func (c *controller) RegisterDriver(networkType string, driver driverapi.Driver,
 // NewNetwork creates a new network of the specified network type. The options
 // are network specific and modeled in a generic way.
 func (c *controller) NewNetwork(networkType, name string, id string, options ...NetworkOption) (Network, error) {
+	return c.NewNetworkWithContext(context.Background(), networkType, name, id, options...)
+}
+
+// NewNetworkWithContext creates a new network like NewNetwork, abandoning
+// the driver and ipam calls once ctx is done.
+func (c *controller) NewNetworkWithContext(ctx context.Context, networkType, name string, id string, options ...NetworkOption) (Network, error) {
+	start := time.Now()
+
+	n, err := c.newNetwork(ctx, networkType, name, id, options...)
+	if err != nil {
+		networkCreateErrors.With(networkType).Inc()
+		return nil, err
+	}
+
+	networkCreateDuration.With(networkType).Since(start)
+	return n, nil
+}
+
+func (c *controller) newNetwork(ctx context.Context, networkType, name string, id string, options ...NetworkOption) (Network, error) {
 	if !config.IsValidName(name) {
 		return nil, ErrInvalidName(name)
 	}
@@ -633,6 +696,14 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 
 	network.processOptions(options...)
 
+	if err := network.validateDNSDomain(); err != nil {
+		return nil, err
+	}
+
+	if err := network.quota.validate(); err != nil {
+		return nil, err
+	}
+
 	_, cap, err := network.resolveDriver(networkType, true)
 	if err != nil {
 		return nil, err
@@ -653,7 +724,14 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 		return nil, err
 	}
 
-	err = network.ipamAllocate()
+	if network.dryRun {
+		if err := network.validate(); err != nil {
+			return nil, err
+		}
+		return network, nil
+	}
+
+	err = network.ipamAllocate(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -663,7 +741,7 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 		}
 	}()
 
-	err = c.addNetwork(network)
+	err = c.addNetwork(ctx, network)
 	if err != nil {
 		return nil, err
 	}
@@ -675,6 +753,10 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 		}
 	}()
 
+	if err = contextError(ctx); err != nil {
+		return nil, err
+	}
+
 	// First store the endpoint count, then the network. To avoid to
 	// end up with a datastore containing a network and not an epCnt,
 	// in case of an ungraceful shutdown during this function call.
@@ -697,6 +779,8 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 
 	joinCluster(network)
 
+	c.publishEvent(newNetworkEvent(EventNetworkCreate, network))
+
 	return network, nil
 }
 
@@ -741,7 +825,7 @@ func (c *controller) reservePools() {
 			}
 		}
 		// Reserve pools
-		if err := n.ipamAllocate(); err != nil {
+		if err := n.ipamAllocate(context.Background()); err != nil {
 			log.Warnf("Failed to allocate ipam pool(s) for network %q (%s): %v", n.Name(), n.ID(), err)
 		}
 		// Reserve existing endpoints' addresses
@@ -773,11 +857,12 @@ func doReplayPoolReserve(n *network) bool {
 	return caps.RequiresRequestReplay
 }
 
-func (c *controller) addNetwork(n *network) error {
+func (c *controller) addNetwork(ctx context.Context, n *network) error {
 	d, err := n.driver(true)
 	if err != nil {
 		return err
 	}
+	d = driverWithContext(ctx, d)
 
 	// Create the network
 	if err := d.CreateNetwork(n.id, n.generic, n, n.getIPData(4), n.getIPData(6)); err != nil {
@@ -932,13 +1017,22 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (s
 	}
 
 	if sb.osSbox == nil && !sb.config.useExternalKey {
+		// Mark the namespace as in use until the sandbox is added to the
+		// controller, so that Reconcile does not take it for an orphan.
+		c.Lock()
+		c.newSandboxKeys[sb.Key()] = struct{}{}
+		c.Unlock()
 		if sb.osSbox, err = osl.NewSandbox(sb.Key(), !sb.config.useDefaultSandBox, false); err != nil {
+			c.Lock()
+			delete(c.newSandboxKeys, sb.Key())
+			c.Unlock()
 			return nil, fmt.Errorf("failed to create new osl sandbox: %v", err)
 		}
 	}
 
 	c.Lock()
 	c.sandboxes[sb.id] = sb
+	delete(c.newSandboxKeys, sb.Key())
 	c.Unlock()
 	defer func() {
 		if err != nil {
@@ -1079,7 +1173,39 @@ func (c *controller) getIPAMDriver(name string) (ipamapi.Ipam, *ipamapi.Capabili
 	return id, cap, nil
 }
 
+// driverWithContext binds ctx to the driver if it supports cancellation.
+// Rollback paths must keep using the unbound driver, as ctx may be done.
+func driverWithContext(ctx context.Context, d driverapi.Driver) driverapi.Driver {
+	if cd, ok := d.(driverapi.ContextDriver); ok {
+		return cd.WithContext(ctx)
+	}
+	return d
+}
+
+// ipamWithContext binds ctx to the ipam driver if it supports cancellation
+func ipamWithContext(ctx context.Context, i ipamapi.Ipam) ipamapi.Ipam {
+	if ci, ok := i.(ipamapi.ContextIpam); ok {
+		return ci.WithContext(ctx)
+	}
+	return i
+}
+
+// contextError returns a non nil error if ctx is done, to abort an
+// operation between two of its steps
+func contextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return types.TimeoutErrorf("operation timed out")
+	default:
+		return ctx.Err()
+	}
+}
+
 func (c *controller) Stop() {
+	c.stopResolvConfWatch()
+	c.stopEvents()
 	c.closeStores()
 	c.stopExternalKeyListener()
 	osl.GC()
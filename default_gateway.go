@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -58,7 +59,7 @@ func (sb *sandbox) setupDefaultGW() error {
 	}
 	epLocal := newEp.(*endpoint)
 
-	if err := epLocal.sbJoin(sb); err != nil {
+	if err := epLocal.sbJoin(context.Background(), sb); err != nil {
 		return fmt.Errorf("container %s: endpoint join on GW Network failed: %v", sb.containerID, err)
 	}
 
@@ -72,7 +73,7 @@ func (sb *sandbox) clearDefaultGW() error {
 	if ep = sb.getEndpointInGWNetwork(); ep == nil {
 		return nil
 	}
-	if err := ep.sbLeave(sb, false); err != nil {
+	if err := ep.sbLeave(context.Background(), sb, false); err != nil {
 		return fmt.Errorf("container %s: endpoint leaving GW Network failed: %v", sb.containerID, err)
 	}
 	if err := ep.Delete(false); err != nil {
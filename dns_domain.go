@@ -0,0 +1,110 @@
+package libnetwork
+
+import (
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+)
+
+// NetworkOptionDNSDomain function returns an option setter for the DNS
+// domain of the network. The endpoints, aliases and services of the network
+// then also resolve as name.domain and the domain is added to the search
+// list of the attached containers.
+func NetworkOptionDNSDomain(domain string) NetworkOption {
+	return func(n *network) {
+		n.dnsDomain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	}
+}
+
+func (n *network) DNSDomain() string {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.dnsDomain
+}
+
+// validateDNSDomain returns a BadRequestError if the DNS domain of the
+// network is not a valid domain name or cannot be written to the
+// resolv.conf search list
+func (n *network) validateDNSDomain() error {
+	if n.dnsDomain == "" {
+		return nil
+	}
+	if _, ok := dns.IsDomainName(n.dnsDomain); !ok || strings.Contains(n.dnsDomain, "..") || strings.ContainsAny(n.dnsDomain, " \t\r\n") {
+		return types.BadRequestErrorf("invalid DNS domain %q for network %s", n.dnsDomain, n.name)
+	}
+	return nil
+}
+
+// dnsSuffix returns the suffix the names of the network resolve with, the
+// DNS domain if set, the network name otherwise
+func (n *network) dnsSuffix() string {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.dnsDomain != "" {
+		return n.dnsDomain
+	}
+	return n.name
+}
+
+// matchesDNSSuffix tells whether the suffix of a name selects the network
+func (n *network) matchesDNSSuffix(suffix string) bool {
+	n.Lock()
+	defer n.Unlock()
+
+	return suffix == n.name || (n.dnsDomain != "" && strings.EqualFold(suffix, n.dnsDomain))
+}
+
+// dnsSearchDomains returns the DNS domains of the connected networks, the
+// higher priority endpoint network first
+func (sb *sandbox) dnsSearchDomains() []string {
+	var domains []string
+	seen := make(map[string]bool)
+	for _, ep := range sb.getSortedEndpoints() {
+		if d := ep.getNetwork().DNSDomain(); d != "" && !seen[d] {
+			seen[d] = true
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// mergeSearchDomains returns the search list with the domains at its head,
+// dropping the domains previously added for the networks the sandbox left.
+// The domains which were in the search list regardless of the networks,
+// from the host resolv.conf or the sandbox options, are kept. It also
+// returns the domains of the merged list added for the networks.
+func mergeSearchDomains(domains, search, added []string) ([]string, []string) {
+	var present []string
+	merged := append([]string(nil), domains...)
+	for _, s := range search {
+		d := strings.ToLower(strings.TrimSuffix(s, "."))
+		if containsString(domains, d) {
+			present = append(present, d)
+			continue
+		}
+		if containsString(added, d) {
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	var nowAdded []string
+	for _, d := range domains {
+		if containsString(added, d) || !containsString(present, d) {
+			nowAdded = append(nowAdded, d)
+		}
+	}
+	return merged, nowAdded
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
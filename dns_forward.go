@@ -0,0 +1,77 @@
+package libnetwork
+
+// NetworkOptionDNSForwardRule function returns an option setter for a
+// conditional forwarding rule of the network: the embedded DNS server of the
+// containers attached to the network forwards the queries for the names
+// within domain to the passed servers.
+func NetworkOptionDNSForwardRule(domain string, servers []string) NetworkOption {
+	return func(n *network) {
+		if n.dnsForwardRules == nil {
+			n.dnsForwardRules = make(map[string][]string)
+		}
+		n.dnsForwardRules[domain] = servers
+	}
+}
+
+// OptionDNSForwardRule function returns an option setter for a conditional
+// forwarding rule of the container embedded DNS server: the queries for the
+// names within domain are forwarded to the passed servers. It overrides the
+// rule of the connected networks for the same domain.
+func OptionDNSForwardRule(domain string, servers []string) SandboxOption {
+	return func(sb *sandbox) {
+		if sb.config.dnsForwardRules == nil {
+			sb.config.dnsForwardRules = make(map[string][]string)
+		}
+		sb.config.dnsForwardRules[domain] = servers
+	}
+}
+
+func (n *network) DNSForwardRules() map[string][]string {
+	n.Lock()
+	defer n.Unlock()
+
+	return copyForwardRules(n.dnsForwardRules)
+}
+
+func copyForwardRules(rules map[string][]string) map[string][]string {
+	if rules == nil {
+		return nil
+	}
+	c := make(map[string][]string, len(rules))
+	for domain, servers := range rules {
+		c[domain] = append([]string(nil), servers...)
+	}
+	return c
+}
+
+// dnsForwardRules returns the conditional forwarding rules of the sandbox:
+// the ones of the connected networks, the higher priority endpoint network
+// first, and the sandbox ones on top
+func (sb *sandbox) dnsForwardRules() map[string][]string {
+	rules := make(map[string][]string)
+	for _, ep := range sb.getSortedEndpoints() {
+		for domain, servers := range ep.getNetwork().DNSForwardRules() {
+			if _, ok := rules[domain]; !ok {
+				rules[domain] = servers
+			}
+		}
+	}
+
+	sb.Lock()
+	for domain, servers := range sb.config.dnsForwardRules {
+		rules[domain] = append([]string(nil), servers...)
+	}
+	sb.Unlock()
+
+	return rules
+}
+
+// updateDNSForwardRules pushes the conditional forwarding rules to the
+// embedded DNS server, as they change when the sandbox joins or leaves a
+// network
+func (sb *sandbox) updateDNSForwardRules() {
+	if sb.resolver == nil {
+		return
+	}
+	sb.resolver.SetForwardRules(sb.dnsForwardRules())
+}
@@ -0,0 +1,228 @@
+package libnetwork
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+)
+
+// maxTXTStringLen is the maximum length of a character string in a TXT
+// record as defined in RFC 1035
+const maxTXTStringLen = 255
+
+// CreateOptionAliasCNAME function returns an option setter to have the
+// aliases of the endpoint resolve as CNAME records pointing to the
+// canonical endpoint name instead of as duplicate address records
+func CreateOptionAliasCNAME() EndpointOption {
+	return func(ep *endpoint) {
+		ep.aliasCNAME = true
+	}
+}
+
+// CreateOptionTXTRecords function returns an option setter for the TXT
+// records the embedded DNS server returns for the endpoint name, its
+// aliases and the service the endpoint belongs to
+func CreateOptionTXTRecords(txt ...string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.txtRecords = append(ep.txtRecords, txt...)
+	}
+}
+
+// txtNames returns the names the TXT records of the endpoint are served
+// for along with the records
+func (ep *endpoint) txtNames() ([]string, []string) {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if len(ep.txtRecords) == 0 {
+		return nil, nil
+	}
+
+	var names []string
+	if !ep.anonymous {
+		names = append(names, ep.name)
+	}
+	names = append(names, ep.myAliases...)
+	if ep.svcName != "" {
+		names = append(names, ep.svcName)
+		names = append(names, ep.svcAliases...)
+	}
+	return names, ep.txtRecords
+}
+
+func (n *network) addTXTRecords(ep *endpoint) {
+	names, txt := ep.txtNames()
+	if len(txt) == 0 {
+		return
+	}
+	epID := ep.ID()
+
+	c := n.getController()
+	c.Lock()
+	defer c.Unlock()
+	sr, ok := c.svcRecords[n.ID()]
+	if !ok {
+		sr = svcInfo{
+			svcMap:     make(map[string][]net.IP),
+			svcIPv6Map: make(map[string][]net.IP),
+			ipMap:      make(map[string]string),
+		}
+	}
+	if sr.txtMap == nil {
+		sr.txtMap = make(map[string]map[string][]string)
+	}
+	c.svcRecords[n.ID()] = sr
+
+	for _, name := range names {
+		if _, ok := sr.txtMap[name]; !ok {
+			sr.txtMap[name] = make(map[string][]string)
+		}
+		sr.txtMap[name][epID] = txt
+	}
+}
+
+func (n *network) deleteTXTRecords(ep *endpoint) {
+	names, txt := ep.txtNames()
+	if len(txt) == 0 {
+		return
+	}
+	epID := ep.ID()
+
+	c := n.getController()
+	c.Lock()
+	defer c.Unlock()
+	sr, ok := c.svcRecords[n.ID()]
+	if !ok || sr.txtMap == nil {
+		return
+	}
+
+	for _, name := range names {
+		delete(sr.txtMap[name], epID)
+		if len(sr.txtMap[name]) == 0 {
+			delete(sr.txtMap, name)
+		}
+	}
+}
+
+// splitDNSName returns the candidate name and network suffix pairs of a
+// name in the docker network domain. For a name a.b.c those are
+// {a.b.c, ""}, {a.b, c} and {a, b.c}
+func splitDNSName(name string) ([]string, []string) {
+	name = strings.TrimSuffix(name, ".")
+	reqName := []string{name}
+	networkName := []string{""}
+
+	for i := strings.LastIndex(name, "."); i != -1; i = strings.LastIndex(name[:i], ".") {
+		networkName = append(networkName, name[i+1:])
+		reqName = append(reqName, name[:i])
+	}
+	return reqName, networkName
+}
+
+// ResolveTXT returns the TXT records of an endpoint or service name
+func (sb *sandbox) ResolveTXT(name string) []string {
+	reqName, networkName := splitDNSName(name)
+	epList := sb.getConnectedEndpoints()
+
+	for i := 0; i < len(reqName); i++ {
+		for _, ep := range epList {
+			n := ep.getNetwork()
+			if networkName[i] != "" && !n.matchesDNSSuffix(networkName[i]) {
+				continue
+			}
+
+			c := n.getController()
+			c.Lock()
+			txt := collectTXT(c.svcRecords[n.ID()].txtMap[reqName[i]])
+			c.Unlock()
+			if len(txt) > 0 {
+				return txt
+			}
+		}
+	}
+	return nil
+}
+
+// collectTXT returns the TXT records of the endpoints in a stable order,
+// the records shared by several endpoints of a service only once
+func collectTXT(epTXT map[string][]string) []string {
+	var (
+		ids  []string
+		txt  []string
+		seen = make(map[string]bool)
+	)
+	for id := range epTXT {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		for _, t := range epTXT[id] {
+			if !seen[t] {
+				seen[t] = true
+				txt = append(txt, t)
+			}
+		}
+	}
+	return txt
+}
+
+// resolveCNAME returns the canonical name an alias points to, when the
+// endpoint owning the alias is set to resolve its aliases as CNAMEs
+func (sb *sandbox) resolveCNAME(name string) string {
+	reqName, networkName := splitDNSName(name)
+	epList := sb.getConnectedEndpoints()
+
+	for i := 0; i < len(reqName); i++ {
+		for _, ep := range epList {
+			n := ep.getNetwork()
+			if networkName[i] != "" && !n.matchesDNSSuffix(networkName[i]) {
+				continue
+			}
+
+			ep.Lock()
+			target, ok := ep.aliases[reqName[i]]
+			cname := ep.aliasCNAME
+			ep.Unlock()
+			if ok && cname {
+				return dns.Fqdn(target + "." + n.dnsSuffix())
+			}
+		}
+	}
+	return ""
+}
+
+// isLocalName tells whether the name is resolved by the embedded server
+func (sb *sandbox) isLocalName(name string) bool {
+	if ip, ipv6Miss := sb.ResolveName(name, types.IPv4); ip != nil || ipv6Miss {
+		return true
+	}
+	if ip, ipv6Miss := sb.ResolveName(name, types.IPv6); ip != nil || ipv6Miss {
+		return true
+	}
+	return sb.resolveCNAME(name) != "" || len(sb.ResolveTXT(name)) > 0
+}
+
+// inDNSDomain tells whether the name falls within the DNS domain of one
+// of the networks the sandbox is connected to
+func (sb *sandbox) inDNSDomain(name string) bool {
+	for _, d := range sb.dnsSearchDomains() {
+		if dns.IsSubDomain(dns.Fqdn(d), dns.Fqdn(strings.ToLower(name))) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTXT splits a TXT record into character strings of at most
+// maxTXTStringLen bytes
+func splitTXT(txt string) []string {
+	var strs []string
+	for len(txt) > maxTXTStringLen {
+		strs = append(strs, txt[:maxTXTStringLen])
+		txt = txt[maxTXTStringLen:]
+	}
+	return append(strs, txt)
+}
@@ -4,6 +4,7 @@ import (
 	"net"
 
 	"github.com/docker/libnetwork/discoverapi"
+	"golang.org/x/net/context"
 )
 
 // NetworkPluginEndpointType represents the Endpoint Type used by Plugin system
@@ -75,6 +76,37 @@ type Driver interface {
 	Type() string
 }
 
+// NetworkUpdater is an optional interface a driver can implement to accept
+// in-place updates of a network it manages. Drivers which do not implement
+// it only allow libnetwork level changes, such as labels, to be applied.
+type NetworkUpdater interface {
+	// UpdateNetwork invokes the driver method to update an existing
+	// network passing the network id, the new network specific config
+	// and the complete list of IPAM data, including any newly added
+	// pools. Returning an error rejects the update.
+	UpdateNetwork(nid string, options map[string]interface{}, ipV4Data, ipV6Data []IPAMData) error
+}
+
+// NetworkValidator is an optional interface a driver can implement to
+// validate a network configuration without creating the network. It backs
+// the dry-run mode of the network creation.
+type NetworkValidator interface {
+	// ValidateNetwork checks the network id, the network specific config
+	// and the IPAM data the network would be created with. No state must
+	// be changed or programmed.
+	ValidateNetwork(nid string, options map[string]interface{}, ipV4Data, ipV6Data []IPAMData) error
+}
+
+// ContextDriver is an optional interface a driver can implement when its
+// methods may block, as for the remote plugins. Before invoking the driver
+// on behalf of a cancellable operation, libnetwork binds the operation
+// context to it.
+type ContextDriver interface {
+	// WithContext returns a copy of the driver whose methods give up and
+	// return an error once ctx is done.
+	WithContext(ctx context.Context) Driver
+}
+
 // NetworkInfo provides a go interface for drivers to provide network
 // specific information to libnetwork.
 type NetworkInfo interface {
@@ -143,6 +175,23 @@ type DriverCallback interface {
 	RegisterDriver(name string, driver Driver, capability Capability) error
 }
 
+// ClusterNodesCallback is an optional interface the DriverCallback passed
+// to the drivers implements when it can tell about the nodes of the gossip
+// cluster libnetwork participates in.
+type ClusterNodesCallback interface {
+	// ClusterNodes returns the nodes of the cluster with the labels they
+	// advertise. It returns nil when this node is not part of a cluster.
+	// The labels of a node do not change while it is part of the cluster.
+	ClusterNodes() []ClusterNode
+}
+
+// ClusterNode describes a node of the gossip cluster
+type ClusterNode struct {
+	Name    string
+	Address string
+	Labels  map[string]string
+}
+
 // Capability represents the high level capabilities of the drivers which libnetwork can make use of
 type Capability struct {
 	DataScope string
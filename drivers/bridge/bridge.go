@@ -1,6 +1,7 @@
 package bridge
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -50,6 +51,9 @@ type configuration struct {
 	EnableIPForwarding  bool
 	EnableIPTables      bool
 	EnableUserlandProxy bool
+	// EnableLiveRestore leaves the userland proxies running when the
+	// daemon exits, so that they are adopted with the restored sandboxes
+	EnableLiveRestore bool
 }
 
 // networkConfiguration for network specific configuration
@@ -70,11 +74,13 @@ type networkConfiguration struct {
 	dbIndex            uint64
 	dbExists           bool
 	Internal           bool
+	Policy             *NetworkPolicy
 }
 
 // endpointConfiguration represents the user specified configuration for the sandbox endpoint
 type endpointConfiguration struct {
 	MacAddress net.HardwareAddr
+	Labels     map[string]string
 }
 
 // containerConfiguration represents the user specified configuration for a container
@@ -100,6 +106,7 @@ type bridgeEndpoint struct {
 	containerConfig *containerConfiguration
 	extConnConfig   *connectivityConfiguration
 	portMapping     []types.PortBinding // Operation port bindings
+	proxyPids       map[string]int      // Userland proxy pids, key: host transport address
 	dbIndex         uint64
 	dbExists        bool
 }
@@ -168,6 +175,12 @@ func (c *networkConfiguration) Validate() error {
 			return &ErrInvalidGateway{}
 		}
 	}
+
+	if c.Policy != nil {
+		if err := c.Policy.Validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -227,6 +240,11 @@ func (c *networkConfiguration) fromLabels(labels map[string]string) error {
 			if c.DefaultBindingIP = net.ParseIP(value); c.DefaultBindingIP == nil {
 				return parseErr(label, value, "nil ip")
 			}
+		case Policy:
+			c.Policy = &NetworkPolicy{}
+			if err = json.Unmarshal([]byte(value), c.Policy); err != nil {
+				return parseErr(label, value, err.Error())
+			}
 		}
 	}
 
@@ -582,6 +600,137 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}, nInfo d
 	return d.storeUpdate(config)
 }
 
+// ValidateNetwork checks the configuration of a bridge network without
+// creating it. The IPv4 data may be empty when the pool is yet to be chosen
+// by the IPAM driver.
+func (d *driver) ValidateNetwork(id string, option map[string]interface{}, ipV4Data, ipV6Data []driverapi.IPAMData) error {
+	d.Lock()
+	if _, ok := d.networks[id]; ok {
+		d.Unlock()
+		return types.ForbiddenErrorf("network %s exists", id)
+	}
+	d.Unlock()
+
+	config, err := parseNetworkOptions(id, option)
+	if err != nil {
+		return err
+	}
+
+	if len(ipV4Data) > 0 {
+		if err := config.processIPAM(id, ipV4Data, ipV6Data); err != nil {
+			return err
+		}
+		if config.AddressIPv4 == nil {
+			config.AddressIPv4 = ipV4Data[0].Pool
+		}
+	}
+
+	for _, nw := range d.getNetworks() {
+		nw.Lock()
+		nwConfig := nw.config
+		nw.Unlock()
+		if err := nwConfig.Conflicts(config); err != nil {
+			return types.ForbiddenErrorf("cannot create network %s (%s): conflicts with network %s (%s): %s",
+				config.ID, config.BridgeName, nwConfig.ID, nwConfig.BridgeName, err.Error())
+		}
+	}
+
+	// The pools of a new bridge become host routes, they must not conflict
+	// with the existing ones. An existing bridge already owns its routes.
+	if config.BridgeName != "" {
+		if _, err := ns.NlHandle().LinkByName(config.BridgeName); err == nil {
+			return nil
+		}
+	}
+	for _, ipd := range ipV4Data {
+		if err := netutils.CheckRouteOverlaps(ipd.Pool); err != nil {
+			return types.ForbiddenErrorf("pool %s conflicts with the host routes: %v", ipd.Pool, err)
+		}
+	}
+
+	return nil
+}
+
+// UpdateNetwork applies a new policy to an existing network. The other
+// network options cannot be changed.
+func (d *driver) UpdateNetwork(id string, option map[string]interface{}, ipV4Data, ipV6Data []driverapi.IPAMData) error {
+	n, err := d.getNetwork(id)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	oldConfig := n.config
+	n.Unlock()
+
+	// The complete IPAM data of the network is passed, only the pools
+	// the network was not created with are rejected
+	if oldConfig.addsIPAMData(id, ipV4Data, ipV6Data) {
+		return types.NotImplementedErrorf("bridge driver does not support adding subnets to network %s", id)
+	}
+
+	config, err := parseNetworkOptions(id, option)
+	if err != nil {
+		return err
+	}
+
+	if !oldConfig.equalOptions(config) {
+		return types.ForbiddenErrorf("only the policy of bridge network %s can be updated", id)
+	}
+
+	newConfig := *oldConfig
+	newConfig.Policy = config.Policy
+
+	n.Lock()
+	n.config = &newConfig
+	n.Unlock()
+
+	d.Lock()
+	enabled := d.config.EnableIPTables
+	d.Unlock()
+
+	if enabled {
+		if err = n.programPolicy(); err != nil {
+			n.Lock()
+			n.config = oldConfig
+			n.Unlock()
+			d.updatePolicy(n)
+			return err
+		}
+	}
+
+	return d.storeUpdate(&newConfig)
+}
+
+// addsIPAMData tells whether the IPAM data passed on update differs from
+// the IPAM data the network was created with
+func (c *networkConfiguration) addsIPAMData(id string, ipV4Data, ipV6Data []driverapi.IPAMData) bool {
+	if len(ipV4Data) == 0 && len(ipV6Data) == 0 {
+		return false
+	}
+
+	nc := &networkConfiguration{}
+	if err := nc.processIPAM(id, ipV4Data, ipV6Data); err != nil {
+		return true
+	}
+
+	return !types.CompareIPNet(nc.AddressIPv4, c.AddressIPv4) ||
+		(len(ipV6Data) > 0 && !types.CompareIPNet(nc.AddressIPv6, c.AddressIPv6))
+}
+
+// equalOptions tells whether the user configurable options of the two
+// configurations, other than the policy, are the same
+func (c *networkConfiguration) equalOptions(o *networkConfiguration) bool {
+	return c.BridgeName == o.BridgeName &&
+		c.EnableIPv6 == o.EnableIPv6 &&
+		c.EnableIPMasquerade == o.EnableIPMasquerade &&
+		c.EnableICC == o.EnableICC &&
+		c.Mtu == o.Mtu &&
+		c.DefaultBindingIP.Equal(o.DefaultBindingIP) &&
+		c.DefaultBridge == o.DefaultBridge &&
+		c.Internal == o.Internal
+}
+
 func (d *driver) createNetwork(config *networkConfiguration) error {
 	var err error
 
@@ -619,6 +768,7 @@ func (d *driver) createNetwork(config *networkConfiguration) error {
 		portMapper: portmapper.New(),
 		driver:     d,
 	}
+	network.portMapper.KeepProxies = d.config.EnableLiveRestore
 
 	d.Lock()
 	d.networks[config.ID] = network
@@ -705,6 +855,9 @@ func (d *driver) createNetwork(config *networkConfiguration) error {
 		// Setup IPTables.
 		{d.config.EnableIPTables, network.setupIPTables},
 
+		// Setup the network policy chain.
+		{d.config.EnableIPTables, network.setupPolicy},
+
 		//We want to track firewalld configuration so that
 		//if it is started/reloaded, the rules can be applied correctly
 		{d.config.EnableIPTables, network.setupFirewalld},
@@ -1065,6 +1218,8 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 	delete(n.endpoints, eid)
 	n.Unlock()
 
+	d.updatePolicy(n)
+
 	// On failure make sure to set back ep in n.endpoints, but only
 	// if it hasn't been taken over already by some other thread.
 	defer func() {
@@ -1185,6 +1340,8 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		return err
 	}
 
+	d.updatePolicy(network)
+
 	return nil
 }
 
@@ -1242,9 +1399,11 @@ func (d *driver) ProgramExternalConnectivity(nid, eid string, options map[string
 	if err != nil {
 		return err
 	}
+	endpoint.proxyPids = network.proxyPids(endpoint.portMapping)
 
 	if err = d.storeUpdate(endpoint); err != nil {
 		endpoint.portMapping = nil
+		endpoint.proxyPids = nil
 		return fmt.Errorf("failed to update bridge endpoint %s to store: %v", endpoint.id[0:7], err)
 	}
 
@@ -1277,6 +1436,13 @@ func (d *driver) RevokeExternalConnectivity(nid, eid string) error {
 		logrus.Warn(err)
 	}
 
+	// Do not restore the released bindings on restart
+	endpoint.portMapping = nil
+	endpoint.proxyPids = nil
+	if err = d.storeUpdate(endpoint); err != nil {
+		return fmt.Errorf("failed to update bridge endpoint %s to store: %v", endpoint.id[0:7], err)
+	}
+
 	return nil
 }
 
@@ -1387,6 +1553,14 @@ func parseEndpointOptions(epOptions map[string]interface{}) (*endpointConfigurat
 		}
 	}
 
+	if opt, ok := epOptions[netlabel.EndpointLabels]; ok {
+		if labels, ok := opt.(map[string]string); ok {
+			ec.Labels = labels
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
 	return ec, nil
 }
 
@@ -84,6 +84,11 @@ func (d *driver) populateEndpoints() error {
 		if !ok {
 			logrus.Debugf("Network (%s) not found for restored bridge endpoint (%s)", ep.nid[0:7], ep.id[0:7])
 			logrus.Debugf("Deleting stale bridge endpoint (%s) from store", ep.id[0:7])
+			for _, b := range ep.portMapping {
+				if err := stopProxy(b, ep.proxyPids); err != nil {
+					logrus.Warnf("Failed to stop userland proxy of port mapping %s for stale endpoint %s: %v", b.String(), ep.id[0:7], err)
+				}
+			}
 			if err := d.storeDelete(ep); err != nil {
 				logrus.Debugf("Failed to delete stale bridge endpoint (%s) from store", ep.id[0:7])
 			}
@@ -94,6 +99,10 @@ func (d *driver) populateEndpoints() error {
 		logrus.Debugf("Endpoint (%s) restored to network (%s)", ep.id[0:7], ep.nid[0:7])
 	}
 
+	for _, n := range d.networks {
+		d.updatePolicy(n)
+	}
+
 	return nil
 }
 
@@ -152,6 +161,10 @@ func (ncfg *networkConfiguration) MarshalJSON() ([]byte, error) {
 		nMap["AddressIPv6"] = ncfg.AddressIPv6.String()
 	}
 
+	if ncfg.Policy != nil {
+		nMap["Policy"] = ncfg.Policy
+	}
+
 	return json.Marshal(nMap)
 }
 
@@ -190,6 +203,12 @@ func (ncfg *networkConfiguration) UnmarshalJSON(b []byte) error {
 	if v, ok := nMap["Internal"]; ok {
 		ncfg.Internal = v.(bool)
 	}
+	if v, ok := nMap["Policy"]; ok {
+		d, _ := json.Marshal(v)
+		if err := json.Unmarshal(d, &ncfg.Policy); err != nil {
+			logrus.Warnf("Failed to decode bridge network policy %v", err)
+		}
+	}
 
 	return nil
 }
@@ -259,6 +278,9 @@ func (ep *bridgeEndpoint) MarshalJSON() ([]byte, error) {
 	epMap["ContainerConfig"] = ep.containerConfig
 	epMap["ExternalConnConfig"] = ep.extConnConfig
 	epMap["PortMapping"] = ep.portMapping
+	if len(ep.proxyPids) != 0 {
+		epMap["ProxyPids"] = ep.proxyPids
+	}
 
 	return json.Marshal(epMap)
 }
@@ -307,6 +329,12 @@ func (ep *bridgeEndpoint) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(d, &ep.portMapping); err != nil {
 		logrus.Warnf("Failed to decode endpoint port mapping %v", err)
 	}
+	if v, ok := epMap["ProxyPids"]; ok {
+		d, _ = json.Marshal(v)
+		if err := json.Unmarshal(d, &ep.proxyPids); err != nil {
+			logrus.Warnf("Failed to decode endpoint userland proxy pids %v", err)
+		}
+	}
 
 	return nil
 }
@@ -362,17 +390,31 @@ func (ep *bridgeEndpoint) DataScope() string {
 	return datastore.LocalScope
 }
 
+// restorePortAllocations reserves again the host ports of the endpoint
+// bindings and re-adopts or respawns their userland proxies. A binding which
+// cannot be restored is dropped from the endpoint and does not prevent the
+// others from being restored.
 func (n *bridgeNetwork) restorePortAllocations(ep *bridgeEndpoint) {
-	if ep.extConnConfig == nil ||
-		ep.extConnConfig.ExposedPorts == nil ||
-		ep.extConnConfig.PortBindings == nil {
+	if len(ep.portMapping) == 0 {
 		return
 	}
-	tmp := ep.extConnConfig.PortBindings
-	ep.extConnConfig.PortBindings = ep.portMapping
-	_, err := n.allocatePorts(ep, n.config.DefaultBindingIP, n.driver.config.EnableUserlandProxy)
-	if err != nil {
-		logrus.Warnf("Failed to reserve existing port mapping for endpoint %s:%v", ep.id[0:7], err)
+
+	ulPxyEnabled := n.driver.config.EnableUserlandProxy
+	restored := make([]types.PortBinding, 0, len(ep.portMapping))
+	for _, b := range ep.portMapping {
+		if err := n.restorePort(b, ep.proxyPids, ulPxyEnabled); err != nil {
+			logrus.Warnf("Failed to reserve existing port mapping %s for endpoint %s: %v", b.String(), ep.id[0:7], err)
+			if err := stopProxy(b, ep.proxyPids); err != nil {
+				logrus.Warnf("Failed to stop userland proxy of port mapping %s for endpoint %s: %v", b.String(), ep.id[0:7], err)
+			}
+			continue
+		}
+		restored = append(restored, b)
+	}
+	ep.portMapping = restored
+
+	ep.proxyPids = n.proxyPids(ep.portMapping)
+	if err := n.driver.storeUpdate(ep); err != nil {
+		logrus.Warnf("Failed to update bridge endpoint %s to store: %v", ep.id[0:7], err)
 	}
-	ep.extConnConfig.PortBindings = tmp
 }
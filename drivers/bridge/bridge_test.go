@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"reflect"
 	"regexp"
 	"testing"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
 )
 
 func init() {
@@ -73,6 +75,7 @@ func TestEndpointMarshalling(t *testing.T) {
 				HostPortEnd: uint16(55000),
 			},
 		},
+		proxyPids: map[string]int{"udp/10.10.100.2:9900": 1234, "tcp/10.11.100.2:5500": 5678},
 	}
 
 	b, err := json.Marshal(e)
@@ -91,7 +94,8 @@ func TestEndpointMarshalling(t *testing.T) {
 		!compareEpConfig(e.config, ee.config) ||
 		!compareContainerConfig(e.containerConfig, ee.containerConfig) ||
 		!compareConnConfig(e.extConnConfig, ee.extConnConfig) ||
-		!compareBindings(e.portMapping, ee.portMapping) {
+		!compareBindings(e.portMapping, ee.portMapping) ||
+		!reflect.DeepEqual(e.proxyPids, ee.proxyPids) {
 		t.Fatalf("JSON marsh/unmarsh failed.\nOriginal:\n%#v\nDecoded:\n%#v", e, ee)
 	}
 }
@@ -397,6 +401,68 @@ func TestCreateFail(t *testing.T) {
 	}
 }
 
+func TestValidateNetwork(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	d := newDriver()
+
+	if err := d.configure(nil); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	ipdList := getIPv4Data(t)
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = &networkConfiguration{BridgeName: "validate_test_1"}
+
+	if err := d.ValidateNetwork("1", genericOption, ipdList, nil); err != nil {
+		t.Fatalf("Validation of a new network failed: %v", err)
+	}
+	if _, ok := d.networks["1"]; ok {
+		t.Fatal("Validation must not create the network")
+	}
+
+	if err := d.CreateNetwork("1", genericOption, nil, ipdList, nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	err := d.ValidateNetwork("1", genericOption, ipdList, nil)
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Expected forbidden error for an existing network id, got: %v", err)
+	}
+
+	genericOption[netlabel.GenericData] = &networkConfiguration{BridgeName: "validate_test_2"}
+	err = d.ValidateNetwork("2", genericOption, ipdList, nil)
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Expected forbidden error for an overlapping subnet, got: %v", err)
+	}
+
+	genericOption[netlabel.GenericData] = &networkConfiguration{BridgeName: "validate_test_2"}
+	if err := d.ValidateNetwork("2", genericOption, nil, nil); err != nil {
+		t.Fatalf("Validation without IPAM data failed: %v", err)
+	}
+
+	// A pool conflicting with a host route
+	link := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "validate_route"}}
+	if err := netlink.LinkAdd(link); err != nil {
+		t.Fatal(err)
+	}
+	defer netlink.LinkDel(link)
+	addr, _ := netlink.ParseAddr("10.199.1.1/24")
+	if err := netlink.AddrAdd(link, addr); err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		t.Fatal(err)
+	}
+	_, pool, _ := net.ParseCIDR("10.199.0.0/16")
+	err = d.ValidateNetwork("2", genericOption, []driverapi.IPAMData{{AddressSpace: "full", Pool: pool}}, nil)
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Expected forbidden error for a pool conflicting with the host routes, got: %v", err)
+	}
+}
+
 func TestCreateMultipleNetworks(t *testing.T) {
 	if !testutils.IsRunningInContainer() {
 		defer testutils.SetupTestOSContext(t)()
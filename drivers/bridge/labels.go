@@ -15,4 +15,7 @@ const (
 
 	// DefaultBridge label
 	DefaultBridge = "com.docker.network.bridge.default_bridge"
+
+	// Policy label carries the JSON encoded network policy
+	Policy = "com.docker.network.bridge.policy"
 )
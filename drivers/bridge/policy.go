@@ -0,0 +1,218 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+// PolicyChainPrefix is the prefix of the per network iptables chain
+// enforcing the network policy
+const PolicyChainPrefix = "DOCKER-POLICY-"
+
+// PolicyAction is the verdict applied to the traffic matched by a policy rule
+type PolicyAction string
+
+const (
+	// PolicyAllow lets the matched traffic through
+	PolicyAllow PolicyAction = "allow"
+	// PolicyDeny drops the matched traffic
+	PolicyDeny PolicyAction = "deny"
+)
+
+// PolicyRule allows or denies the traffic from the endpoints selected by
+// From to the endpoints selected by To. A selector matches the endpoints
+// carrying all of its labels, an empty selector matches all the endpoints.
+// An empty Ports list matches all the ports.
+type PolicyRule struct {
+	Action PolicyAction
+	From   map[string]string
+	To     map[string]string
+	Ports  []types.TransportPort
+}
+
+// NetworkPolicy controls the traffic between the endpoints of a bridge
+// network. Rules are evaluated in order and the first match wins. Traffic
+// not matched by any rule is subject to DefaultAction; when it is not deny,
+// the network ICC setting applies.
+type NetworkPolicy struct {
+	DefaultAction PolicyAction
+	Rules         []PolicyRule
+}
+
+// Validate checks the policy actions and ports
+func (p *NetworkPolicy) Validate() error {
+	if err := p.DefaultAction.validate(true); err != nil {
+		return err
+	}
+	for i, r := range p.Rules {
+		if err := r.Action.validate(false); err != nil {
+			return types.BadRequestErrorf("invalid policy rule %d: %v", i, err)
+		}
+		for _, port := range r.Ports {
+			if port.Proto != types.TCP && port.Proto != types.UDP {
+				return types.BadRequestErrorf("invalid policy rule %d: unsupported protocol %s", i, port.Proto)
+			}
+			if port.Port == 0 {
+				return types.BadRequestErrorf("invalid policy rule %d: invalid port 0", i)
+			}
+		}
+	}
+	return nil
+}
+
+func (a PolicyAction) validate(allowEmpty bool) error {
+	switch a {
+	case PolicyAllow, PolicyDeny:
+		return nil
+	case "":
+		if allowEmpty {
+			return nil
+		}
+	}
+	return types.BadRequestErrorf("invalid policy action %q", a)
+}
+
+func (a PolicyAction) target() string {
+	if a == PolicyDeny {
+		return "DROP"
+	}
+	return "ACCEPT"
+}
+
+func selectorMatches(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func policyChainName(nid string) string {
+	if len(nid) > 12 {
+		nid = nid[:12]
+	}
+	return PolicyChainPrefix + nid
+}
+
+func policyJumpRule(bridgeName, chain string) iptRule {
+	return iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeName, "-o", bridgeName, "-j", chain}}
+}
+
+// policyRules compiles the policy into the list of rules of the policy chain,
+// given the endpoints currently on the network.
+func policyRules(policy *NetworkPolicy, endpoints []*bridgeEndpoint) [][]string {
+	// Established flows are accepted by the generic FORWARD rules
+	rules := [][]string{{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"}}
+
+	for _, r := range policy.Rules {
+		for _, src := range endpoints {
+			if !selectorMatches(r.From, src.labels()) {
+				continue
+			}
+			for _, dst := range endpoints {
+				if src == dst || !selectorMatches(r.To, dst.labels()) {
+					continue
+				}
+				args := []string{"-s", src.addr.IP.String(), "-d", dst.addr.IP.String()}
+				if len(r.Ports) == 0 {
+					rules = append(rules, append(args, "-j", r.Action.target()))
+					continue
+				}
+				for _, p := range r.Ports {
+					pargs := append([]string{}, args...)
+					pargs = append(pargs, "-p", p.Proto.String(), "--dport", strconv.Itoa(int(p.Port)), "-j", r.Action.target())
+					rules = append(rules, pargs)
+				}
+			}
+		}
+	}
+
+	if policy.DefaultAction == PolicyDeny {
+		rules = append(rules, []string{"-j", "DROP"})
+	}
+
+	return rules
+}
+
+func (ep *bridgeEndpoint) labels() map[string]string {
+	if ep.config == nil {
+		return nil
+	}
+	return ep.config.Labels
+}
+
+// programPolicy rebuilds the policy chain of the network from the current
+// policy and endpoints. It removes the chain if the network has no policy.
+func (n *bridgeNetwork) programPolicy() error {
+	n.Lock()
+	config := n.config
+	endpoints := make([]*bridgeEndpoint, 0, len(n.endpoints))
+	for _, ep := range n.endpoints {
+		if ep.addr != nil {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	n.Unlock()
+
+	chain := policyChainName(n.id)
+	if config.Policy == nil {
+		return removePolicyChain(config.BridgeName, chain)
+	}
+
+	if _, err := iptables.NewChain(chain, iptables.Filter, false); err != nil {
+		return fmt.Errorf("failed to create policy chain %s: %v", chain, err)
+	}
+
+	if err := iptables.RawCombinedOutput("-F", chain); err != nil {
+		return fmt.Errorf("failed to flush policy chain %s: %v", chain, err)
+	}
+
+	for _, args := range policyRules(config.Policy, endpoints) {
+		if err := iptables.RawCombinedOutput(append([]string{"-A", chain}, args...)...); err != nil {
+			return fmt.Errorf("failed to program policy chain %s: %v", chain, err)
+		}
+	}
+
+	return programChainRule(policyJumpRule(config.BridgeName, chain), "POLICY", true)
+}
+
+func removePolicyChain(bridgeName, chain string) error {
+	if !iptables.ExistChain(chain, iptables.Filter) {
+		return nil
+	}
+	if err := programChainRule(policyJumpRule(bridgeName, chain), "POLICY", false); err != nil {
+		return err
+	}
+	return iptables.RemoveExistingChain(chain, iptables.Filter)
+}
+
+func (n *bridgeNetwork) setupPolicy(config *networkConfiguration, i *bridgeInterface) error {
+	if err := n.programPolicy(); err != nil {
+		return err
+	}
+	n.registerIptCleanFunc(func() error {
+		return removePolicyChain(config.BridgeName, policyChainName(n.id))
+	})
+	return nil
+}
+
+// updatePolicy reprograms the network policy, if iptables are enabled, after
+// the endpoints or the policy of the network changed.
+func (d *driver) updatePolicy(n *bridgeNetwork) {
+	d.Lock()
+	enabled := d.config.EnableIPTables
+	d.Unlock()
+
+	if !enabled {
+		return
+	}
+
+	if err := n.programPolicy(); err != nil {
+		logrus.Warnf("Failed to program policy for bridge network %s: %v", n.id, err)
+	}
+}
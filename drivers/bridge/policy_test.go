@@ -0,0 +1,120 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func newPolicyEndpoint(ip string, labels map[string]string) *bridgeEndpoint {
+	return &bridgeEndpoint{
+		addr:   &net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(16, 32)},
+		config: &endpointConfiguration{Labels: labels},
+	}
+}
+
+func TestPolicyRules(t *testing.T) {
+	web := newPolicyEndpoint("172.20.0.2", map[string]string{"tier": "web", "tenant": "a"})
+	db := newPolicyEndpoint("172.20.0.3", map[string]string{"tier": "db", "tenant": "a"})
+	other := newPolicyEndpoint("172.20.0.4", map[string]string{"tenant": "b"})
+
+	policy := &NetworkPolicy{
+		DefaultAction: PolicyDeny,
+		Rules: []PolicyRule{
+			{
+				Action: PolicyAllow,
+				From:   map[string]string{"tier": "web"},
+				To:     map[string]string{"tier": "db"},
+				Ports:  []types.TransportPort{{Proto: types.TCP, Port: 5432}},
+			},
+			{
+				Action: PolicyDeny,
+				From:   map[string]string{"tenant": "b"},
+			},
+		},
+	}
+
+	expected := [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-s", "172.20.0.2", "-d", "172.20.0.3", "-p", "tcp", "--dport", "5432", "-j", "ACCEPT"},
+		{"-s", "172.20.0.4", "-d", "172.20.0.2", "-j", "DROP"},
+		{"-s", "172.20.0.4", "-d", "172.20.0.3", "-j", "DROP"},
+		{"-j", "DROP"},
+	}
+
+	rules := policyRules(policy, []*bridgeEndpoint{web, db, other})
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("Unexpected policy rules.\nExpected: %v\nGot: %v", expected, rules)
+	}
+
+	// Without a deny default action, unmatched traffic falls back to ICC
+	policy.DefaultAction = ""
+	rules = policyRules(policy, []*bridgeEndpoint{web, db, other})
+	if !reflect.DeepEqual(rules, expected[:len(expected)-1]) {
+		t.Fatalf("Unexpected policy rules.\nExpected: %v\nGot: %v", expected[:len(expected)-1], rules)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	valid := &NetworkPolicy{
+		Rules: []PolicyRule{{Action: PolicyAllow, Ports: []types.TransportPort{{Proto: types.UDP, Port: 53}}}},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Unexpected error for valid policy: %v", err)
+	}
+
+	for _, p := range []*NetworkPolicy{
+		{DefaultAction: "reject"},
+		{Rules: []PolicyRule{{}}},
+		{Rules: []PolicyRule{{Action: PolicyAllow, Ports: []types.TransportPort{{Proto: types.ICMP, Port: 1}}}}},
+		{Rules: []PolicyRule{{Action: PolicyDeny, Ports: []types.TransportPort{{Proto: types.TCP}}}}},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Fatalf("Expected failure for invalid policy %v", p)
+		} else if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Unexpected error type for invalid policy %v: %v", p, err)
+		}
+	}
+}
+
+func TestPolicyFromLabels(t *testing.T) {
+	labels := map[string]string{
+		Policy: `{"DefaultAction":"deny","Rules":[{"Action":"allow","From":{"app":"a"},"To":{"app":"b"},"Ports":[{"Proto":6,"Port":80}]}]}`,
+	}
+
+	config := &networkConfiguration{}
+	if err := config.fromLabels(labels); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &NetworkPolicy{
+		DefaultAction: PolicyDeny,
+		Rules: []PolicyRule{{
+			Action: PolicyAllow,
+			From:   map[string]string{"app": "a"},
+			To:     map[string]string{"app": "b"},
+			Ports:  []types.TransportPort{{Proto: types.TCP, Port: 80}},
+		}},
+	}
+	if !reflect.DeepEqual(config.Policy, expected) {
+		t.Fatalf("Unexpected policy. Expected: %v, Got: %v", expected, config.Policy)
+	}
+
+	labels[Policy] = "{"
+	if err := config.fromLabels(labels); err == nil {
+		t.Fatalf("Expected failure for malformed policy label")
+	}
+}
+
+func TestPolicyChainName(t *testing.T) {
+	chain := policyChainName("0123456789abcdef0123")
+	if chain != "DOCKER-POLICY-0123456789ab" {
+		t.Fatalf("Unexpected policy chain name: %s", chain)
+	}
+	// iptables chain names are limited to 28 characters
+	if len(chain) > 28 {
+		t.Fatalf("Policy chain name %s is too long", chain)
+	}
+}
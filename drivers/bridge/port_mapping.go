@@ -7,6 +7,7 @@ import (
 	"net"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/portmapper"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -126,3 +127,53 @@ func (n *bridgeNetwork) releasePort(bnd types.PortBinding) error {
 	}
 	return n.portMapper.Unmap(host)
 }
+
+// restorePort maps again a binding which was in place before a restart,
+// adopting its userland proxy when it is still running
+func (n *bridgeNetwork) restorePort(bnd types.PortBinding, proxyPids map[string]int, ulPxyEnabled bool) error {
+	container, err := bnd.ContainerAddr()
+	if err != nil {
+		return err
+	}
+	host, err := bnd.HostAddr()
+	if err != nil {
+		return err
+	}
+	return n.portMapper.Restore(container, bnd.HostIP, int(bnd.HostPort), ulPxyEnabled, proxyPids[proxyKey(host)])
+}
+
+// stopProxy stops the userland proxy started for a binding before a
+// restart, when the binding is not restored
+func stopProxy(bnd types.PortBinding, proxyPids map[string]int) error {
+	container, err := bnd.ContainerAddr()
+	if err != nil {
+		return err
+	}
+	host, err := bnd.HostAddr()
+	if err != nil {
+		return err
+	}
+	return portmapper.StopProxy(container, bnd.HostIP, int(bnd.HostPort), proxyPids[proxyKey(host)])
+}
+
+// proxyPids returns the pids of the userland proxies serving the bindings
+func (n *bridgeNetwork) proxyPids(bindings []types.PortBinding) map[string]int {
+	var pids map[string]int
+	for _, b := range bindings {
+		host, err := b.HostAddr()
+		if err != nil {
+			continue
+		}
+		if pid := n.portMapper.ProxyPid(host); pid != 0 {
+			if pids == nil {
+				pids = make(map[string]int)
+			}
+			pids[proxyKey(host)] = pid
+		}
+	}
+	return pids
+}
+
+func proxyKey(host net.Addr) string {
+	return host.Network() + "/" + host.String()
+}
@@ -1,11 +1,13 @@
 package bridge
 
 import (
+	"net"
 	"os"
 	"testing"
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/portmapper"
 	"github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
 )
@@ -92,3 +94,32 @@ func TestPortMappingConfig(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRestorePortAllocationsDropsFailedBindings(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	n := &bridgeNetwork{
+		portMapper: portmapper.New(),
+		driver:     newDriver(),
+	}
+
+	// The host port of the first binding was taken while the daemon was down
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	taken := uint16(l.Addr().(*net.TCPAddr).Port)
+
+	ip := net.ParseIP("127.0.0.1")
+	failed := types.PortBinding{Proto: types.TCP, IP: net.ParseIP("172.17.0.2"), Port: 80, HostIP: ip, HostPort: taken}
+	good := types.PortBinding{Proto: types.UDP, IP: net.ParseIP("172.17.0.2"), Port: 53, HostIP: ip, HostPort: 54053}
+	ep := &bridgeEndpoint{id: "restoredendpoint", portMapping: []types.PortBinding{failed, good}}
+
+	n.restorePortAllocations(ep)
+	defer n.releasePorts(ep)
+
+	if len(ep.portMapping) != 1 || !ep.portMapping[0].Equal(&good) {
+		t.Fatalf("Expected only the restored binding to be kept, got %v", ep.portMapping)
+	}
+}
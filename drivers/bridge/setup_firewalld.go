@@ -1,6 +1,9 @@
 package bridge
 
-import "github.com/docker/libnetwork/iptables"
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/iptables"
+)
 
 func (n *bridgeNetwork) setupFirewalld(config *networkConfiguration, i *bridgeInterface) error {
 	d := n.driver
@@ -15,6 +18,16 @@ func (n *bridgeNetwork) setupFirewalld(config *networkConfiguration, i *bridgeIn
 
 	iptables.OnReloaded(func() { n.setupIPTables(config, i) })
 	iptables.OnReloaded(n.portMapper.ReMapAll)
+	// The policy chain is rebuilt from the current policy and endpoints,
+	// as long as the network exists
+	iptables.OnReloaded(func() {
+		if _, err := d.getNetwork(n.id); err != nil {
+			return
+		}
+		if err := n.programPolicy(); err != nil {
+			logrus.Warnf("Failed to program policy for bridge network %s on firewall reload: %v", n.id, err)
+		}
+	})
 
 	return nil
 }
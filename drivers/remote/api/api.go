@@ -45,6 +45,24 @@ type CreateNetworkResponse struct {
 	Response
 }
 
+// UpdateNetworkRequest requests an in-place update of an existing network.
+type UpdateNetworkRequest struct {
+	// The ID of the network to update.
+	NetworkID string
+
+	// The complete set of network options after the update.
+	Options map[string]interface{}
+
+	// IPAMData contains the address pool information for this network,
+	// including any newly added pools
+	IPv4Data, IPv6Data []driverapi.IPAMData
+}
+
+// UpdateNetworkResponse is the response to the UpdateNetworkRequest.
+type UpdateNetworkResponse struct {
+	Response
+}
+
 // DeleteNetworkRequest is the request to delete an existing network.
 type DeleteNetworkRequest struct {
 	// The ID of the network to delete.
@@ -3,6 +3,7 @@ package remote
 import (
 	"fmt"
 	"net"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/plugins"
@@ -10,12 +11,23 @@ import (
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/drivers/remote/api"
+	"github.com/docker/libnetwork/metrics"
+	"github.com/docker/libnetwork/pluginutils"
 	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
+)
+
+var (
+	callDuration = metrics.NewHistogramVec("libnetwork_remote_driver_call_duration_seconds",
+		"Time taken by network plugins to serve driver calls, by plugin and method.", nil, "driver", "method")
+	callErrors = metrics.NewCounterVec("libnetwork_remote_driver_call_errors_total",
+		"Number of failed network plugin calls, by plugin and method.", "driver", "method")
 )
 
 type driver struct {
 	endpoint    *plugins.Client
 	networkType string
+	ctx         context.Context
 }
 
 type maybeError interface {
@@ -73,16 +85,28 @@ func (d *driver) Config(option map[string]interface{}) error {
 
 func (d *driver) call(methodName string, arg interface{}, retVal maybeError) error {
 	method := driverapi.NetworkPluginEndpointType + "." + methodName
-	err := d.endpoint.Call(method, arg, retVal)
+	start := time.Now()
+	err := pluginutils.CallContext(d.ctx, method, func() error { return d.endpoint.Call(method, arg, retVal) })
+	callDuration.With(d.networkType, methodName).Since(start)
 	if err != nil {
+		callErrors.With(d.networkType, methodName).Inc()
 		return err
 	}
 	if e := retVal.GetError(); e != "" {
+		callErrors.With(d.networkType, methodName).Inc()
 		return fmt.Errorf("remote: %s", e)
 	}
 	return nil
 }
 
+// WithContext returns a copy of the driver whose plugin calls are abandoned
+// once ctx is done
+func (d *driver) WithContext(ctx context.Context) driverapi.Driver {
+	nd := *d
+	nd.ctx = ctx
+	return &nd
+}
+
 func (d *driver) NetworkAllocate(id string, option map[string]string, ipV4Data, ipV6Data []driverapi.IPAMData) (map[string]string, error) {
 	return nil, types.NotImplementedErrorf("not implemented")
 }
@@ -104,6 +128,16 @@ func (d *driver) CreateNetwork(id string, options map[string]interface{}, nInfo
 	return d.call("CreateNetwork", create, &api.CreateNetworkResponse{})
 }
 
+func (d *driver) UpdateNetwork(id string, options map[string]interface{}, ipV4Data, ipV6Data []driverapi.IPAMData) error {
+	update := &api.UpdateNetworkRequest{
+		NetworkID: id,
+		Options:   options,
+		IPv4Data:  ipV4Data,
+		IPv6Data:  ipV6Data,
+	}
+	return d.call("UpdateNetwork", update, &api.UpdateNetworkResponse{})
+}
+
 func (d *driver) DeleteNetwork(nid string) error {
 	delete := &api.DeleteNetworkRequest{NetworkID: nid}
 	return d.call("DeleteNetwork", delete, &api.DeleteNetworkResponse{})
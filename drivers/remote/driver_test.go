@@ -11,6 +11,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/pkg/plugins"
 	"github.com/docker/libnetwork/datastore"
@@ -18,6 +19,7 @@ import (
 	"github.com/docker/libnetwork/driverapi"
 	_ "github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
 )
 
 func decodeToMap(r *http.Request) (res map[string]interface{}, err error) {
@@ -325,6 +327,15 @@ func TestRemoteDriver(t *testing.T) {
 		}
 		return map[string]interface{}{}
 	})
+	handle(t, mux, "UpdateNetwork", func(msg map[string]interface{}) interface{} {
+		if nid, ok := msg["NetworkID"]; !ok || nid != networkID {
+			t.Fatal("Network ID missing or does not match that created")
+		}
+		if opts, ok := msg["Options"].(map[string]interface{}); !ok || opts["foo"] != "fooValue" {
+			t.Fatal("Updated network options missing")
+		}
+		return map[string]interface{}{}
+	})
 	handle(t, mux, "DeleteNetwork", func(msg map[string]interface{}) interface{} {
 		if nid, ok := msg["NetworkID"]; !ok || nid != networkID {
 			t.Fatal("Network ID missing or does not match that created")
@@ -409,6 +420,14 @@ func TestRemoteDriver(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	updater, ok := d.(driverapi.NetworkUpdater)
+	if !ok {
+		t.Fatal("Remote driver does not support network updates")
+	}
+	if err = updater.UpdateNetwork(netID, map[string]interface{}{"foo": "fooValue"}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
 	endID := "dummy-endpoint"
 	ifInfo := &testEndpoint{}
 	err = d.CreateEndpoint(netID, endID, ifInfo, map[string]interface{}{})
@@ -476,6 +495,44 @@ func TestDriverError(t *testing.T) {
 	}
 }
 
+func TestDriverContextTimeout(t *testing.T) {
+	var plugin = "test-net-driver-timeout"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	release := make(chan struct{})
+	defer close(release)
+	handle(t, mux, "CreateEndpoint", func(msg map[string]interface{}) interface{} {
+		<-release
+		return map[string]interface{}{}
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver := newDriver(plugin, p.Client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	cd := driver.(driverapi.ContextDriver).WithContext(ctx)
+	err = cd.CreateEndpoint("dummy", "dummy", &testEndpoint{t: t}, map[string]interface{}{})
+	if err == nil {
+		t.Fatalf("Expected error from hung driver")
+	}
+	if _, ok := err.(types.TimeoutError); !ok {
+		t.Fatalf("Unexpected error type from hung driver: %v", err)
+	}
+
+	// Once the context is done, calls fail without reaching the plugin
+	if err := cd.DeleteEndpoint("dummy", "dummy"); err == nil {
+		t.Fatalf("Expected error from driver with expired context")
+	}
+}
+
 func TestMissingValues(t *testing.T) {
 	var plugin = "test-net-driver-missing"
 
@@ -32,6 +32,7 @@ type DrvRegistry struct {
 	ipamDrivers ipamTable
 	dfn         DriverNotifyFunc
 	ifn         IPAMNotifyFunc
+	cfn         ClusterNodesFunc
 }
 
 // Functors definition
@@ -51,6 +52,9 @@ type IPAMNotifyFunc func(name string, driver ipamapi.Ipam, cap *ipamapi.Capabili
 // DriverNotifyFunc defines the notify function signature when a new network driver gets registered.
 type DriverNotifyFunc func(name string, driver driverapi.Driver, capability driverapi.Capability) error
 
+// ClusterNodesFunc defines the function signature returning the nodes of the gossip cluster.
+type ClusterNodesFunc func() []driverapi.ClusterNode
+
 // New retruns a new driver registry handle.
 func New(lDs, gDs interface{}, dfn DriverNotifyFunc, ifn IPAMNotifyFunc) (*DrvRegistry, error) {
 	r := &DrvRegistry{
@@ -63,6 +67,25 @@ func New(lDs, gDs interface{}, dfn DriverNotifyFunc, ifn IPAMNotifyFunc) (*DrvRe
 	return r, nil
 }
 
+// SetClusterNodesFunc sets the function the drivers learn the nodes of the gossip cluster through.
+func (r *DrvRegistry) SetClusterNodesFunc(cfn ClusterNodesFunc) {
+	r.Lock()
+	r.cfn = cfn
+	r.Unlock()
+}
+
+// ClusterNodes implements the driverapi.ClusterNodesCallback interface.
+func (r *DrvRegistry) ClusterNodes() []driverapi.ClusterNode {
+	r.Lock()
+	cfn := r.cfn
+	r.Unlock()
+
+	if cfn == nil {
+		return nil
+	}
+	return cfn()
+}
+
 // AddDriver adds a network driver to the registry.
 func (r *DrvRegistry) AddDriver(ntype string, fn InitFunc, config map[string]interface{}) error {
 	return fn(r, config)
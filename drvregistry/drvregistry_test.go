@@ -190,3 +190,16 @@ func TestWalkDrivers(t *testing.T) {
 
 	assert.Equal(t, driverName, mockDriverName)
 }
+
+func TestClusterNodes(t *testing.T) {
+	reg := getNew(t)
+
+	var dc driverapi.DriverCallback = reg
+	cb, ok := dc.(driverapi.ClusterNodesCallback)
+	assert.True(t, ok)
+	assert.Nil(t, cb.ClusterNodes())
+
+	nodes := []driverapi.ClusterNode{{Name: "node1", Address: "192.168.1.1", Labels: map[string]string{"zone": "a"}}}
+	reg.SetClusterNodesFunc(func() []driverapi.ClusterNode { return nodes })
+	assert.Equal(t, nodes, cb.ClusterNodes())
+}
@@ -0,0 +1,172 @@
+package libnetwork
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+)
+
+// NetworkOptionDryRun function returns an option setter which turns the
+// network creation into a validation of the network configuration. The
+// driver and the IPAM configuration are checked and the would-be network
+// is returned, but nothing is allocated, programmed or persisted.
+func NetworkOptionDryRun() NetworkOption {
+	return func(n *network) {
+		n.dryRun = true
+	}
+}
+
+// validate runs the checks of a dry-run network creation. On success the
+// network IPAM info reflects the pools requested in its configuration.
+func (n *network) validate() error {
+	if !n.hasSpecialDriver() {
+		if _, _, err := n.getController().getIPAMDriver(n.ipamType); err != nil {
+			return err
+		}
+
+		if n.addrSpace == "" {
+			addrSpace, err := n.deriveAddressSpace()
+			if err != nil {
+				return err
+			}
+			n.addrSpace = addrSpace
+		}
+
+		v4Info, err := n.validateIpamConfigs(4, n.ipamV4Config)
+		if err != nil {
+			return err
+		}
+
+		var v6Info []*IpamInfo
+		if n.enableIPv6 {
+			if v6Info, err = n.validateIpamConfigs(6, n.ipamV6Config); err != nil {
+				return err
+			}
+		}
+
+		if err := n.checkPoolOverlaps(append(v4Info, v6Info...)); err != nil {
+			return err
+		}
+
+		n.ipamV4Info = v4Info
+		n.ipamV6Info = v6Info
+	}
+
+	d, err := n.driver(true)
+	if err != nil {
+		return err
+	}
+
+	if v, ok := d.(driverapi.NetworkValidator); ok {
+		if err := v.ValidateNetwork(n.id, n.generic, n.getIPData(4), n.getIPData(6)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateIpamConfigs checks the passed IPAM configurations and returns the
+// IPAM info of the ones requesting a specific pool. The other pools would
+// be chosen by the IPAM driver.
+func (n *network) validateIpamConfigs(ipVer int, cfgList []*IpamConf) ([]*IpamInfo, error) {
+	var infoList []*IpamInfo
+
+	for _, cfg := range cfgList {
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+
+		if cfg.PreferredPool == "" {
+			if cfg.SubPool != "" || cfg.Gateway != "" || len(cfg.AuxAddresses) != 0 {
+				return nil, types.BadRequestErrorf("invalid IPv%d configuration: sub pool, gateway and auxiliary addresses require a pool", ipVer)
+			}
+			continue
+		}
+
+		_, pool, err := net.ParseCIDR(cfg.PreferredPool)
+		if err != nil {
+			return nil, types.BadRequestErrorf("invalid IPv%d pool %s: %v", ipVer, cfg.PreferredPool, err)
+		}
+		if (pool.IP.To4() != nil) != (ipVer == 4) {
+			return nil, types.BadRequestErrorf("invalid IPv%d pool %s", ipVer, cfg.PreferredPool)
+		}
+
+		if cfg.SubPool != "" {
+			_, sub, err := net.ParseCIDR(cfg.SubPool)
+			if err != nil {
+				return nil, types.BadRequestErrorf("invalid sub pool %s: %v", cfg.SubPool, err)
+			}
+			pOnes, _ := pool.Mask.Size()
+			sOnes, _ := sub.Mask.Size()
+			if !pool.Contains(sub.IP) || sOnes < pOnes {
+				return nil, types.BadRequestErrorf("sub pool %s is not within pool %s", cfg.SubPool, cfg.PreferredPool)
+			}
+		}
+
+		d := &IpamInfo{IPAMData: driverapi.IPAMData{AddressSpace: n.addrSpace, Pool: pool}}
+
+		if cfg.Gateway != "" {
+			ip := net.ParseIP(cfg.Gateway)
+			if !pool.Contains(ip) {
+				return nil, types.BadRequestErrorf("gateway %s is not within pool %s", cfg.Gateway, cfg.PreferredPool)
+			}
+			d.Gateway = &net.IPNet{IP: ip, Mask: pool.Mask}
+		}
+
+		for k, v := range cfg.AuxAddresses {
+			ip := net.ParseIP(v)
+			if ip == nil || !pool.Contains(ip) {
+				return nil, types.BadRequestErrorf("auxiliary address %s (%s) is not within pool %s", k, v, cfg.PreferredPool)
+			}
+			if d.AuxAddresses == nil {
+				d.AuxAddresses = make(map[string]*net.IPNet)
+			}
+			d.AuxAddresses[k] = &net.IPNet{IP: ip, Mask: pool.Mask}
+		}
+
+		for _, o := range infoList {
+			if netutils.NetworkOverlaps(o.Pool, pool) {
+				return nil, types.BadRequestErrorf("pools %s and %s overlap", o.Pool, pool)
+			}
+		}
+
+		infoList = append(infoList, d)
+	}
+
+	return infoList, nil
+}
+
+// checkPoolOverlaps returns a ForbiddenError if one of the pools overlaps
+// with a pool of another network managed by the same IPAM driver in the
+// same address space
+func (n *network) checkPoolOverlaps(infoList []*IpamInfo) error {
+	if len(infoList) == 0 {
+		return nil
+	}
+
+	networks, err := n.getController().getNetworksFromStore()
+	if err != nil {
+		return err
+	}
+
+	for _, nw := range networks {
+		if nw.id == n.id || nw.ipamType != n.ipamType {
+			continue
+		}
+		if n.addrSpace != "" && nw.addrSpace != "" && nw.addrSpace != n.addrSpace {
+			continue
+		}
+		for _, o := range append(nw.getIPInfo(4), nw.getIPInfo(6)...) {
+			for _, d := range infoList {
+				if netutils.NetworkOverlaps(o.Pool, d.Pool) {
+					return types.ForbiddenErrorf("pool %s overlaps with pool %s of network %s (%s)", d.Pool, o.Pool, nw.Name(), nw.ID())
+				}
+			}
+		}
+	}
+
+	return nil
+}
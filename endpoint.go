@@ -7,6 +7,7 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/datastore"
@@ -14,6 +15,7 @@ import (
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
 )
 
 // Endpoint represents a logical connection between a network and a sandbox.
@@ -31,9 +33,17 @@ type Endpoint interface {
 	// the network resources allocated for the endpoint.
 	Join(sandbox Sandbox, options ...EndpointOption) error
 
+	// JoinWithContext is like Join but gives up and rolls back the join once
+	// ctx is done.
+	JoinWithContext(ctx context.Context, sandbox Sandbox, options ...EndpointOption) error
+
 	// Leave detaches the network resources populated in the sandbox.
 	Leave(sandbox Sandbox, options ...EndpointOption) error
 
+	// LeaveWithContext is like Leave but abandons the driver calls once ctx
+	// is done.
+	LeaveWithContext(ctx context.Context, sandbox Sandbox, options ...EndpointOption) error
+
 	// Return certain operational data belonging to this endpoint
 	Info() EndpointInfo
 
@@ -42,6 +52,10 @@ type Endpoint interface {
 
 	// Delete and detaches this endpoint from the network.
 	Delete(force bool) error
+
+	// DeleteWithContext is like Delete but abandons the driver calls once
+	// ctx is done.
+	DeleteWithContext(ctx context.Context, force bool) error
 }
 
 // EndpointOption is an option setter function type used to pass various options to Network
@@ -64,13 +78,18 @@ type endpoint struct {
 	joinLeaveDone     chan struct{}
 	prefAddress       net.IP
 	prefAddressV6     net.IP
+	secondaryCnt      int
+	secondaryCntV6    int
 	ipamOptions       map[string]string
 	aliases           map[string]string
 	myAliases         []string
+	aliasCNAME        bool
+	txtRecords        []string
 	svcID             string
 	svcName           string
 	virtualIP         net.IP
 	svcAliases        []string
+	svcMode           ServiceMode
 	ingressPorts      []*PortConfig
 	dbIndex           uint64
 	dbExists          bool
@@ -100,6 +119,9 @@ func (ep *endpoint) MarshalJSON() ([]byte, error) {
 	epMap["virtualIP"] = ep.virtualIP.String()
 	epMap["ingressPorts"] = ep.ingressPorts
 	epMap["svcAliases"] = ep.svcAliases
+	epMap["svcMode"] = ep.svcMode
+	epMap["txtRecords"] = ep.txtRecords
+	epMap["aliasCNAME"] = ep.aliasCNAME
 
 	return json.Marshal(epMap)
 }
@@ -176,6 +198,14 @@ func (ep *endpoint) UnmarshalJSON(b []byte) (err error) {
 			ep.generic[netlabel.ExposedPorts] = tplist
 
 		}
+
+		if opt, ok := ep.generic[netlabel.EndpointLabels]; ok {
+			labels := make(map[string]string)
+			for k, v := range opt.(map[string]interface{}) {
+				labels[k] = v.(string)
+			}
+			ep.generic[netlabel.EndpointLabels] = labels
+		}
 	}
 
 	if v, ok := epMap["anonymous"]; ok {
@@ -200,6 +230,10 @@ func (ep *endpoint) UnmarshalJSON(b []byte) (err error) {
 		ep.virtualIP = net.ParseIP(vip.(string))
 	}
 
+	if sm, ok := epMap["svcMode"]; ok {
+		ep.svcMode = ServiceMode(sm.(string))
+	}
+
 	sal, _ := json.Marshal(epMap["svcAliases"])
 	var svcAliases []string
 	json.Unmarshal(sal, &svcAliases)
@@ -214,6 +248,15 @@ func (ep *endpoint) UnmarshalJSON(b []byte) (err error) {
 	var myAliases []string
 	json.Unmarshal(ma, &myAliases)
 	ep.myAliases = myAliases
+
+	tr, _ := json.Marshal(epMap["txtRecords"])
+	var txtRecords []string
+	json.Unmarshal(tr, &txtRecords)
+	ep.txtRecords = txtRecords
+
+	if v, ok := epMap["aliasCNAME"]; ok {
+		ep.aliasCNAME = v.(bool)
+	}
 	return nil
 }
 
@@ -237,6 +280,7 @@ func (ep *endpoint) CopyTo(o datastore.KVObject) error {
 	dstEp.svcName = ep.svcName
 	dstEp.svcID = ep.svcID
 	dstEp.virtualIP = ep.virtualIP
+	dstEp.svcMode = ep.svcMode
 
 	dstEp.svcAliases = make([]string, len(ep.svcAliases))
 	copy(dstEp.svcAliases, ep.svcAliases)
@@ -260,6 +304,10 @@ func (ep *endpoint) CopyTo(o datastore.KVObject) error {
 	dstEp.myAliases = make([]string, len(ep.myAliases))
 	copy(dstEp.myAliases, ep.myAliases)
 
+	dstEp.aliasCNAME = ep.aliasCNAME
+	dstEp.txtRecords = make([]string, len(ep.txtRecords))
+	copy(dstEp.txtRecords, ep.txtRecords)
+
 	dstEp.generic = options.Generic{}
 	for k, v := range ep.generic {
 		dstEp.generic[k] = v
@@ -399,6 +447,10 @@ func (ep *endpoint) getNetworkFromStore() (*network, error) {
 }
 
 func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
+	return ep.JoinWithContext(context.Background(), sbox, options...)
+}
+
+func (ep *endpoint) JoinWithContext(ctx context.Context, sbox Sandbox, options ...EndpointOption) error {
 	if sbox == nil {
 		return types.BadRequestErrorf("endpoint cannot be joined by nil container")
 	}
@@ -411,10 +463,22 @@ func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
 	sb.joinLeaveStart()
 	defer sb.joinLeaveEnd()
 
-	return ep.sbJoin(sb, options...)
+	var driver string
+	if n := ep.getNetwork(); n != nil {
+		driver = n.Type()
+	}
+
+	start := time.Now()
+	if err := ep.sbJoin(ctx, sb, options...); err != nil {
+		endpointJoinErrors.With(driver).Inc()
+		return err
+	}
+
+	endpointJoinDuration.With(driver).Since(start)
+	return nil
 }
 
-func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) error {
+func (ep *endpoint) sbJoin(ctx context.Context, sb *sandbox, options ...EndpointOption) error {
 	n, err := ep.getNetworkFromStore()
 	if err != nil {
 		return fmt.Errorf("failed to get network from store during join: %v", err)
@@ -425,6 +489,10 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) error {
 		return fmt.Errorf("failed to get endpoint from store during join: %v", err)
 	}
 
+	if err = n.checkSandboxQuota(sb); err != nil {
+		return err
+	}
+
 	ep.Lock()
 	if ep.sandboxID != "" {
 		ep.Unlock()
@@ -442,6 +510,11 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) error {
 			ep.Unlock()
 		}
 	}()
+	defer func() {
+		if err == nil {
+			n.getController().publishEvent(newEndpointEvent(EventEndpointJoin, ep, sb))
+		}
+	}()
 
 	nid := n.ID()
 
@@ -451,8 +524,11 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) error {
 	if err != nil {
 		return fmt.Errorf("failed to join endpoint: %v", err)
 	}
+	// The driver calls of the join are bound to ctx, while the rollbacks
+	// use d so that they still run once ctx is done.
+	cd := driverWithContext(ctx, d)
 
-	err = d.Join(nid, epid, sb.Key(), ep, sb.Labels())
+	err = cd.Join(nid, epid, sb.Key(), ep, sb.Labels())
 	if err != nil {
 		return err
 	}
@@ -469,17 +545,17 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) error {
 		n.getController().watchSvcRecord(ep)
 	}
 
-	address := ""
-	if ip := ep.getFirstInterfaceAddress(); ip != nil {
-		address = ip.String()
-	}
-	if err = sb.updateHostsFile(address); err != nil {
+	if err = sb.updateHostsFile(ep.hostsAddresses()); err != nil {
 		return err
 	}
 	if err = sb.updateDNS(n.enableIPv6); err != nil {
 		return err
 	}
 
+	if err = contextError(ctx); err != nil {
+		return err
+	}
+
 	if err = n.getController().updateToStore(ep); err != nil {
 		return err
 	}
@@ -500,12 +576,17 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) error {
 		return err
 	}
 
+	if err = contextError(ctx); err != nil {
+		return err
+	}
+
 	if e := ep.addToCluster(); e != nil {
 		log.Errorf("Could not update state for endpoint %s into cluster: %v", ep.Name(), e)
 	}
 
 	if sb.needDefaultGW() && sb.getEndpointInGWNetwork() == nil {
-		return sb.setupDefaultGW()
+		err = sb.setupDefaultGW()
+		return err
 	}
 
 	moveExtConn := sb.getGatewayEndpoint() != extEp
@@ -513,7 +594,7 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) error {
 	if moveExtConn {
 		if extEp != nil {
 			log.Debugf("Revoking external connectivity on endpoint %s (%s)", extEp.Name(), extEp.ID())
-			if err = d.RevokeExternalConnectivity(extEp.network.ID(), extEp.ID()); err != nil {
+			if err = cd.RevokeExternalConnectivity(extEp.network.ID(), extEp.ID()); err != nil {
 				return types.InternalErrorf(
 					"driver failed revoking external connectivity on endpoint %s (%s): %v",
 					extEp.Name(), extEp.ID(), err)
@@ -529,7 +610,7 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) error {
 		}
 		if !n.internal {
 			log.Debugf("Programming external connectivity on endpoint %s (%s)", ep.Name(), ep.ID())
-			if err = d.ProgramExternalConnectivity(n.ID(), ep.ID(), sb.Labels()); err != nil {
+			if err = cd.ProgramExternalConnectivity(n.ID(), ep.ID(), sb.Labels()); err != nil {
 				return types.InternalErrorf(
 					"driver failed programming external connectivity on endpoint %s (%s): %v",
 					ep.Name(), ep.ID(), err)
@@ -593,6 +674,8 @@ func (ep *endpoint) rename(name string) error {
 	// will force the peers to get the correct EP name.
 	n.getEpCnt().updateStore()
 
+	n.getController().publishEvent(newEndpointEvent(EventEndpointRename, ep, nil))
+
 	return err
 }
 
@@ -604,6 +687,10 @@ func (ep *endpoint) hasInterface(iName string) bool {
 }
 
 func (ep *endpoint) Leave(sbox Sandbox, options ...EndpointOption) error {
+	return ep.LeaveWithContext(context.Background(), sbox, options...)
+}
+
+func (ep *endpoint) LeaveWithContext(ctx context.Context, sbox Sandbox, options ...EndpointOption) error {
 	if sbox == nil || sbox.ID() == "" || sbox.Key() == "" {
 		return types.BadRequestErrorf("invalid Sandbox passed to enpoint leave: %v", sbox)
 	}
@@ -616,10 +703,10 @@ func (ep *endpoint) Leave(sbox Sandbox, options ...EndpointOption) error {
 	sb.joinLeaveStart()
 	defer sb.joinLeaveEnd()
 
-	return ep.sbLeave(sb, false, options...)
+	return ep.sbLeave(ctx, sb, false, options...)
 }
 
-func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption) error {
+func (ep *endpoint) sbLeave(ctx context.Context, sb *sandbox, force bool, options ...EndpointOption) error {
 	n, err := ep.getNetworkFromStore()
 	if err != nil {
 		return fmt.Errorf("failed to get network from store during leave: %v", err)
@@ -647,6 +734,7 @@ func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption)
 	if err != nil {
 		return fmt.Errorf("failed to leave endpoint: %v", err)
 	}
+	d = driverWithContext(ctx, d)
 
 	ep.Lock()
 	ep.sandboxID = ""
@@ -667,6 +755,14 @@ func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption)
 		}
 
 		if err := d.Leave(n.id, ep.id); err != nil {
+			// The driver call was abandoned, stay attached to the
+			// sandbox so that the leave can be retried
+			if ctx.Err() != nil {
+				ep.Lock()
+				ep.sandboxID = sid
+				ep.Unlock()
+				return err
+			}
 			if _, ok := err.(types.MaskableError); !ok {
 				log.Warnf("driver error disconnecting container %s : %v", ep.name, err)
 			}
@@ -686,6 +782,8 @@ func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption)
 		return err
 	}
 
+	n.getController().publishEvent(newEndpointEvent(EventEndpointLeave, ep, sb))
+
 	if e := ep.deleteFromCluster(); e != nil {
 		log.Errorf("Could not delete state for endpoint %s from cluster: %v", ep.Name(), e)
 	}
@@ -716,6 +814,10 @@ func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption)
 }
 
 func (ep *endpoint) Delete(force bool) error {
+	return ep.DeleteWithContext(context.Background(), force)
+}
+
+func (ep *endpoint) DeleteWithContext(ctx context.Context, force bool) error {
 	var err error
 	n, err := ep.getNetworkFromStore()
 	if err != nil {
@@ -739,7 +841,7 @@ func (ep *endpoint) Delete(force bool) error {
 	}
 
 	if sb != nil {
-		if e := ep.sbLeave(sb.(*sandbox), force); e != nil {
+		if e := ep.sbLeave(ctx, sb.(*sandbox), force); e != nil {
 			log.Warnf("failed to leave sandbox for endpoint %s : %v", name, e)
 		}
 	}
@@ -748,8 +850,10 @@ func (ep *endpoint) Delete(force bool) error {
 		return err
 	}
 
+	// The endpoint is kept even on a forced delete if the driver cleanup
+	// was abandoned because ctx is done, so that the delete can be retried.
 	defer func() {
-		if err != nil && !force {
+		if err != nil && (!force || ctx.Err() != nil) {
 			ep.dbExists = false
 			if e := n.getController().updateToStore(ep); e != nil {
 				log.Warnf("failed to recreate endpoint in store %s : %v", name, e)
@@ -757,12 +861,12 @@ func (ep *endpoint) Delete(force bool) error {
 		}
 	}()
 
-	if err = n.getEpCnt().DecEndpointCnt(); err != nil && !force {
+	if err = n.getEpCnt().release(ep.addressCount()); err != nil && !force {
 		return err
 	}
 	defer func() {
-		if err != nil && !force {
-			if e := n.getEpCnt().IncEndpointCnt(); e != nil {
+		if err != nil && (!force || ctx.Err() != nil) {
+			if e := n.getEpCnt().reserve(NetworkQuota{}, ep.addressCount()); e != nil {
 				log.Warnf("failed to update network %s : %v", n.name, e)
 			}
 		}
@@ -771,16 +875,19 @@ func (ep *endpoint) Delete(force bool) error {
 	// unwatch for service records
 	n.getController().unWatchSvcRecord(ep)
 
-	if err = ep.deleteEndpoint(force); err != nil && !force {
+	if err = ep.deleteEndpoint(ctx, force); err != nil && (!force || ctx.Err() != nil) {
+		n.getController().watchSvcRecord(ep)
 		return err
 	}
 
 	ep.releaseAddress()
 
+	n.getController().publishEvent(newEndpointEvent(EventEndpointDelete, ep, nil))
+
 	return nil
 }
 
-func (ep *endpoint) deleteEndpoint(force bool) error {
+func (ep *endpoint) deleteEndpoint(ctx context.Context, force bool) error {
 	ep.Lock()
 	n := ep.network
 	name := ep.name
@@ -796,11 +903,17 @@ func (ep *endpoint) deleteEndpoint(force bool) error {
 		return nil
 	}
 
-	if err := driver.DeleteEndpoint(n.id, epid); err != nil {
+	if err := driverWithContext(ctx, driver).DeleteEndpoint(n.id, epid); err != nil {
 		if _, ok := err.(types.ForbiddenError); ok {
 			return err
 		}
 
+		// The driver call was abandoned, keep the endpoint so that
+		// the driver cleanup is not skipped
+		if ctx.Err() != nil {
+			return err
+		}
+
 		if _, ok := err.(types.MaskableError); !ok {
 			log.Warnf("driver error deleting endpoint %s : %v", name, err)
 		}
@@ -833,6 +946,28 @@ func (ep *endpoint) getFirstInterfaceAddress() net.IP {
 	return nil
 }
 
+// hostsAddresses returns the addresses of the endpoint the container name
+// resolves to in /etc/hosts, the secondary ones after the primary address of
+// each IP version
+func (ep *endpoint) hostsAddresses() []string {
+	ip := ep.getFirstInterfaceAddress()
+	if ip == nil {
+		return nil
+	}
+
+	addresses := []string{ip.String()}
+	for _, addr := range ep.Iface().SecondaryAddresses() {
+		addresses = append(addresses, addr.IP.String())
+	}
+	if addr := ep.Iface().AddressIPv6(); addr != nil {
+		addresses = append(addresses, addr.IP.String())
+	}
+	for _, addr := range ep.Iface().SecondaryAddressesIPv6() {
+		addresses = append(addresses, addr.IP.String())
+	}
+	return addresses
+}
+
 // EndpointOptionGeneric function returns an option setter for a Generic option defined
 // in a Dictionary of Key-Value pair
 func EndpointOptionGeneric(generic map[string]interface{}) EndpointOption {
@@ -866,6 +1001,17 @@ func CreateOptionIpam(ipV4, ipV6 net.IP, llIPs []net.IP, ipamOptions map[string]
 	}
 }
 
+// CreateOptionSecondaryAddresses function returns an option setter for requesting
+// from IPAM, in addition to the primary addresses, the passed number of IPv4 and
+// IPv6 addresses for the endpoint interface. They are allocated from the pool of
+// the primary address of the same family and programmed as interface aliases.
+func CreateOptionSecondaryAddresses(v4, v6 int) EndpointOption {
+	return func(ep *endpoint) {
+		ep.secondaryCnt = v4
+		ep.secondaryCntV6 = v6
+	}
+}
+
 // CreateOptionExposedPorts function returns an option setter for the container exposed
 // ports option to be passed to network.CreateEndpoint() method.
 func CreateOptionExposedPorts(exposedPorts []types.TransportPort) EndpointOption {
@@ -879,6 +1025,19 @@ func CreateOptionExposedPorts(exposedPorts []types.TransportPort) EndpointOption
 	}
 }
 
+// CreateOptionLabels function returns an option setter for the labels of
+// the endpoint, which drivers can use to select the endpoint
+func CreateOptionLabels(labels map[string]string) EndpointOption {
+	return func(ep *endpoint) {
+		// Defensive copy
+		l := make(map[string]string, len(labels))
+		for k, v := range labels {
+			l[k] = v
+		}
+		ep.generic[netlabel.EndpointLabels] = l
+	}
+}
+
 // CreateOptionPortMapping function returns an option setter for the mapping
 // ports option to be passed to network.CreateEndpoint() method.
 func CreateOptionPortMapping(portBindings []types.PortBinding) EndpointOption {
@@ -927,6 +1086,14 @@ func CreateOptionService(name, id string, vip net.IP, ingressPorts []*PortConfig
 	}
 }
 
+// CreateOptionServiceMode function returns an option setter for the way the
+// clients reach the service the endpoint belongs to, ServiceModeVIP if not set
+func CreateOptionServiceMode(mode ServiceMode) EndpointOption {
+	return func(ep *endpoint) {
+		ep.svcMode = mode
+	}
+}
+
 //CreateOptionMyAlias function returns an option setter for setting endpoint's self alias
 func CreateOptionMyAlias(alias string) EndpointOption {
 	return func(ep *endpoint) {
@@ -1025,7 +1192,7 @@ func (ep *endpoint) assignAddressVersion(ipVer int, ipam ipamapi.Ipam) error {
 			*address = addr
 			*poolID = d.PoolID
 			ep.Unlock()
-			return nil
+			return ep.assignSecondaryAddresses(ipVer, ipam, d.PoolID)
 		}
 		if err != ipamapi.ErrNoAvailableIPs || progAdd != nil {
 			return err
@@ -1037,6 +1204,36 @@ func (ep *endpoint) assignAddressVersion(ipVer int, ipam ipamapi.Ipam) error {
 	return fmt.Errorf("no available IPv%d addresses on this network's address pools: %s (%s)", ipVer, n.Name(), n.ID())
 }
 
+// assignSecondaryAddresses requests the secondary addresses of the given IP
+// version from the pool the primary address was allocated from
+func (ep *endpoint) assignSecondaryAddresses(ipVer int, ipam ipamapi.Ipam, poolID string) error {
+	var (
+		count int
+		list  *[]*net.IPNet
+	)
+
+	switch ipVer {
+	case 4:
+		count = ep.secondaryCnt
+		list = &ep.iface.addrs
+	case 6:
+		count = ep.secondaryCntV6
+		list = &ep.iface.addrsv6
+	}
+
+	for i := len(*list); i < count; i++ {
+		addr, _, err := ipam.RequestAddress(poolID, nil, ep.ipamOptions)
+		if err != nil {
+			return fmt.Errorf("failed to allocate secondary IPv%d address for endpoint %s: %v", ipVer, ep.Name(), err)
+		}
+		ep.Lock()
+		*list = append(*list, addr)
+		ep.Unlock()
+	}
+
+	return nil
+}
+
 func (ep *endpoint) releaseAddress() {
 	n := ep.getNetwork()
 	if n.hasSpecialDriver() {
@@ -1062,6 +1259,18 @@ func (ep *endpoint) releaseAddress() {
 			log.Warnf("Failed to release ip address %s on delete of endpoint %s (%s): %v", ep.iface.addrv6.IP, ep.Name(), ep.ID(), err)
 		}
 	}
+
+	for _, addr := range ep.iface.addrs {
+		if err := ipam.ReleaseAddress(ep.iface.v4PoolID, addr.IP); err != nil {
+			log.Warnf("Failed to release secondary ip address %s on delete of endpoint %s (%s): %v", addr.IP, ep.Name(), ep.ID(), err)
+		}
+	}
+
+	for _, addr := range ep.iface.addrsv6 {
+		if err := ipam.ReleaseAddress(ep.iface.v6PoolID, addr.IP); err != nil {
+			log.Warnf("Failed to release secondary ip address %s on delete of endpoint %s (%s): %v", addr.IP, ep.Name(), ep.ID(), err)
+		}
+	}
 }
 
 func (c *controller) cleanupLocalEndpoints() {
@@ -9,10 +9,11 @@ import (
 )
 
 type endpointCnt struct {
-	n        *network
-	Count    uint64
-	dbIndex  uint64
-	dbExists bool
+	n         *network
+	Count     uint64
+	Addresses uint64
+	dbIndex   uint64
+	dbExists  bool
 	sync.Mutex
 }
 
@@ -91,6 +92,7 @@ func (ec *endpointCnt) CopyTo(o datastore.KVObject) error {
 	dstEc := o.(*endpointCnt)
 	dstEc.n = ec.n
 	dstEc.Count = ec.Count
+	dstEc.Addresses = ec.Addresses
 	dstEc.dbExists = ec.dbExists
 	dstEc.dbIndex = ec.dbIndex
 
@@ -137,15 +139,24 @@ func (ec *endpointCnt) setCnt(cnt uint64) error {
 	return ec.updateStore()
 }
 
-func (ec *endpointCnt) atomicIncDecEpCnt(inc bool) error {
+// setCounts overwrites the endpoint and address counts
+func (ec *endpointCnt) setCounts(cnt, addrs uint64) error {
+	return ec.atomicUpdate(func() error {
+		ec.Count = cnt
+		ec.Addresses = addrs
+		return nil
+	})
+}
+
+// atomicUpdate applies update to the counts and saves them to the store,
+// retrying with the latest stored counts on concurrent modifications. The
+// counts are left untouched if update fails.
+func (ec *endpointCnt) atomicUpdate(update func() error) error {
 retry:
 	ec.Lock()
-	if inc {
-		ec.Count++
-	} else {
-		if ec.Count > 0 {
-			ec.Count--
-		}
+	if err := update(); err != nil {
+		ec.Unlock()
+		return err
 	}
 	ec.Unlock()
 
@@ -169,6 +180,47 @@ retry:
 	return nil
 }
 
+func (ec *endpointCnt) atomicIncDecEpCnt(inc bool) error {
+	return ec.atomicUpdate(func() error {
+		if inc {
+			ec.Count++
+		} else {
+			if ec.Count > 0 {
+				ec.Count--
+			}
+		}
+		return nil
+	})
+}
+
+// reserve counts an endpoint holding addrs addresses. It fails with a
+// ForbiddenError if the counts would exceed the passed quota.
+func (ec *endpointCnt) reserve(quota NetworkQuota, addrs uint64) error {
+	return ec.atomicUpdate(func() error {
+		if err := quota.check(ec.Count+1, ec.Addresses+addrs); err != nil {
+			return err
+		}
+		ec.Count++
+		ec.Addresses += addrs
+		return nil
+	})
+}
+
+// release uncounts an endpoint holding addrs addresses
+func (ec *endpointCnt) release(addrs uint64) error {
+	return ec.atomicUpdate(func() error {
+		if ec.Count > 0 {
+			ec.Count--
+		}
+		if ec.Addresses > addrs {
+			ec.Addresses -= addrs
+		} else {
+			ec.Addresses = 0
+		}
+		return nil
+	})
+}
+
 func (ec *endpointCnt) IncEndpointCnt() error {
 	return ec.atomicIncDecEpCnt(true)
 }
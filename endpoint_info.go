@@ -46,6 +46,12 @@ type InterfaceInfo interface {
 
 	// LinkLocalAddresses returns the list of link-local (IPv4/IPv6) addresses assigned to the endpoint.
 	LinkLocalAddresses() []*net.IPNet
+
+	// SecondaryAddresses returns the additional IPv4 addresses assigned to the endpoint.
+	SecondaryAddresses() []*net.IPNet
+
+	// SecondaryAddressesIPv6 returns the additional IPv6 addresses assigned to the endpoint.
+	SecondaryAddressesIPv6() []*net.IPNet
 }
 
 type endpointInterface struct {
@@ -53,6 +59,8 @@ type endpointInterface struct {
 	addr      *net.IPNet
 	addrv6    *net.IPNet
 	llAddrs   []*net.IPNet
+	addrs     []*net.IPNet
+	addrsv6   []*net.IPNet
 	srcName   string
 	dstPrefix string
 	routes    []*net.IPNet
@@ -78,6 +86,12 @@ func (epi *endpointInterface) MarshalJSON() ([]byte, error) {
 		}
 		epMap["llAddrs"] = list
 	}
+	if len(epi.addrs) != 0 {
+		epMap["addrs"] = ipNetStrings(epi.addrs)
+	}
+	if len(epi.addrsv6) != 0 {
+		epMap["addrsv6"] = ipNetStrings(epi.addrsv6)
+	}
 	epMap["srcName"] = epi.srcName
 	epMap["dstPrefix"] = epi.dstPrefix
 	var routes []string
@@ -124,6 +138,16 @@ func (epi *endpointInterface) UnmarshalJSON(b []byte) error {
 			epi.llAddrs = append(epi.llAddrs, ll)
 		}
 	}
+	if v, ok := epMap["addrs"]; ok {
+		if epi.addrs, err = parseIPNetList(v); err != nil {
+			return types.InternalErrorf("failed to decode endpoint interface secondary ipv4 addresses after json unmarshal: %v", err)
+		}
+	}
+	if v, ok := epMap["addrsv6"]; ok {
+		if epi.addrsv6, err = parseIPNetList(v); err != nil {
+			return types.InternalErrorf("failed to decode endpoint interface secondary ipv6 addresses after json unmarshal: %v", err)
+		}
+	}
 	epi.srcName = epMap["srcName"].(string)
 	epi.dstPrefix = epMap["dstPrefix"].(string)
 
@@ -144,6 +168,34 @@ func (epi *endpointInterface) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+func ipNetStrings(list []*net.IPNet) []string {
+	sl := make([]string, 0, len(list))
+	for _, n := range list {
+		sl = append(sl, n.String())
+	}
+	return sl
+}
+
+func parseIPNetList(v interface{}) ([]*net.IPNet, error) {
+	il, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected address list %v", v)
+	}
+	list := make([]*net.IPNet, 0, len(il))
+	for _, i := range il {
+		s, ok := i.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected address %v", i)
+		}
+		n, err := types.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
 func (epi *endpointInterface) CopyTo(dstEpi *endpointInterface) error {
 	dstEpi.mac = types.GetMacCopy(epi.mac)
 	dstEpi.addr = types.GetIPNetCopy(epi.addr)
@@ -159,6 +211,13 @@ func (epi *endpointInterface) CopyTo(dstEpi *endpointInterface) error {
 		}
 	}
 
+	for _, addr := range epi.addrs {
+		dstEpi.addrs = append(dstEpi.addrs, types.GetIPNetCopy(addr))
+	}
+	for _, addr := range epi.addrsv6 {
+		dstEpi.addrsv6 = append(dstEpi.addrsv6, types.GetIPNetCopy(addr))
+	}
+
 	for _, route := range epi.routes {
 		dstEpi.routes = append(dstEpi.routes, types.GetIPNetCopy(route))
 	}
@@ -297,6 +356,22 @@ func (epi *endpointInterface) LinkLocalAddresses() []*net.IPNet {
 	return epi.llAddrs
 }
 
+func (epi *endpointInterface) SecondaryAddresses() []*net.IPNet {
+	return epi.addrs
+}
+
+func (epi *endpointInterface) SecondaryAddressesIPv6() []*net.IPNet {
+	return epi.addrsv6
+}
+
+// ipAliases returns the secondary addresses to be programmed as aliases
+// of the interface in the sandbox
+func (epi *endpointInterface) ipAliases() []*net.IPNet {
+	aliases := make([]*net.IPNet, 0, len(epi.addrs)+len(epi.addrsv6))
+	aliases = append(aliases, epi.addrs...)
+	return append(aliases, epi.addrsv6...)
+}
+
 func (epi *endpointInterface) SetNames(srcName string, dstPrefix string) error {
 	epi.srcName = srcName
 	epi.dstPrefix = dstPrefix
@@ -0,0 +1,189 @@
+package libnetwork
+
+import (
+	"github.com/docker/go-events"
+)
+
+// EventType identifies the kind of lifecycle change carried by an Event
+type EventType string
+
+const (
+	// EventNetworkCreate is generated when a network is created
+	EventNetworkCreate EventType = "network.create"
+	// EventNetworkUpdate is generated when a network is updated in place
+	EventNetworkUpdate EventType = "network.update"
+	// EventNetworkDelete is generated when a network is deleted
+	EventNetworkDelete EventType = "network.delete"
+	// EventEndpointCreate is generated when an endpoint is created
+	EventEndpointCreate EventType = "endpoint.create"
+	// EventEndpointDelete is generated when an endpoint is deleted
+	EventEndpointDelete EventType = "endpoint.delete"
+	// EventEndpointJoin is generated when a sandbox joins an endpoint
+	EventEndpointJoin EventType = "endpoint.join"
+	// EventEndpointLeave is generated when a sandbox leaves an endpoint
+	EventEndpointLeave EventType = "endpoint.leave"
+	// EventEndpointRename is generated when an endpoint is renamed
+	EventEndpointRename EventType = "endpoint.rename"
+	// EventSandboxDestroy is generated when a sandbox is destroyed
+	EventSandboxDestroy EventType = "sandbox.destroy"
+)
+
+// Event describes a lifecycle change of a network, endpoint or sandbox.
+// Only the fields relevant to the event type are populated.
+type Event struct {
+	Type         EventType
+	NetworkID    string
+	NetworkName  string
+	EndpointID   string
+	EndpointName string
+	SandboxID    string
+	ContainerID  string
+}
+
+// EventFilter selects the events delivered to a subscriber. Empty
+// fields act as wildcards.
+type EventFilter struct {
+	Types     []EventType
+	NetworkID string
+	SandboxID string
+}
+
+func (f EventFilter) match(ev Event) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == ev.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.NetworkID != "" && f.NetworkID != ev.NetworkID {
+		return false
+	}
+
+	if f.SandboxID != "" && f.SandboxID != ev.SandboxID {
+		return false
+	}
+
+	return true
+}
+
+// eventSink delivers events on a typed channel. It is always fronted by an
+// events.Queue so that a slow reader never blocks the broadcaster.
+type eventSink struct {
+	C      chan Event
+	closed chan struct{}
+}
+
+func newEventSink() *eventSink {
+	return &eventSink{
+		C:      make(chan Event),
+		closed: make(chan struct{}),
+	}
+}
+
+func (s *eventSink) Write(ev events.Event) error {
+	select {
+	case s.C <- ev.(Event):
+	case <-s.closed:
+		// The subscription is canceled, the event is discarded
+	}
+	return nil
+}
+
+// cancel stops the delivery of the events still queued
+func (s *eventSink) cancel() {
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+}
+
+// Close is called by the queue once it is drained, no event is written
+// to the sink afterwards
+func (s *eventSink) Close() error {
+	close(s.C)
+	return nil
+}
+
+// Subscribe returns a channel on which the lifecycle events matching the
+// filter are delivered, and a function to cancel the subscription. Every
+// subscriber has its own unbounded queue, so a slow consumer does not
+// stall the controller or the other subscribers. Canceling discards the
+// events not delivered yet and closes the channel.
+func (c *controller) Subscribe(filter EventFilter) (<-chan Event, func()) {
+	ch := newEventSink()
+	sink := events.Sink(events.NewQueue(ch))
+	sink = events.NewFilter(sink, events.MatcherFunc(func(ev events.Event) bool {
+		return filter.match(ev.(Event))
+	}))
+
+	c.Lock()
+	c.subscriptions[ch] = struct{}{}
+	c.Unlock()
+
+	c.eventBroadcaster.Add(sink)
+	return ch.C, func() {
+		c.eventBroadcaster.Remove(sink)
+		ch.cancel()
+		sink.Close()
+
+		c.Lock()
+		delete(c.subscriptions, ch)
+		c.Unlock()
+	}
+}
+
+// stopEvents closes the broadcaster and the subscriptions. The events not
+// delivered yet are discarded, so that the subscribers which stopped reading
+// do not block the close.
+func (c *controller) stopEvents() {
+	c.Lock()
+	for ch := range c.subscriptions {
+		ch.cancel()
+	}
+	c.Unlock()
+
+	c.eventBroadcaster.Close()
+}
+
+func (c *controller) publishEvent(ev Event) {
+	if c.eventBroadcaster == nil {
+		return
+	}
+	c.eventBroadcaster.Write(ev)
+}
+
+func newNetworkEvent(typ EventType, n *network) Event {
+	return Event{
+		Type:        typ,
+		NetworkID:   n.ID(),
+		NetworkName: n.Name(),
+	}
+}
+
+func newEndpointEvent(typ EventType, ep *endpoint, sb *sandbox) Event {
+	ev := Event{
+		Type:         typ,
+		EndpointID:   ep.ID(),
+		EndpointName: ep.Name(),
+	}
+
+	if n := ep.getNetwork(); n != nil {
+		ev.NetworkID = n.ID()
+		ev.NetworkName = n.Name()
+	}
+
+	if sb != nil {
+		ev.SandboxID = sb.ID()
+		ev.ContainerID = sb.ContainerID()
+	}
+
+	return ev
+}
@@ -345,6 +345,8 @@ func (a *Allocator) insertBitMask(key SubnetKey, pool *net.IPNet) error {
 	a.Lock()
 	a.addresses[key] = h
 	a.Unlock()
+
+	poolFreeAddresses.With(key.String()).Set(float64(h.Unselected()))
 	return nil
 }
 
@@ -455,6 +457,8 @@ func (a *Allocator) RequestAddress(poolID string, prefAddress net.IP, opts map[s
 		return nil, nil, err
 	}
 
+	poolFreeAddresses.With(k.String()).Set(float64(bm.Unselected()))
+
 	return &net.IPNet{IP: ip, Mask: p.Pool.Mask}, nil, nil
 }
 
@@ -512,7 +516,12 @@ func (a *Allocator) ReleaseAddress(poolID string, address net.IP) error {
 			k.String(), address, poolID, err)
 	}
 
-	return bm.Unset(ipToUint64(h))
+	if err := bm.Unset(ipToUint64(h)); err != nil {
+		return err
+	}
+
+	poolFreeAddresses.With(k.String()).Set(float64(bm.Unselected()))
+	return nil
 }
 
 func (a *Allocator) getAddress(nw *net.IPNet, bitmask *bitseq.Handle, prefAddress net.IP, ipr *AddressRange) (net.IP, error) {
@@ -0,0 +1,8 @@
+package ipam
+
+import (
+	"github.com/docker/libnetwork/metrics"
+)
+
+var poolFreeAddresses = metrics.NewGaugeVec("libnetwork_ipam_pool_free_addresses",
+	"Number of addresses available for allocation, by pool.", "pool")
@@ -322,6 +322,7 @@ func (aSpace *addrSpace) updatePoolDBOnRemoval(k SubnetKey) (func() error, error
 					if err != nil {
 						return types.InternalErrorf("could not find bitmask in datastore for pool %s removal: %v", k.String(), err)
 					}
+					poolFreeAddresses.Delete(k.String())
 					return bm.Destroy()
 				}, nil
 			}
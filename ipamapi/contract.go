@@ -6,6 +6,7 @@ import (
 
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
 )
 
 /********************
@@ -79,6 +80,14 @@ type Ipam interface {
 	ReleaseAddress(string, net.IP) error
 }
 
+// ContextIpam is an optional interface an IPAM driver can implement when its
+// methods may block, as for the remote plugins.
+type ContextIpam interface {
+	// WithContext returns a copy of the driver whose methods give up and
+	// return an error once ctx is done.
+	WithContext(ctx context.Context) Ipam
+}
+
 // Capability represents the requirements and capabilities of the IPAM driver
 type Capability struct {
 	// Whether on address request, libnetwork must
@@ -9,12 +9,15 @@ import (
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/ipams/remote/api"
+	"github.com/docker/libnetwork/pluginutils"
 	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
 )
 
 type allocator struct {
 	endpoint *plugins.Client
 	name     string
+	ctx      context.Context
 }
 
 // PluginResponse is the interface for the plugin request responses
@@ -49,7 +52,7 @@ func Init(cb ipamapi.Callback, l, g interface{}) error {
 
 func (a *allocator) call(methodName string, arg interface{}, retVal PluginResponse) error {
 	method := ipamapi.PluginEndpointType + "." + methodName
-	err := a.endpoint.Call(method, arg, retVal)
+	err := pluginutils.CallContext(a.ctx, method, func() error { return a.endpoint.Call(method, arg, retVal) })
 	if err != nil {
 		return err
 	}
@@ -59,6 +62,14 @@ func (a *allocator) call(methodName string, arg interface{}, retVal PluginRespon
 	return nil
 }
 
+// WithContext returns a copy of the allocator whose plugin calls are
+// abandoned once ctx is done
+func (a *allocator) WithContext(ctx context.Context) ipamapi.Ipam {
+	na := *a
+	na.ctx = ctx
+	return &na
+}
+
 func (a *allocator) getCapabilities() (*ipamapi.Capability, error) {
 	var res api.GetCapabilityResponse
 	if err := a.call("GetCapabilities", nil, &res); err != nil {
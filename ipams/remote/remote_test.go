@@ -10,10 +10,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/pkg/plugins"
 	"github.com/docker/libnetwork/ipamapi"
 	_ "github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
 )
 
 func decodeToMap(r *http.Request) (res map[string]interface{}, err error) {
@@ -139,6 +142,38 @@ func TestGetDefaultAddressSpaces(t *testing.T) {
 	}
 }
 
+func TestRequestPoolContextTimeout(t *testing.T) {
+	var plugin = "test-ipam-driver-timeout"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	release := make(chan struct{})
+	defer close(release)
+	handle(t, mux, "RequestPool", func(msg map[string]interface{}) interface{} {
+		<-release
+		return map[string]interface{}{}
+	})
+
+	p, err := plugins.Get(plugin, ipamapi.PluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newAllocator(plugin, p.Client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, _, _, err = d.(ipamapi.ContextIpam).WithContext(ctx).RequestPool("white", "172.18.0.0/16", "", nil, false)
+	if err == nil {
+		t.Fatalf("Expected error from hung ipam driver")
+	}
+	if _, ok := err.(types.TimeoutError); !ok {
+		t.Fatalf("Unexpected error type from hung ipam driver: %v", err)
+	}
+}
+
 func TestRemoteDriver(t *testing.T) {
 	var plugin = "test-ipam-driver"
 
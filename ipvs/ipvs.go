@@ -94,6 +94,12 @@ func (i *Handle) DelService(s *Service) error {
 	return i.doCmd(s, nil, ipvsCmdDelService)
 }
 
+// GetServices returns all the ipvs services present in the passed
+// handle.
+func (i *Handle) GetServices() ([]*Service, error) {
+	return i.doGetServicesCmd()
+}
+
 // NewDestination creates a new real server in the passed ipvs
 // service which should already be existing in the passed handle.
 func (i *Handle) NewDestination(s *Service, d *Destination) error {
@@ -164,6 +164,14 @@ func TestService(t *testing.T) {
 			err := i.NewService(&s)
 			assert.NoError(t, err)
 			checkService(t, true, protocol, schedMethod, serviceAddress)
+
+			svcs, err := i.GetServices()
+			assert.NoError(t, err)
+			assert.Len(t, svcs, 1)
+			assert.Equal(t, s.FWMark, svcs[0].FWMark)
+			assert.Equal(t, s.Port, svcs[0].Port)
+			assert.Equal(t, schedMethod, svcs[0].SchedName)
+
 			var lastMethod string
 			for _, updateSchedMethod := range schedMethods {
 				if updateSchedMethod == schedMethod {
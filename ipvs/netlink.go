@@ -131,6 +131,79 @@ func (i *Handle) doCmd(s *Service, d *Destination, cmd uint8) error {
 	return nil
 }
 
+func (i *Handle) doGetServicesCmd() ([]*Service, error) {
+	req := nl.NewNetlinkRequest(ipvsFamily, syscall.NLM_F_DUMP)
+	req.AddData(&genlMsgHdr{cmd: ipvsCmdGetService, version: 1})
+
+	msgs, err := execute(i.sock, req, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Service
+	for _, m := range msgs {
+		hdr := deserializeGenlMsg(m)
+		attrs, err := nl.ParseRouteAttr(m[hdr.Len():])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attr := range attrs {
+			if int(attr.Attr.Type) != ipvsCmdAttrService {
+				continue
+			}
+			s, err := assembleService(attr.Value)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, s)
+		}
+	}
+
+	return res, nil
+}
+
+func assembleService(b []byte) (*Service, error) {
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{}
+	for _, attr := range attrs {
+		switch int(attr.Attr.Type) {
+		case ipvsSvcAttrAddressFamily:
+			s.AddressFamily = native.Uint16(attr.Value)
+		case ipvsSvcAttrProtocol:
+			s.Protocol = native.Uint16(attr.Value)
+		case ipvsSvcAttrAddress:
+			s.Address = net.IP(attr.Value)
+		case ipvsSvcAttrPort:
+			s.Port = binary.BigEndian.Uint16(attr.Value)
+		case ipvsSvcAttrFWMark:
+			s.FWMark = native.Uint32(attr.Value)
+		case ipvsSvcAttrSchedName:
+			s.SchedName = nl.BytesToString(attr.Value)
+		case ipvsSvcAttrFlags:
+			s.Flags = native.Uint32(attr.Value)
+		case ipvsSvcAttrTimeout:
+			s.Timeout = native.Uint32(attr.Value)
+		case ipvsSvcAttrNetmask:
+			s.Netmask = native.Uint32(attr.Value)
+		case ipvsSvcAttrPEName:
+			s.PEName = nl.BytesToString(attr.Value)
+		}
+	}
+
+	// The kernel always reports a 16 byte address. Trim it down for
+	// IPv4 services.
+	if s.AddressFamily == nl.FAMILY_V4 && len(s.Address) == net.IPv6len {
+		s.Address = s.Address[:net.IPv4len]
+	}
+
+	return s, nil
+}
+
 func getIPVSFamily() (int, error) {
 	sock, err := nl.GetNetlinkSocketAt(netns.None(), netns.None(), syscall.NETLINK_GENERIC)
 	if err != nil {
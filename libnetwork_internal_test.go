@@ -3,8 +3,15 @@ package libnetwork
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
@@ -13,6 +20,7 @@ import (
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
 )
 
 func TestNetworkMarshalling(t *testing.T) {
@@ -24,6 +32,11 @@ func TestNetworkMarshalling(t *testing.T) {
 		networkType: "bridge",
 		enableIPv6:  true,
 		persist:     true,
+		quota:       NetworkQuota{MaxEndpoints: 10, MaxAddresses: 20},
+		dnsForwardRules: map[string][]string{
+			"corp.example.": {"10.0.0.53", "10.0.1.53"},
+		},
+		dnsDomain: "svc.example",
 		ipamOptions: map[string]string{
 			netlabel.MacAddress: "a:b:c:d:e:f",
 			"primary":           "",
@@ -129,11 +142,12 @@ func TestNetworkMarshalling(t *testing.T) {
 
 	if n.name != nn.name || n.id != nn.id || n.networkType != nn.networkType || n.ipamType != nn.ipamType ||
 		n.addrSpace != nn.addrSpace || n.enableIPv6 != nn.enableIPv6 ||
-		n.persist != nn.persist || !compareIpamConfList(n.ipamV4Config, nn.ipamV4Config) ||
+		n.persist != nn.persist || n.quota != nn.quota || n.dnsDomain != nn.dnsDomain || !compareIpamConfList(n.ipamV4Config, nn.ipamV4Config) ||
 		!compareIpamInfoList(n.ipamV4Info, nn.ipamV4Info) || !compareIpamConfList(n.ipamV6Config, nn.ipamV6Config) ||
 		!compareIpamInfoList(n.ipamV6Info, nn.ipamV6Info) ||
 		!compareStringMaps(n.ipamOptions, nn.ipamOptions) ||
-		!compareStringMaps(n.labels, nn.labels) {
+		!compareStringMaps(n.labels, nn.labels) ||
+		!reflect.DeepEqual(n.dnsForwardRules, nn.dnsForwardRules) {
 		t.Fatalf("JSON marsh/unmarsh failed."+
 			"\nOriginal:\n%#v\nDecoded:\n%#v"+
 			"\nOriginal ipamV4Conf: %#v\n\nDecoded ipamV4Conf: %#v"+
@@ -173,17 +187,24 @@ func TestEndpointMarshalling(t *testing.T) {
 	nw6.IP = ip
 
 	e := &endpoint{
-		name:      "Bau",
-		id:        "efghijklmno",
-		sandboxID: "ambarabaciccicocco",
-		anonymous: true,
+		name:       "Bau",
+		id:         "efghijklmno",
+		sandboxID:  "ambarabaciccicocco",
+		anonymous:  true,
+		aliasCNAME: true,
+		txtRecords: []string{"version=1.2", "tier=front"},
 		iface: &endpointInterface{
 			mac: []byte{11, 12, 13, 14, 15, 16},
 			addr: &net.IPNet{
 				IP:   net.IP{10, 0, 1, 23},
 				Mask: net.IPMask{255, 255, 255, 0},
 			},
-			addrv6:    nw6,
+			addrv6: nw6,
+			addrs: []*net.IPNet{
+				{IP: net.IP{10, 0, 1, 24}, Mask: net.IPMask{255, 255, 255, 0}},
+				{IP: net.IP{10, 0, 1, 25}, Mask: net.IPMask{255, 255, 255, 0}},
+			},
+			addrsv6:   []*net.IPNet{{IP: net.ParseIP("2001:db8:4003::123"), Mask: nw6.Mask}},
 			srcName:   "veth12ab1314",
 			dstPrefix: "eth",
 			v4PoolID:  "poolpool",
@@ -202,11 +223,49 @@ func TestEndpointMarshalling(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if e.name != ee.name || e.id != ee.id || e.sandboxID != ee.sandboxID || !compareEndpointInterface(e.iface, ee.iface) || e.anonymous != ee.anonymous {
+	if e.name != ee.name || e.id != ee.id || e.sandboxID != ee.sandboxID || !compareEndpointInterface(e.iface, ee.iface) || e.anonymous != ee.anonymous ||
+		e.aliasCNAME != ee.aliasCNAME || !reflect.DeepEqual(e.txtRecords, ee.txtRecords) {
 		t.Fatalf("JSON marsh/unmarsh failed.\nOriginal:\n%#v\nDecoded:\n%#v\nOriginal iface: %#v\nDecodediface:\n%#v", e, ee, e.iface, ee.iface)
 	}
 }
 
+func TestEndpointHostsAddresses(t *testing.T) {
+	ep := &endpoint{iface: &endpointInterface{}}
+	if addrs := ep.hostsAddresses(); addrs != nil {
+		t.Fatalf("Expected no address without the interface address, got %v", addrs)
+	}
+
+	mask6 := net.CIDRMask(64, 128)
+	ep.iface = &endpointInterface{
+		addr:    &net.IPNet{IP: net.IP{10, 0, 1, 23}, Mask: net.CIDRMask(24, 32)},
+		addrv6:  &net.IPNet{IP: net.ParseIP("2001:db8::23"), Mask: mask6},
+		addrs:   []*net.IPNet{{IP: net.IP{10, 0, 1, 24}, Mask: net.CIDRMask(24, 32)}},
+		addrsv6: []*net.IPNet{{IP: net.ParseIP("2001:db8::24"), Mask: mask6}, {IP: net.ParseIP("2001:db8::25"), Mask: mask6}},
+	}
+	expected := []string{"10.0.1.23", "10.0.1.24", "2001:db8::23", "2001:db8::24", "2001:db8::25"}
+	if addrs := ep.hostsAddresses(); !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("Expected %v, got %v", expected, addrs)
+	}
+}
+
+func TestNetworkQuotaCheck(t *testing.T) {
+	q := NetworkQuota{MaxEndpoints: 2, MaxAddresses: 3}
+
+	if err := q.check(2, 3); err != nil {
+		t.Fatalf("Unexpected error within quota: %v", err)
+	}
+	for _, c := range [][2]uint64{{3, 1}, {1, 4}} {
+		err := q.check(c[0], c[1])
+		if _, ok := err.(types.ForbiddenError); !ok {
+			t.Fatalf("Expected forbidden error for %d endpoints and %d addresses, got %v", c[0], c[1], err)
+		}
+	}
+
+	if err := (NetworkQuota{}).check(1000, 1000); err != nil {
+		t.Fatalf("Unexpected error with no quota: %v", err)
+	}
+}
+
 func compareEndpointInterface(a, b *endpointInterface) bool {
 	if a == b {
 		return true
@@ -215,7 +274,20 @@ func compareEndpointInterface(a, b *endpointInterface) bool {
 		return false
 	}
 	return a.srcName == b.srcName && a.dstPrefix == b.dstPrefix && a.v4PoolID == b.v4PoolID && a.v6PoolID == b.v6PoolID &&
-		types.CompareIPNet(a.addr, b.addr) && types.CompareIPNet(a.addrv6, b.addrv6)
+		types.CompareIPNet(a.addr, b.addr) && types.CompareIPNet(a.addrv6, b.addrv6) &&
+		compareIPNetList(a.addrs, b.addrs) && compareIPNetList(a.addrsv6, b.addrsv6)
+}
+
+func compareIPNetList(a, b []*net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !types.CompareIPNet(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
 }
 
 func compareIpamConfList(listA, listB []*IpamConf) bool {
@@ -308,7 +380,7 @@ func TestAuxAddresses(t *testing.T) {
 
 		n.ipamV4Config = []*IpamConf{{PreferredPool: i.masterPool, SubPool: i.subPool, AuxAddresses: i.auxAddresses}}
 
-		err = n.ipamAllocate()
+		err = n.ipamAllocate(context.Background())
 
 		if i.good != (err == nil) {
 			t.Fatalf("Unexpected result for %v: %v", i, err)
@@ -318,6 +390,222 @@ func TestAuxAddresses(t *testing.T) {
 	}
 }
 
+func TestValidateIpamConfigs(t *testing.T) {
+	n := &network{ipamType: ipamapi.DefaultIPAM, networkType: "bridge"}
+
+	input := []struct {
+		ipVer int
+		cfg   IpamConf
+		good  bool
+	}{
+		{4, IpamConf{}, true},
+		{4, IpamConf{PreferredPool: "192.168.0.0/16", SubPool: "192.168.1.0/24", Gateway: "192.168.0.1"}, true},
+		{4, IpamConf{PreferredPool: "192.168.0.0/16", AuxAddresses: map[string]string{"goodOne": "192.168.2.2"}}, true},
+		{4, IpamConf{PreferredPool: "192.168.0.0/16", AuxAddresses: map[string]string{"badOne": "192.169.2.2"}}, false},
+		{4, IpamConf{PreferredPool: "192.168.0.0/16", SubPool: "192.169.1.0/24"}, false},
+		{4, IpamConf{PreferredPool: "192.168.1.0/24", SubPool: "192.168.0.0/16"}, false},
+		{4, IpamConf{PreferredPool: "192.168.0.0/16", Gateway: "10.0.0.1"}, false},
+		{4, IpamConf{SubPool: "192.168.1.0/24"}, false},
+		{4, IpamConf{PreferredPool: "192.168.0.0/33"}, false},
+		{4, IpamConf{PreferredPool: "fe90::/64"}, false},
+		{6, IpamConf{PreferredPool: "fe90::/64", Gateway: "fe90::1"}, true},
+	}
+
+	for _, i := range input {
+		cfg := i.cfg
+		infoList, err := n.validateIpamConfigs(i.ipVer, []*IpamConf{&cfg})
+		if i.good != (err == nil) {
+			t.Fatalf("Unexpected result for %v: %v", i, err)
+		}
+		if err != nil {
+			if _, ok := err.(types.BadRequestError); !ok {
+				t.Fatalf("Unexpected error type for %v: %v", i, err)
+			}
+			continue
+		}
+		if cfg.PreferredPool != "" && (len(infoList) != 1 || infoList[0].Pool.String() != cfg.PreferredPool) {
+			t.Fatalf("Unexpected IPAM info for %v: %v", i, infoList)
+		}
+	}
+
+	cfgList := []*IpamConf{{PreferredPool: "192.168.0.0/16"}, {PreferredPool: "192.168.1.0/24"}}
+	if _, err := n.validateIpamConfigs(4, cfgList); err == nil {
+		t.Fatal("Expected failure for overlapping pools")
+	}
+}
+
+func TestDNSDomain(t *testing.T) {
+	for _, d := range []string{"", "svc.example", "Example.COM.", "a-b.c"} {
+		n := &network{name: "net1"}
+		NetworkOptionDNSDomain(d)(n)
+		if err := n.validateDNSDomain(); err != nil {
+			t.Fatalf("Unexpected failure for DNS domain %q: %v", d, err)
+		}
+	}
+	for _, d := range []string{"a..b", "a b.c", strings.Repeat("a", 64) + ".com"} {
+		n := &network{name: "net1"}
+		NetworkOptionDNSDomain(d)(n)
+		err := n.validateDNSDomain()
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Expected BadRequestError for DNS domain %q, got: %v", d, err)
+		}
+	}
+
+	n := &network{name: "net1"}
+	if n.dnsSuffix() != "net1" || !n.matchesDNSSuffix("net1") || n.matchesDNSSuffix("svc.example") {
+		t.Fatal("Network without DNS domain must resolve with the network name")
+	}
+	NetworkOptionDNSDomain("Svc.Example.")(n)
+	if n.DNSDomain() != "svc.example" || n.dnsSuffix() != "svc.example" {
+		t.Fatalf("Unexpected DNS domain: %s", n.DNSDomain())
+	}
+	if !n.matchesDNSSuffix("net1") || !n.matchesDNSSuffix("SVC.example") || n.matchesDNSSuffix("example") {
+		t.Fatal("Network must match both its name and its DNS domain")
+	}
+}
+
+func TestMergeSearchDomains(t *testing.T) {
+	cases := []struct {
+		domains  []string
+		search   []string
+		added    []string
+		merged   []string
+		nowAdded []string
+	}{
+		{nil, nil, nil, nil, nil},
+		{nil, []string{"example.com"}, nil, []string{"example.com"}, nil},
+		{[]string{"svc.example"}, []string{"example.com"}, nil, []string{"svc.example", "example.com"}, []string{"svc.example"}},
+		{[]string{"svc.example"}, []string{"svc.example", "example.com"}, []string{"svc.example"}, []string{"svc.example", "example.com"}, []string{"svc.example"}},
+		{nil, []string{"svc.example", "example.com"}, []string{"svc.example"}, []string{"example.com"}, nil},
+		{[]string{"b.example"}, []string{"A.example.", "example.com"}, []string{"a.example"}, []string{"b.example", "example.com"}, []string{"b.example"}},
+		// Domains of the host or of the sandbox options are not added for the networks
+		{[]string{"svc.example"}, []string{"example.com", "svc.example"}, nil, []string{"svc.example", "example.com"}, nil},
+		{nil, []string{"svc.example", "example.com"}, nil, []string{"svc.example", "example.com"}, nil},
+	}
+	for _, c := range cases {
+		merged, nowAdded := mergeSearchDomains(c.domains, c.search, c.added)
+		if !reflect.DeepEqual(merged, c.merged) || !reflect.DeepEqual(nowAdded, c.nowAdded) {
+			t.Fatalf("mergeSearchDomains(%v, %v, %v): expected %v %v, got %v %v", c.domains, c.search, c.added, c.merged, c.nowAdded, merged, nowAdded)
+		}
+	}
+}
+
+func TestValidateServiceMode(t *testing.T) {
+	n := &network{name: "net1"}
+	ports := []*PortConfig{{Name: "http", Protocol: ProtocolTCP, TargetPort: 80}}
+
+	valid := []*endpoint{
+		{name: "ep1"},
+		{name: "ep1", svcName: "web", svcMode: ServiceModeVIP, virtualIP: net.ParseIP("10.0.0.2")},
+		{name: "ep1", svcName: "web", svcMode: ServiceModeDNSRR, ingressPorts: ports},
+	}
+	for _, ep := range valid {
+		if err := ep.validateServiceMode(n); err != nil {
+			t.Fatalf("Unexpected failure for service mode %q: %v", ep.svcMode, err)
+		}
+	}
+
+	invalid := []*endpoint{
+		{name: "ep1", svcName: "web", svcMode: "bogus"},
+		{name: "ep1", svcName: "web", svcMode: ServiceModeDNSRR, virtualIP: net.ParseIP("10.0.0.2")},
+		{name: "ep1", svcName: "web", svcMode: ServiceModeDNSRR,
+			ingressPorts: []*PortConfig{{Name: "http", Protocol: ProtocolTCP, TargetPort: 80, PublishedPort: 8080}}},
+	}
+	for _, ep := range invalid {
+		if _, ok := ep.validateServiceMode(n).(types.BadRequestError); !ok {
+			t.Fatalf("Expected BadRequestError for endpoint %#v", ep)
+		}
+	}
+
+	ep := &endpoint{name: "ep1", svcName: "web", svcMode: ServiceModeDNSRR}
+	if _, ok := ep.validateServiceMode(&network{name: "ingress", ingress: true}).(types.BadRequestError); !ok {
+		t.Fatal("Expected BadRequestError for a DNS round robin service on the ingress network")
+	}
+}
+
+func TestDNSRRServicePorts(t *testing.T) {
+	c := &controller{svcRecords: make(map[string]svcInfo)}
+	n := &network{id: "nid", name: "net1", ctrlr: c}
+	ports := []*PortConfig{
+		{Name: "http", Protocol: ProtocolTCP, TargetPort: 80},
+		{Name: "dns", Protocol: ProtocolUDP, TargetPort: 53},
+		{Protocol: ProtocolTCP, TargetPort: 8080},
+	}
+	ip1, ip2 := net.ParseIP("192.168.10.2"), net.ParseIP("192.168.10.3")
+
+	n.addSvcPortRecords("web", []string{"www"}, ports, ip1)
+	n.addSvcPortRecords("web", []string{"www"}, ports, ip2)
+	n.addSvcPortRecords("web", []string{"www"}, ports, ip2)
+
+	for _, name := range []string{"web", "www"} {
+		svcs := c.svcRecords["nid"].service[name]
+		if len(svcs) != 2 {
+			t.Fatalf("Expected the records of 2 named ports for %s, got %d", name, len(svcs))
+		}
+		for _, svc := range svcs {
+			if len(svc.target) != 2 {
+				t.Fatalf("Expected 2 targets for %s.%s.%s, got %d", svc.portName, svc.proto, name, len(svc.target))
+			}
+		}
+		if svcs[0].portName != "_http" || svcs[0].proto != "_tcp" || svcs[0].target[0].port != 80 ||
+			svcs[1].portName != "_dns" || svcs[1].proto != "_udp" || svcs[1].target[0].port != 53 {
+			t.Fatalf("Unexpected port records for %s: %#v", name, svcs)
+		}
+	}
+
+	n.deleteSvcPortRecords("web", []string{"www"}, ports, ip1)
+	for _, svc := range c.svcRecords["nid"].service["web"] {
+		if len(svc.target) != 1 || !svc.target[0].ip.Equal(ip2) {
+			t.Fatalf("Unexpected targets left for %s: %#v", svc.portName, svc.target)
+		}
+	}
+
+	n.deleteSvcPortRecords("web", []string{"www"}, ports, ip2)
+	if len(c.svcRecords["nid"].service) != 0 {
+		t.Fatalf("Expected no port records left, got %#v", c.svcRecords["nid"].service)
+	}
+}
+
+func TestResolveServiceConcurrentUpdate(t *testing.T) {
+	c := &controller{svcRecords: make(map[string]svcInfo)}
+	n := &network{id: "nid", name: "net1", ctrlr: c}
+	// The endpoint holds another copy of the network, as when it is read
+	// from the store, so that the network lock does not order the accesses
+	sb := &sandbox{endpoints: epHeap{{id: "ep1", network: &network{id: "nid", name: "net1", ctrlr: c}}}}
+	ports := []*PortConfig{{Name: "http", Protocol: ProtocolTCP, TargetPort: 80}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ip := net.IPv4(192, 168, 10, byte(i+2))
+			n.addSvcRecords(fmt.Sprintf("web.%d", i), ip, nil, true)
+			n.addSvcPortRecords("web", nil, ports, ip)
+			runtime.Gosched()
+		}
+	}()
+
+	for resolving := true; resolving; {
+		select {
+		case <-done:
+			resolving = false
+		default:
+		}
+		if _, _, err := sb.ResolveService("_http._tcp.web"); err != nil {
+			t.Fatal(err)
+		}
+		runtime.Gosched()
+	}
+
+	srv, ip, err := sb.ResolveService("_http._tcp.web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srv) != 100 || len(ip) != 100 || srv[0].Target != "web.0.net1." {
+		t.Fatalf("Unexpected resolution: %d records, %d addresses", len(srv), len(ip))
+	}
+}
+
 func TestSRVServiceQuery(t *testing.T) {
 	c, err := New()
 	if err != nil {
@@ -481,6 +769,77 @@ func TestIpamReleaseOnNetDriverFailures(t *testing.T) {
 	}
 }
 
+func TestReconcileEndpointCount(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	n, err := c.NewNetwork("bridge", "reconcilenet", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete(false)
+
+	ec := n.(*network).getEpCnt()
+	if err := ec.setCounts(5, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	findDrift := func(r *ReconcileReport) *Drift {
+		for _, d := range r.Drifts {
+			if d.Kind == DriftEndpointCount && d.Resource == n.ID() {
+				return d
+			}
+		}
+		return nil
+	}
+
+	report, err := c.Reconcile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := findDrift(report)
+	if d == nil {
+		t.Fatalf("expected endpoint count drift in dry run, got %v", report.Drifts)
+	}
+	if d.Repaired || ec.EndpointCnt() != 5 {
+		t.Fatalf("dry run must not repair the endpoint count")
+	}
+
+	report, err = c.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := findDrift(report); d == nil || !d.Repaired {
+		t.Fatalf("expected endpoint count drift to be repaired, got %v", d)
+	}
+	expected := QuotaUsage{Endpoints: 1, Addresses: ep.(*endpoint).addressCount()}
+	if usage := n.(*network).QuotaUsage(); usage != expected {
+		t.Fatalf("expected usage %v after repair, got %v", expected, usage)
+	}
+
+	report, err = c.Reconcile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := findDrift(report); d != nil {
+		t.Fatalf("unexpected endpoint count drift after repair: %v", d)
+	}
+}
+
 var badDriverName = "bad network driver"
 
 type badDriver struct {
@@ -543,3 +902,378 @@ func (b *badDriver) NetworkFree(id string) error {
 
 func (b *badDriver) EventNotify(etype driverapi.EventType, nid, tableName, key string, value []byte) {
 }
+
+func TestStopWithIdleSubscriber(t *testing.T) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := nc.(*controller)
+
+	// A subscriber which stopped reading its events
+	ch, cancel := c.Subscribe(EventFilter{})
+	for i := 0; i < 5; i++ {
+		c.publishEvent(Event{Type: EventNetworkCreate})
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		c.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked on a subscriber which is not reading")
+	}
+
+	for range ch {
+	}
+	cancel()
+}
+
+// ctxIpam is an ipam driver whose context bound copy fails every call once
+// the context is done, as the remote ipam does
+type ctxIpam struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	released []string
+}
+
+func (i *ctxIpam) WithContext(ctx context.Context) ipamapi.Ipam {
+	return &ctxIpam{ctx: ctx, cancel: i.cancel}
+}
+
+func (i *ctxIpam) done() error {
+	if i.ctx != nil {
+		return i.ctx.Err()
+	}
+	return nil
+}
+
+func (i *ctxIpam) GetDefaultAddressSpaces() (string, string, error) {
+	return "local", "global", nil
+}
+
+func (i *ctxIpam) RequestPool(addressSpace, pool, subPool string, options map[string]string, v6 bool) (string, *net.IPNet, map[string]string, error) {
+	if err := i.done(); err != nil {
+		return "", nil, nil, err
+	}
+	// The deadline expires while the network is being allocated
+	i.cancel()
+	_, ipNet, _ := net.ParseCIDR(pool)
+	return "pool-" + pool, ipNet, nil, nil
+}
+
+func (i *ctxIpam) ReleasePool(poolID string) error {
+	if err := i.done(); err != nil {
+		return err
+	}
+	i.released = append(i.released, poolID)
+	return nil
+}
+
+func (i *ctxIpam) RequestAddress(poolID string, ip net.IP, opts map[string]string) (*net.IPNet, map[string]string, error) {
+	if err := i.done(); err != nil {
+		return nil, nil, err
+	}
+	return nil, nil, fmt.Errorf("unexpected address request")
+}
+
+func (i *ctxIpam) ReleaseAddress(poolID string, ip net.IP) error {
+	return i.done()
+}
+
+func (i *ctxIpam) DiscoverNew(dType discoverapi.DiscoveryType, data interface{}) error {
+	return nil
+}
+
+func (i *ctxIpam) DiscoverDelete(dType discoverapi.DiscoveryType, data interface{}) error {
+	return nil
+}
+
+func TestIpamReleaseAfterContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ipam := &ctxIpam{cancel: cancel}
+	n := &network{name: "ctxnet", networkType: "bridge"}
+	cfgList := []*IpamConf{{PreferredPool: "10.99.0.0/24"}}
+
+	if _, err := n.ipamAllocatePools(ctx, 4, ipam, cfgList); err == nil {
+		t.Fatal("Expected the gateway request to fail once the context is done")
+	}
+
+	if len(ipam.released) != 1 || ipam.released[0] != "pool-10.99.0.0/24" {
+		t.Fatalf("Expected the pool to be released without the context, got %v", ipam.released)
+	}
+}
+
+var dryRunDriverName = "dry run network driver"
+
+// dryRunDriver counts the network creations and validations
+type dryRunDriver struct {
+	badDriver
+	created   int
+	validated int
+}
+
+func (d *dryRunDriver) CreateNetwork(nid string, options map[string]interface{}, nInfo driverapi.NetworkInfo, ipV4Data, ipV6Data []driverapi.IPAMData) error {
+	d.created++
+	return nil
+}
+
+func (d *dryRunDriver) ValidateNetwork(nid string, options map[string]interface{}, ipV4Data, ipV6Data []driverapi.IPAMData) error {
+	d.validated++
+	return nil
+}
+
+func TestNetworkDryRun(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	d := &dryRunDriver{}
+	cc := c.(*controller)
+	if err := cc.drvRegistry.AddDriver(dryRunDriverName, func(reg driverapi.DriverCallback, opt map[string]interface{}) error {
+		return reg.RegisterDriver(dryRunDriverName, d, driverapi.Capability{DataScope: datastore.LocalScope})
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ipamOpt := NetworkOptionIpam(ipamapi.DefaultIPAM, "", []*IpamConf{{PreferredPool: "10.35.0.0/16", Gateway: "10.35.255.254"}}, nil, nil)
+	n, err := c.NewNetwork(dryRunDriverName, "dryrunnet", "", ipamOpt, NetworkOptionDryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v4, _ := n.Info().IpamInfo(); len(v4) != 1 || v4[0].Pool.String() != "10.35.0.0/16" {
+		t.Fatalf("Unexpected IPAM info of the would-be network: %v", v4)
+	}
+	if d.validated != 1 || d.created != 0 {
+		t.Fatalf("Expected the network to be validated only, got %d validations and %d creations", d.validated, d.created)
+	}
+
+	if _, err := c.NetworkByName("dryrunnet"); err == nil {
+		t.Fatal("The would-be network must not be known to the controller")
+	}
+	networks, err := cc.getNetworksFromStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nw := range networks {
+		if nw.Name() == "dryrunnet" {
+			t.Fatal("The would-be network must not be stored")
+		}
+	}
+
+	// The pool and the gateway were not allocated
+	n, err = c.NewNetwork(dryRunDriverName, "realnet", "", ipamOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+	if d.created != 1 {
+		t.Fatalf("Expected the network to be created, got %d creations", d.created)
+	}
+}
+
+var joinDriverName = "join network driver"
+
+// joinDriver accepts the endpoints and the joins, without an interface
+type joinDriver struct {
+	badDriver
+}
+
+func (d *joinDriver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo, options map[string]interface{}) error {
+	return nil
+}
+
+func (d *joinDriver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	return nil
+}
+
+func TestSecondaryAddresses(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	dir, err := ioutil.TempDir("", "secondary-addresses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	cc := c.(*controller)
+	if err := cc.drvRegistry.AddDriver(joinDriverName, func(reg driverapi.DriverCallback, opt map[string]interface{}) error {
+		return reg.RegisterDriver(joinDriverName, &joinDriver{}, driverapi.Capability{DataScope: datastore.LocalScope})
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The network needs neither the gateway network nor the resolver
+	n, err := c.NewNetwork(joinDriverName, "secnet", "",
+		NetworkOptionInternalNetwork(),
+		NetworkOptionEnableIPv6(true),
+		NetworkOptionIpam(ipamapi.DefaultIPAM, "",
+			[]*IpamConf{{PreferredPool: "10.36.0.0/24", Gateway: "10.36.0.1"}},
+			[]*IpamConf{{PreferredPool: "2001:db8:36::/64", Gateway: "2001:db8:36::1"}}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	ep, err := n.CreateEndpoint("ep", CreateOptionSecondaryAddresses(2, 1), CreateOptionDisableResolution())
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface := ep.Info().Iface()
+	if len(iface.SecondaryAddresses()) != 2 || len(iface.SecondaryAddressesIPv6()) != 1 || iface.AddressIPv6() == nil {
+		t.Fatalf("Unexpected addresses of the endpoint: %v %v %v %v", iface.Address(), iface.SecondaryAddresses(), iface.AddressIPv6(), iface.SecondaryAddressesIPv6())
+	}
+	addresses := []*net.IPNet{iface.Address(), iface.AddressIPv6()}
+	addresses = append(addresses, iface.SecondaryAddresses()...)
+	addresses = append(addresses, iface.SecondaryAddressesIPv6()...)
+
+	hostsPath := filepath.Join(dir, "hosts")
+	sb, err := c.NewSandbox("c1", OptionUseDefaultSandbox(), OptionHostname("c1"), OptionHostsPath(hostsPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Delete()
+
+	if err := ep.Join(sb); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(hostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range addresses {
+		if !strings.Contains(string(content), fmt.Sprintf("%s\tc1\n", addr.IP)) {
+			t.Fatalf("Address %s not programmed in the hosts file:\n%s", addr.IP, content)
+		}
+	}
+
+	if err := ep.Leave(sb); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Delete(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The released addresses can be requested again
+	ipam, _, err := cc.getIPAMDriver(ipamapi.DefaultIPAM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v4, v6 := n.Info().IpamInfo()
+	for _, addr := range addresses {
+		poolID := v4[0].PoolID
+		if addr.IP.To4() == nil {
+			poolID = v6[0].PoolID
+		}
+		if _, _, err := ipam.RequestAddress(poolID, addr.IP, nil); err != nil {
+			t.Fatalf("Address %s not released: %v", addr.IP, err)
+		}
+		if err := ipam.ReleaseAddress(poolID, addr.IP); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNetworkQuotaUpdate(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	cc := c.(*controller)
+	if err := cc.drvRegistry.AddDriver(joinDriverName, func(reg driverapi.DriverCallback, opt map[string]interface{}) error {
+		return reg.RegisterDriver(joinDriverName, &joinDriver{}, driverapi.Capability{DataScope: datastore.LocalScope})
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ipamOpt := NetworkOptionIpam(ipamapi.DefaultIPAM, "", []*IpamConf{{PreferredPool: "10.37.0.0/24", Gateway: "10.37.0.1"}}, nil, nil)
+	negative := int64(-1)
+	_, err = c.NewNetwork(joinDriverName, "quotanet", "", ipamOpt, NetworkOptionQuota(NetworkQuota{MaxEndpoints: uint64(negative)}))
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Expected BadRequestError for a negative limit at creation, got %v", err)
+	}
+
+	n, err := c.NewNetwork(joinDriverName, "quotanet", "", ipamOpt, NetworkOptionInternalNetwork())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	sb, err := c.NewSandbox("c1", OptionUseDefaultSandbox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Delete()
+	for _, name := range []string{"ep1", "ep2"} {
+		ep, err := n.CreateEndpoint(name, CreateOptionDisableResolution())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Delete(true)
+		if err := ep.Join(sb); err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Leave(sb)
+	}
+
+	err = n.Update(NetworkOptionQuota(NetworkQuota{MaxAddresses: uint64(negative)}))
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Expected BadRequestError for a negative limit, got %v", err)
+	}
+	for _, q := range []NetworkQuota{{MaxEndpoints: 1}, {MaxAddresses: 1}, {MaxEndpointsPerSandbox: 1}} {
+		err = n.Update(NetworkOptionQuota(q))
+		if _, ok := err.(types.ForbiddenError); !ok {
+			t.Fatalf("Expected ForbiddenError for quota %+v below the usage, got %v", q, err)
+		}
+	}
+
+	quota := NetworkQuota{MaxEndpoints: 2, MaxEndpointsPerSandbox: 2, MaxAddresses: 2}
+	if err := n.Update(NetworkOptionQuota(quota)); err != nil {
+		t.Fatal(err)
+	}
+	un, err := c.NetworkByID(n.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := un.Info().Quota(); q != quota {
+		t.Fatalf("Expected quota %+v, got %+v", quota, q)
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/plugins"
@@ -24,6 +25,7 @@ import (
 	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/bridge"
 	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
@@ -34,6 +36,7 @@ import (
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -368,6 +371,207 @@ func TestBridgeIpv6FromMac(t *testing.T) {
 	}
 }
 
+func TestControllerEvents(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	evCh, cancel := controller.Subscribe(libnetwork.EventFilter{})
+	defer cancel()
+
+	// A subscriber which does not read its events
+	idleCh, idleCancel := controller.Subscribe(libnetwork.EventFilter{})
+	defer idleCancel()
+
+	netOption := options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName": "testevents",
+		},
+	}
+	n, err := createTestNetwork(bridgeNetType, "testevents", netOption, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sb, err := controller.NewSandbox("events_container")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Join(sb); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sb.Rename("renamed"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := controller.SandboxDestroy("events_container"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []libnetwork.EventType{
+		libnetwork.EventNetworkCreate,
+		libnetwork.EventEndpointCreate,
+		libnetwork.EventEndpointJoin,
+		libnetwork.EventEndpointRename,
+		libnetwork.EventEndpointLeave,
+		libnetwork.EventEndpointDelete,
+		libnetwork.EventSandboxDestroy,
+		libnetwork.EventNetworkDelete,
+	}
+
+	for _, typ := range expected {
+		select {
+		case ev := <-evCh:
+			if ev.Type != typ {
+				t.Fatalf("Expected event %s, got %s", typ, ev.Type)
+			}
+			if ev.NetworkID != "" && ev.NetworkID != n.ID() {
+				t.Fatalf("Unexpected network id in event %s: %s", ev.Type, ev.NetworkID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for event %s", typ)
+		}
+	}
+
+	// Canceling closes the channel, discarding the undelivered events
+	for _, c := range []struct {
+		ch     <-chan libnetwork.Event
+		cancel func()
+	}{{evCh, cancel}, {idleCh, idleCancel}} {
+		c.cancel()
+		timeout := time.After(5 * time.Second)
+	drain:
+		for {
+			select {
+			case _, ok := <-c.ch:
+				if !ok {
+					break drain
+				}
+			case <-timeout:
+				t.Fatal("Event channel not closed after canceling the subscription")
+			}
+		}
+	}
+}
+
+func TestNetworkUpdate(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	netOption := options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName": "testupdate",
+		},
+	}
+	ipamV4ConfList := []*libnetwork.IpamConf{{PreferredPool: "192.168.110.0/24"}}
+
+	n, err := createTestNetwork(bridgeNetType, "testupdate", netOption, ipamV4ConfList, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	labels := map[string]string{"com.example.team": "blue"}
+	if err := n.Update(libnetwork.NetworkOptionLabels(labels)); err != nil {
+		t.Fatal(err)
+	}
+
+	un, err := controller.NetworkByID(n.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := un.Info().Labels()["com.example.team"]; v != "blue" {
+		t.Fatalf("Expected updated label, got %q", v)
+	}
+
+	// The bridge driver does not accept additional pools
+	ipamV4ConfList = append(ipamV4ConfList, &libnetwork.IpamConf{PreferredPool: "192.168.111.0/24"})
+	err = n.Update(libnetwork.NetworkOptionIpam(ipamapi.DefaultIPAM, "", ipamV4ConfList, nil, nil))
+	if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("Expected NotImplementedError, got %v", err)
+	}
+
+	err = n.Update(libnetwork.NetworkOptionInternalNetwork())
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Expected ForbiddenError, got %v", err)
+	}
+
+	if un, err = controller.NetworkByID(n.ID()); err != nil {
+		t.Fatal(err)
+	}
+	v4Info, _ := un.Info().IpamInfo()
+	if len(v4Info) != 1 {
+		t.Fatalf("Expected the network to keep one IPv4 pool, found %d", len(v4Info))
+	}
+}
+
+func TestNetworkUpdatePolicy(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	opts := map[string]string{bridge.BridgeName: "testpolicy"}
+	n, err := controller.NewNetwork(bridgeNetType, "testpolicy", "",
+		libnetwork.NetworkOptionDriverOpts(opts),
+		libnetwork.NetworkOptionIpam(ipamapi.DefaultIPAM, "", []*libnetwork.IpamConf{{PreferredPool: "192.168.112.0/24"}}, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// The driver gets the complete IPAM data of the network on update
+	policy := `{"DefaultAction":"deny"}`
+	policyOpts := map[string]string{
+		bridge.BridgeName: "testpolicy",
+		bridge.Policy:     policy,
+	}
+	if err := n.Update(libnetwork.NetworkOptionDriverOpts(policyOpts)); err != nil {
+		t.Fatal(err)
+	}
+
+	un, err := controller.NetworkByID(n.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := un.Info().DriverOptions()[bridge.Policy]; v != policy {
+		t.Fatalf("Expected the updated policy in the driver options, got %q", v)
+	}
+
+	// The driver validates the policy
+	invalidOpts := map[string]string{
+		bridge.BridgeName: "testpolicy",
+		bridge.Policy:     `{"DefaultAction":"reject"}`,
+	}
+	err = n.Update(libnetwork.NetworkOptionDriverOpts(invalidOpts))
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Expected BadRequestError, got %v", err)
+	}
+
+	if err := n.Update(libnetwork.NetworkOptionDriverOpts(opts)); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestUnknownDriver(t *testing.T) {
 	if !testutils.IsRunningInContainer() {
 		defer testutils.SetupTestOSContext(t)()
@@ -1188,10 +1392,18 @@ func (f *fakeSandbox) Refresh(opts ...libnetwork.SandboxOption) error {
 	return nil
 }
 
+func (f *fakeSandbox) RefreshWithContext(ctx context.Context, opts ...libnetwork.SandboxOption) error {
+	return nil
+}
+
 func (f *fakeSandbox) Delete() error {
 	return nil
 }
 
+func (f *fakeSandbox) DeleteWithContext(ctx context.Context) error {
+	return nil
+}
+
 func (f *fakeSandbox) Rename(name string) error {
 	return nil
 }
@@ -1216,6 +1428,14 @@ func (f *fakeSandbox) Endpoints() []libnetwork.Endpoint {
 	return nil
 }
 
+func (f *fakeSandbox) DNSQueryLog() ([]libnetwork.DNSQueryLogEntry, error) {
+	return nil, nil
+}
+
+func (f *fakeSandbox) WatchDNSQueryLog() (<-chan libnetwork.DNSQueryLogEntry, func(), error) {
+	return nil, nil, nil
+}
+
 func TestExternalKey(t *testing.T) {
 	externalKeyTest(t, false)
 }
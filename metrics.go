@@ -0,0 +1,39 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/metrics"
+)
+
+var (
+	networkCreateDuration = metrics.NewHistogramVec("libnetwork_network_create_duration_seconds",
+		"Time taken to create a network.", nil, "driver")
+	networkCreateErrors = metrics.NewCounterVec("libnetwork_network_create_errors_total",
+		"Number of failed network creations.", "driver")
+	endpointJoinDuration = metrics.NewHistogramVec("libnetwork_endpoint_join_duration_seconds",
+		"Time taken by a sandbox to join an endpoint.", nil, "driver")
+	endpointJoinErrors = metrics.NewCounterVec("libnetwork_endpoint_join_errors_total",
+		"Number of failed endpoint joins.", "driver")
+
+	resolverQueries = metrics.NewCounterVec("libnetwork_resolver_queries_total",
+		"Number of DNS queries handled by the embedded resolver, by result.", "result")
+	resolverInflightQueries = metrics.NewGauge("libnetwork_resolver_inflight_queries",
+		"Number of DNS queries being forwarded to external servers by the embedded resolver.")
+	resolverForwardDuration = metrics.NewHistogram("libnetwork_resolver_forward_duration_seconds",
+		"Time taken by external DNS servers to answer forwarded queries.", nil)
+	resolverUpstreamFailures = metrics.NewCounterVec("libnetwork_resolver_upstream_failures_total",
+		"Number of failed exchanges of the embedded resolver with external DNS servers, by server.", "server")
+	resolverCacheLookups = metrics.NewCounterVec("libnetwork_resolver_cache_lookups_total",
+		"Number of lookups in the embedded resolver response cache, by result.", "result")
+	resolverCacheEntries = metrics.NewGauge("libnetwork_resolver_cache_entries",
+		"Number of external DNS responses held in the embedded resolver cache.")
+)
+
+// Results of the queries counted by resolverQueries
+const (
+	queryResultLocal     = "local"
+	queryResultForwarded = "forwarded"
+	queryResultCached    = "cached"
+	queryResultDropped   = "dropped"
+	queryResultLimited   = "limited"
+	queryResultFailed    = "failed"
+)
@@ -0,0 +1,432 @@
+// Package metrics provides counters, gauges and histograms which are
+// exposed over http in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, suited for
+// timing network operations.
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultRegistry is the registry used by the package level constructors
+// and served by Handler.
+var DefaultRegistry = NewRegistry()
+
+type sample struct {
+	suffix string
+	label  string
+	lvalue string
+	value  float64
+}
+
+type metric interface {
+	samples() []sample
+}
+
+type child struct {
+	values []string
+	m      metric
+}
+
+// family is a metric with a given name and all its label combinations.
+type family struct {
+	name      string
+	help      string
+	typ       string
+	labels    []string
+	newMetric func() metric
+	valueFunc func() float64
+	sync.Mutex
+	children map[string]*child
+}
+
+func (f *family) with(values []string) metric {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	f.Lock()
+	defer f.Unlock()
+
+	c, ok := f.children[key]
+	if !ok {
+		c = &child{values: append([]string(nil), values...), m: f.newMetric()}
+		f.children[key] = c
+	}
+	return c.m
+}
+
+func (f *family) delete(values []string) {
+	f.Lock()
+	delete(f.children, strings.Join(values, "\xff"))
+	f.Unlock()
+}
+
+func (f *family) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escape(f.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+
+	if f.valueFunc != nil {
+		fmt.Fprintf(w, "%s %s\n", f.name, formatValue(f.valueFunc()))
+		return
+	}
+
+	f.Lock()
+	keys := make([]string, 0, len(f.children))
+	for k := range f.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	children := make([]*child, 0, len(keys))
+	for _, k := range keys {
+		children = append(children, f.children[k])
+	}
+	f.Unlock()
+
+	for _, c := range children {
+		for _, s := range c.m.samples() {
+			var pairs []string
+			for i, l := range f.labels {
+				pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", l, escape(c.values[i], true)))
+			}
+			if s.label != "" {
+				pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", s.label, s.lvalue))
+			}
+			labels := ""
+			if len(pairs) > 0 {
+				labels = "{" + strings.Join(pairs, ",") + "}"
+			}
+			fmt.Fprintf(w, "%s%s%s %s\n", f.name, s.suffix, labels, formatValue(s.value))
+		}
+	}
+}
+
+func escape(s string, quote bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quote {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Registry is a set of uniquely named metrics
+type Registry struct {
+	sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+func (r *Registry) register(f *family) *family {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.families[f.name]; ok {
+		panic(fmt.Sprintf("metric %s is already registered", f.name))
+	}
+	f.children = make(map[string]*child)
+	r.families[f.name] = f
+	return f
+}
+
+// Encode writes all the metrics of the registry to w in the Prometheus
+// text exposition format
+func (r *Registry) Encode(w io.Writer) error {
+	r.Lock()
+	names := make([]string, 0, len(r.families))
+	for n := range r.families {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	families := make([]*family, 0, len(names))
+	for _, n := range names {
+		families = append(families, r.families[n])
+	}
+	r.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler returns an http handler serving the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.Encode(w)
+	})
+}
+
+// Handler returns an http handler serving the metrics of the default registry
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// Counter is a value which can only increase
+type Counter struct {
+	sync.Mutex
+	v float64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by v, which must not be negative
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.Lock()
+	c.v += v
+	c.Unlock()
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() float64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.v
+}
+
+func (c *Counter) samples() []sample {
+	return []sample{{value: c.Value()}}
+}
+
+// CounterVec is a set of counters sharing a name and partitioned by labels
+type CounterVec struct {
+	f *family
+}
+
+// NewCounterVec registers a new labeled counter in the registry
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(&family{name: name, help: help, typ: typeCounter, labels: labels,
+		newMetric: func() metric { return &Counter{} }})}
+}
+
+// With returns the counter for the given label values
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.f.with(values).(*Counter)
+}
+
+// NewCounter registers a new counter in the registry
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// Gauge is a value which can go up and down
+type Gauge struct {
+	sync.Mutex
+	v float64
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v float64) {
+	g.Lock()
+	g.v = v
+	g.Unlock()
+}
+
+// Add adds v, which can be negative, to the gauge
+func (g *Gauge) Add(v float64) {
+	g.Lock()
+	g.v += v
+	g.Unlock()
+}
+
+// Inc increments the gauge by one
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec decrements the gauge by one
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 {
+	g.Lock()
+	defer g.Unlock()
+	return g.v
+}
+
+func (g *Gauge) samples() []sample {
+	return []sample{{value: g.Value()}}
+}
+
+// GaugeVec is a set of gauges sharing a name and partitioned by labels
+type GaugeVec struct {
+	f *family
+}
+
+// NewGaugeVec registers a new labeled gauge in the registry
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(&family{name: name, help: help, typ: typeGauge, labels: labels,
+		newMetric: func() metric { return &Gauge{} }})}
+}
+
+// With returns the gauge for the given label values
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.f.with(values).(*Gauge)
+}
+
+// Delete removes the gauge for the given label values
+func (v *GaugeVec) Delete(values ...string) {
+	v.f.delete(values)
+}
+
+// NewGauge registers a new gauge in the registry
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by fn every time
+// the metrics are collected
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, typ: typeGauge, valueFunc: fn})
+}
+
+// Histogram counts observations in configurable buckets
+type Histogram struct {
+	sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe adds an observation to the histogram
+func (h *Histogram) Observe(v float64) {
+	h.Lock()
+	defer h.Unlock()
+
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Since observes the seconds elapsed since start
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) samples() []sample {
+	h.Lock()
+	defer h.Unlock()
+
+	s := make([]sample, 0, len(h.buckets)+3)
+	for i, b := range h.buckets {
+		s = append(s, sample{suffix: "_bucket", label: "le", lvalue: formatValue(b), value: float64(h.counts[i])})
+	}
+	s = append(s,
+		sample{suffix: "_bucket", label: "le", lvalue: "+Inf", value: float64(h.count)},
+		sample{suffix: "_sum", value: h.sum},
+		sample{suffix: "_count", value: float64(h.count)})
+	return s
+}
+
+// HistogramVec is a set of histograms sharing a name and buckets and
+// partitioned by labels
+type HistogramVec struct {
+	f *family
+}
+
+// NewHistogramVec registers a new labeled histogram in the registry. If
+// buckets is nil, DefBuckets are used.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &HistogramVec{r.register(&family{name: name, help: help, typ: typeHistogram, labels: labels,
+		newMetric: func() metric { return &Histogram{buckets: b, counts: make([]uint64, len(b))} }})}
+}
+
+// With returns the histogram for the given label values
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.f.with(values).(*Histogram)
+}
+
+// NewHistogram registers a new histogram in the registry
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// NewCounter registers a new counter in the default registry
+func NewCounter(name, help string) *Counter {
+	return DefaultRegistry.NewCounter(name, help)
+}
+
+// NewCounterVec registers a new labeled counter in the default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return DefaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+// NewGauge registers a new gauge in the default registry
+func NewGauge(name, help string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help)
+}
+
+// NewGaugeVec registers a new labeled gauge in the default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return DefaultRegistry.NewGaugeVec(name, help, labels...)
+}
+
+// NewGaugeFunc registers a computed gauge in the default registry
+func NewGaugeFunc(name, help string, fn func() float64) {
+	DefaultRegistry.NewGaugeFunc(name, help, fn)
+}
+
+// NewHistogram registers a new histogram in the default registry
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets)
+}
+
+// NewHistogramVec registers a new labeled histogram in the default registry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return DefaultRegistry.NewHistogramVec(name, help, buckets, labels...)
+}
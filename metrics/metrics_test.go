@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryOutput(t *testing.T) {
+	r := NewRegistry()
+
+	queries := r.NewCounterVec("test_queries_total", "Number of queries.", "result")
+	queries.With("forwarded").Add(3)
+	queries.With("local").Inc()
+
+	inflight := r.NewGauge("test_inflight", "In flight queries.")
+	inflight.Inc()
+	inflight.Inc()
+	inflight.Dec()
+
+	r.NewGaugeFunc("test_computed", "Computed value.", func() float64 { return 42 })
+
+	h := r.NewHistogram("test_duration_seconds", "Duration.", []float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	var b bytes.Buffer
+	if err := r.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_computed Computed value.
+# TYPE test_computed gauge
+test_computed 42
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 5.55
+test_duration_seconds_count 3
+# HELP test_inflight In flight queries.
+# TYPE test_inflight gauge
+test_inflight 1
+# HELP test_queries_total Number of queries.
+# TYPE test_queries_total counter
+test_queries_total{result="forwarded"} 3
+test_queries_total{result="local"} 1
+`
+	if b.String() != expected {
+		t.Fatalf("Unexpected output.\nExpected:\n%s\nGot:\n%s", expected, b.String())
+	}
+}
+
+func TestGaugeVecDelete(t *testing.T) {
+	r := NewRegistry()
+
+	free := r.NewGaugeVec("test_free", "Free addresses.", "pool")
+	free.With(`a"b`).Set(10)
+	free.With("c").Set(5)
+	free.Delete("c")
+
+	var b bytes.Buffer
+	r.Encode(&b)
+
+	if !strings.Contains(b.String(), `test_free{pool="a\"b"} 10`) {
+		t.Fatalf("Expected escaped label value in output:\n%s", b.String())
+	}
+	if strings.Contains(b.String(), `pool="c"`) {
+		t.Fatalf("Deleted gauge still present in output:\n%s", b.String())
+	}
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_dup", "")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected panic on duplicate registration")
+		}
+	}()
+	r.NewGauge("test_dup", "")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Total.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, &http.Request{Method: "GET"})
+
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Unexpected content type %s", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "test_total 1\n") {
+		t.Fatalf("Unexpected body:\n%s", rec.Body.String())
+	}
+}
@@ -27,6 +27,9 @@ const (
 	// ExposedPorts constant represents the container's Exposed Ports
 	ExposedPorts = Prefix + ".endpoint.exposedports"
 
+	// EndpointLabels constant represents the labels of an endpoint
+	EndpointLabels = Prefix + ".endpoint.labels"
+
 	//EnableIPv6 constant represents enabling IPV6 at network level
 	EnableIPv6 = Prefix + ".enable_ipv6"
 
@@ -47,6 +50,15 @@ const (
 
 	// Internal constant represents that the network is internal which disables default gateway service
 	Internal = Prefix + ".internal"
+
+	// NodeLabelPrefix constant marks the labels a node advertises to the gossip cluster
+	NodeLabelPrefix = Prefix + ".node"
+
+	// NodeOS constant represents the operating system of a node
+	NodeOS = NodeLabelPrefix + ".os"
+
+	// NodeKernelVersion constant represents the kernel version of a node
+	NodeKernelVersion = NodeLabelPrefix + ".kernel_version"
 )
 
 var (
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -18,6 +19,7 @@ import (
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
 )
 
 // A Network represents a logical connectivity zone that containers may
@@ -36,9 +38,18 @@ type Network interface {
 	// specified unique name. The options parameter carry driver specific options.
 	CreateEndpoint(name string, options ...EndpointOption) (Endpoint, error)
 
+	// CreateEndpointWithContext is like CreateEndpoint but gives up and rolls
+	// back the endpoint creation once ctx is done.
+	CreateEndpointWithContext(ctx context.Context, name string, options ...EndpointOption) (Endpoint, error)
+
 	// Delete the network.
 	Delete() error
 
+	// Update applies the passed options to the network in place. Labels can
+	// always be changed. Changes to the driver options and additional IPAM
+	// pools are only accepted if the network driver supports them.
+	Update(options ...NetworkOption) error
+
 	// Endpoints returns the list of Endpoint(s) in this network.
 	Endpoints() []Endpoint
 
@@ -65,6 +76,10 @@ type NetworkInfo interface {
 	Internal() bool
 	Labels() map[string]string
 	Dynamic() bool
+	Quota() NetworkQuota
+	QuotaUsage() QuotaUsage
+	DNSForwardRules() map[string][]string
+	DNSDomain() string
 }
 
 // EndpointWalker is a client provided function which will be used to walk the Endpoints.
@@ -76,6 +91,7 @@ type svcInfo struct {
 	svcIPv6Map map[string][]net.IP
 	ipMap      map[string]string
 	service    map[string][]servicePorts
+	txtMap     map[string]map[string][]string // name -> endpoint ID -> TXT records
 }
 
 // backing container or host's info
@@ -189,6 +205,12 @@ type network struct {
 	ingress      bool
 	driverTables []string
 	dynamic      bool
+	quota        NetworkQuota
+	dryRun       bool
+	// dnsForwardRules maps the domains whose names the embedded DNS
+	// server of the attached containers resolves through specific servers
+	dnsForwardRules map[string][]string
+	dnsDomain       string
 	sync.Mutex
 }
 
@@ -287,6 +309,19 @@ func (c *IpamConf) CopyTo(dstC *IpamConf) error {
 	return nil
 }
 
+func (c *IpamConf) equal(o *IpamConf) bool {
+	if c.PreferredPool != o.PreferredPool || c.SubPool != o.SubPool || c.Gateway != o.Gateway ||
+		len(c.AuxAddresses) != len(o.AuxAddresses) {
+		return false
+	}
+	for k, v := range c.AuxAddresses {
+		if ov, ok := o.AuxAddresses[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
 // CopyTo deep copies to the destination IpamInfo
 func (i *IpamInfo) CopyTo(dstI *IpamInfo) error {
 	dstI.PoolID = i.PoolID
@@ -322,6 +357,7 @@ func (n *network) CopyTo(o datastore.KVObject) error {
 	dstN.scope = n.scope
 	dstN.dynamic = n.dynamic
 	dstN.ipamType = n.ipamType
+	dstN.addrSpace = n.addrSpace
 	dstN.enableIPv6 = n.enableIPv6
 	dstN.persist = n.persist
 	dstN.postIPv6 = n.postIPv6
@@ -331,6 +367,9 @@ func (n *network) CopyTo(o datastore.KVObject) error {
 	dstN.internal = n.internal
 	dstN.inDelete = n.inDelete
 	dstN.ingress = n.ingress
+	dstN.quota = n.quota
+	dstN.dnsForwardRules = copyForwardRules(n.dnsForwardRules)
+	dstN.dnsDomain = n.dnsDomain
 
 	// copy labels
 	if dstN.labels == nil {
@@ -438,6 +477,15 @@ func (n *network) MarshalJSON() ([]byte, error) {
 	netMap["internal"] = n.internal
 	netMap["inDelete"] = n.inDelete
 	netMap["ingress"] = n.ingress
+	if n.quota != (NetworkQuota{}) {
+		netMap["quota"] = n.quota
+	}
+	if len(n.dnsForwardRules) != 0 {
+		netMap["dnsForwardRules"] = n.dnsForwardRules
+	}
+	if n.dnsDomain != "" {
+		netMap["dnsDomain"] = n.dnsDomain
+	}
 	return json.Marshal(netMap)
 }
 
@@ -531,6 +579,27 @@ func (n *network) UnmarshalJSON(b []byte) (err error) {
 	if v, ok := netMap["ingress"]; ok {
 		n.ingress = v.(bool)
 	}
+	if v, ok := netMap["quota"]; ok {
+		ba, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(ba, &n.quota); err != nil {
+			return err
+		}
+	}
+	if v, ok := netMap["dnsForwardRules"]; ok {
+		ba, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(ba, &n.dnsForwardRules); err != nil {
+			return err
+		}
+	}
+	if v, ok := netMap["dnsDomain"]; ok {
+		n.dnsDomain = v.(string)
+	}
 	// Reconcile old networks with the recently added `--ipv6` flag
 	if !n.enableIPv6 {
 		n.enableIPv6 = len(n.ipamV6Info) > 0
@@ -783,6 +852,8 @@ func (n *network) delete(force bool) error {
 		return fmt.Errorf("error deleting network from store: %v", err)
 	}
 
+	c.publishEvent(newNetworkEvent(EventNetworkDelete, n))
+
 	return nil
 }
 
@@ -806,13 +877,173 @@ func (n *network) deleteNetwork() error {
 	return nil
 }
 
-func (n *network) addEndpoint(ep *endpoint) error {
+func (n *network) Update(options ...NetworkOption) error {
+	n.Lock()
+	c := n.ctrlr
+	name := n.name
+	id := n.id
+	n.Unlock()
+
+	n, err := c.getNetworkFromStore(id)
+	if err != nil {
+		return &UnknownNetworkError{name: name, id: id}
+	}
+
+	if n.inDelete {
+		return types.ForbiddenErrorf("network %s (%s) is being deleted", n.Name(), n.ID())
+	}
+
+	// Apply the options on a copy, so that the network is left
+	// untouched if the update is refused.
+	un := n.New().(*network)
+	if err = n.CopyTo(un); err != nil {
+		return err
+	}
+	un.epCnt = n.epCnt
+	un.processOptions(options...)
+
+	newV4, newV6, err := n.validateUpdate(un)
+	if err != nil {
+		return err
+	}
+
+	if err = un.validateDNSDomain(); err != nil {
+		return err
+	}
+	if un.quota != n.quota {
+		if err = n.checkQuotaUpdate(un.quota); err != nil {
+			return err
+		}
+	}
+
+	driverUpdate := len(newV4) > 0 || len(newV6) > 0 || !reflect.DeepEqual(n.generic, un.generic)
+
+	var updater driverapi.NetworkUpdater
+	if driverUpdate {
+		d, err := n.driver(true)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if updater, ok = d.(driverapi.NetworkUpdater); !ok {
+			return types.NotImplementedErrorf("network driver %q does not support updating the network options or address pools", n.Type())
+		}
+	}
+
+	var addedV4, addedV6 []*IpamInfo
+	if len(newV4) > 0 || len(newV6) > 0 {
+		var ipam ipamapi.Ipam
+		if ipam, _, err = c.getIPAMDriver(n.ipamType); err != nil {
+			return err
+		}
+		if un.addrSpace == "" {
+			if un.addrSpace, err = un.deriveAddressSpace(); err != nil {
+				return err
+			}
+		}
+		if addedV4, err = un.ipamAllocatePools(context.Background(), 4, ipam, newV4); err != nil {
+			return err
+		}
+		if addedV6, err = un.ipamAllocatePools(context.Background(), 6, ipam, newV6); err != nil {
+			un.ipamReleasePools(ipam, addedV4)
+			return err
+		}
+		defer func() {
+			if err != nil {
+				un.ipamReleasePools(ipam, addedV4)
+				un.ipamReleasePools(ipam, addedV6)
+			}
+		}()
+		un.ipamV4Info = append(un.ipamV4Info, addedV4...)
+		un.ipamV6Info = append(un.ipamV6Info, addedV6...)
+	}
+
+	if updater != nil {
+		if err = updater.UpdateNetwork(un.id, un.generic, un.getIPData(4), un.getIPData(6)); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				if e := updater.UpdateNetwork(n.id, n.generic, n.getIPData(4), n.getIPData(6)); e != nil {
+					log.Warnf("couldn't roll back driver update of network %s (%s) on failure (%v): %v", n.Name(), n.ID(), err, e)
+				}
+			}
+		}()
+	}
+
+	if err = c.updateToStore(un); err != nil {
+		return err
+	}
+
+	c.publishEvent(newNetworkEvent(EventNetworkUpdate, un))
+
+	return nil
+}
+
+// validateUpdate checks that the updated network only differs from the
+// current one in the attributes which can be changed in place, and returns
+// the IPAM configurations added by the update.
+func (n *network) validateUpdate(un *network) ([]*IpamConf, []*IpamConf, error) {
+	if un.name != n.name || un.networkType != n.networkType || un.ipamType != n.ipamType ||
+		un.enableIPv6 != n.enableIPv6 || un.internal != n.internal || un.ingress != n.ingress ||
+		un.dynamic != n.dynamic || un.persist != n.persist || un.postIPv6 != n.postIPv6 {
+		return nil, nil, types.ForbiddenErrorf("only labels, driver options and additional ipam pools can be updated on network %s (%s)", n.name, n.id)
+	}
+
+	// NetworkOptionIpam resets the address space and the ipam options
+	// when they are not specified. Only reject actual changes.
+	if un.addrSpace == "" {
+		un.addrSpace = n.addrSpace
+	}
+	if un.ipamOptions == nil {
+		un.ipamOptions = n.ipamOptions
+	}
+	if un.addrSpace != n.addrSpace || !reflect.DeepEqual(un.ipamOptions, n.ipamOptions) {
+		return nil, nil, types.ForbiddenErrorf("address space and ipam options of network %s (%s) cannot be updated", n.name, n.id)
+	}
+
+	newV4, err := addedIpamConfigs(n.ipamV4Config, un.ipamV4Config)
+	if err != nil {
+		return nil, nil, err
+	}
+	newV6, err := addedIpamConfigs(n.ipamV6Config, un.ipamV6Config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(newV4) > 0 || len(newV6) > 0 {
+		if n.hasSpecialDriver() {
+			return nil, nil, types.ForbiddenErrorf("ipam configuration of network %s (%s) cannot be updated", n.name, n.id)
+		}
+		if len(newV6) > 0 && !n.enableIPv6 {
+			return nil, nil, types.ForbiddenErrorf("cannot add IPv6 pools to network %s (%s) which is not IPv6 enabled", n.name, n.id)
+		}
+	}
+
+	return newV4, newV6, nil
+}
+
+// addedIpamConfigs returns the configurations appended to the current list.
+// Existing configurations can neither be modified nor removed.
+func addedIpamConfigs(current, updated []*IpamConf) ([]*IpamConf, error) {
+	if len(updated) < len(current) {
+		return nil, types.ForbiddenErrorf("existing ipam configurations cannot be removed from a network")
+	}
+	for i, c := range current {
+		if !c.equal(updated[i]) {
+			return nil, types.ForbiddenErrorf("existing ipam configuration %s cannot be modified", c.PreferredPool)
+		}
+	}
+	return updated[len(current):], nil
+}
+
+func (n *network) addEndpoint(ctx context.Context, ep *endpoint) error {
 	d, err := n.driver(true)
 	if err != nil {
 		return fmt.Errorf("failed to add endpoint: %v", err)
 	}
 
-	err = d.CreateEndpoint(n.id, ep.id, ep.Interface(), ep.generic)
+	err = driverWithContext(ctx, d).CreateEndpoint(n.id, ep.id, ep.Interface(), ep.generic)
 	if err != nil {
 		return types.InternalErrorf("failed to create endpoint %s on network %s: %v",
 			ep.Name(), n.Name(), err)
@@ -822,6 +1053,10 @@ func (n *network) addEndpoint(ep *endpoint) error {
 }
 
 func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoint, error) {
+	return n.CreateEndpointWithContext(context.Background(), name, options...)
+}
+
+func (n *network) CreateEndpointWithContext(ctx context.Context, name string, options ...EndpointOption) (Endpoint, error) {
 	var err error
 	if !config.IsValidName(name) {
 		return nil, ErrInvalidName(name)
@@ -852,6 +1087,14 @@ func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoi
 		}
 	}
 
+	if err = ep.validateServiceMode(n); err != nil {
+		return nil, err
+	}
+
+	if err = n.checkQuota(ep); err != nil {
+		return nil, err
+	}
+
 	if opt, ok := ep.generic[netlabel.MacAddress]; ok {
 		if mac, ok := opt.(net.HardwareAddr); ok {
 			ep.iface.mac = mac
@@ -873,27 +1116,33 @@ func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoi
 		ep.ipamOptions[netlabel.MacAddress] = ep.iface.mac.String()
 	}
 
-	if err = ep.assignAddress(ipam, true, n.enableIPv6 && !n.postIPv6); err != nil {
-		return nil, err
-	}
+	// Release whatever got allocated, including when the assignment
+	// itself fails midway through the primary and secondary addresses
 	defer func() {
 		if err != nil {
 			ep.releaseAddress()
 		}
 	}()
+	if err = ep.assignAddress(ipamWithContext(ctx, ipam), true, n.enableIPv6 && !n.postIPv6); err != nil {
+		return nil, err
+	}
 
-	if err = n.addEndpoint(ep); err != nil {
+	if err = n.addEndpoint(ctx, ep); err != nil {
 		return nil, err
 	}
 	defer func() {
 		if err != nil {
-			if e := ep.deleteEndpoint(false); e != nil {
+			if e := ep.deleteEndpoint(context.Background(), false); e != nil {
 				log.Warnf("cleaning up endpoint failed %s : %v", name, e)
 			}
 		}
 	}()
 
-	if err = ep.assignAddress(ipam, false, n.enableIPv6 && n.postIPv6); err != nil {
+	if err = ep.assignAddress(ipamWithContext(ctx, ipam), false, n.enableIPv6 && n.postIPv6); err != nil {
+		return nil, err
+	}
+
+	if err = contextError(ctx); err != nil {
 		return nil, err
 	}
 
@@ -917,10 +1166,12 @@ func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoi
 	}()
 
 	// Increment endpoint count to indicate completion of endpoint addition
-	if err = n.getEpCnt().IncEndpointCnt(); err != nil {
+	if err = n.getEpCnt().reserve(n.Quota(), ep.addressCount()); err != nil {
 		return nil, err
 	}
 
+	n.getController().publishEvent(newEndpointEvent(EventEndpointCreate, ep, nil))
+
 	return ep, nil
 }
 
@@ -992,33 +1243,51 @@ func (n *network) updateSvcRecord(ep *endpoint, localEps []*endpoint, isAdd bool
 			ipv6 = iface.AddressIPv6().IP
 		}
 
-		if isAdd {
-			// If anonymous endpoint has an alias use the first alias
-			// for ip->name mapping. Not having the reverse mapping
-			// breaks some apps
-			if ep.isAnonymous() {
-				if len(myAliases) > 0 {
-					n.addSvcRecords(myAliases[0], iface.Address().IP, ipv6, true)
+		// The secondary addresses are registered under the same names
+		// as the primary ones
+		addrs := [][2]net.IP{{iface.Address().IP, ipv6}}
+		for _, addr := range iface.SecondaryAddresses() {
+			addrs = append(addrs, [2]net.IP{addr.IP, nil})
+		}
+		for _, addr := range iface.SecondaryAddressesIPv6() {
+			addrs = append(addrs, [2]net.IP{nil, addr.IP})
+		}
+
+		for _, a := range addrs {
+			if isAdd {
+				// If anonymous endpoint has an alias use the first alias
+				// for ip->name mapping. Not having the reverse mapping
+				// breaks some apps
+				if ep.isAnonymous() {
+					if len(myAliases) > 0 {
+						n.addSvcRecords(myAliases[0], a[0], a[1], true)
+					}
+				} else {
+					n.addSvcRecords(epName, a[0], a[1], true)
 				}
-			} else {
-				n.addSvcRecords(epName, iface.Address().IP, ipv6, true)
-			}
-			for _, alias := range myAliases {
-				n.addSvcRecords(alias, iface.Address().IP, ipv6, false)
-			}
-		} else {
-			if ep.isAnonymous() {
-				if len(myAliases) > 0 {
-					n.deleteSvcRecords(myAliases[0], iface.Address().IP, ipv6, true)
+				for _, alias := range myAliases {
+					n.addSvcRecords(alias, a[0], a[1], false)
 				}
 			} else {
-				n.deleteSvcRecords(epName, iface.Address().IP, ipv6, true)
-			}
-			for _, alias := range myAliases {
-				n.deleteSvcRecords(alias, iface.Address().IP, ipv6, false)
+				if ep.isAnonymous() {
+					if len(myAliases) > 0 {
+						n.deleteSvcRecords(myAliases[0], a[0], a[1], true)
+					}
+				} else {
+					n.deleteSvcRecords(epName, a[0], a[1], true)
+				}
+				for _, alias := range myAliases {
+					n.deleteSvcRecords(alias, a[0], a[1], false)
+				}
 			}
 		}
 	}
+
+	if isAdd {
+		n.addTXTRecords(ep)
+	} else {
+		n.deleteTXTRecords(ep)
+	}
 }
 
 func addIPToName(ipMap map[string]string, name string, ip net.IP) {
@@ -1068,13 +1337,17 @@ func (n *network) addSvcRecords(name string, epIP net.IP, epIPv6 net.IP, ipMapUp
 	}
 
 	if ipMapUpdate {
-		addIPToName(sr.ipMap, name, epIP)
+		if epIP != nil {
+			addIPToName(sr.ipMap, name, epIP)
+		}
 		if epIPv6 != nil {
 			addIPToName(sr.ipMap, name, epIPv6)
 		}
 	}
 
-	addNameToIP(sr.svcMap, name, epIP)
+	if epIP != nil {
+		addNameToIP(sr.svcMap, name, epIP)
+	}
 	if epIPv6 != nil {
 		addNameToIP(sr.svcIPv6Map, name, epIPv6)
 	}
@@ -1090,14 +1363,18 @@ func (n *network) deleteSvcRecords(name string, epIP net.IP, epIPv6 net.IP, ipMa
 	}
 
 	if ipMapUpdate {
-		delete(sr.ipMap, netutils.ReverseIP(epIP.String()))
+		if epIP != nil {
+			delete(sr.ipMap, netutils.ReverseIP(epIP.String()))
+		}
 
 		if epIPv6 != nil {
 			delete(sr.ipMap, netutils.ReverseIP(epIPv6.String()))
 		}
 	}
 
-	delNameToIP(sr.svcMap, name, epIP)
+	if epIP != nil {
+		delNameToIP(sr.svcMap, name, epIP)
+	}
 
 	if epIPv6 != nil {
 		delNameToIP(sr.svcIPv6Map, name, epIPv6)
@@ -1143,7 +1420,7 @@ func (n *network) getController() *controller {
 	return n.ctrlr
 }
 
-func (n *network) ipamAllocate() error {
+func (n *network) ipamAllocate(ctx context.Context) error {
 	if n.hasSpecialDriver() {
 		return nil
 	}
@@ -1159,7 +1436,7 @@ func (n *network) ipamAllocate() error {
 		}
 	}
 
-	err = n.ipamAllocateVersion(4, ipam)
+	err = n.ipamAllocateVersion(ctx, 4, ipam)
 	if err != nil {
 		return err
 	}
@@ -1174,7 +1451,7 @@ func (n *network) ipamAllocate() error {
 		return nil
 	}
 
-	err = n.ipamAllocateVersion(6, ipam)
+	err = n.ipamAllocateVersion(ctx, 6, ipam)
 	if err != nil {
 		return err
 	}
@@ -1182,9 +1459,12 @@ func (n *network) ipamAllocate() error {
 	return nil
 }
 
-func (n *network) requestPoolHelper(ipam ipamapi.Ipam, addressSpace, preferredPool, subPool string, options map[string]string, v6 bool) (string, *net.IPNet, map[string]string, error) {
+// requestPoolHelper requests the pools bound to ctx. The overlapping pools
+// are released through the unbound driver, so that they are not leaked
+// in the ipam driver once ctx is done.
+func (n *network) requestPoolHelper(ctx context.Context, ipam ipamapi.Ipam, addressSpace, preferredPool, subPool string, options map[string]string, v6 bool) (string, *net.IPNet, map[string]string, error) {
 	for {
-		poolID, pool, meta, err := ipam.RequestPool(addressSpace, preferredPool, subPool, options, v6)
+		poolID, pool, meta, err := ipamWithContext(ctx, ipam).RequestPool(addressSpace, preferredPool, subPool, options, v6)
 		if err != nil {
 			return "", nil, nil, err
 		}
@@ -1223,7 +1503,7 @@ func (n *network) requestPoolHelper(ipam ipamapi.Ipam, addressSpace, preferredPo
 	}
 }
 
-func (n *network) ipamAllocateVersion(ipVer int, ipam ipamapi.Ipam) error {
+func (n *network) ipamAllocateVersion(ctx context.Context, ipVer int, ipam ipamapi.Ipam) error {
 	var (
 		cfgList  *[]*IpamConf
 		infoList *[]*IpamInfo
@@ -1248,21 +1528,33 @@ func (n *network) ipamAllocateVersion(ipVer int, ipam ipamapi.Ipam) error {
 		*cfgList = []*IpamConf{{}}
 	}
 
-	*infoList = make([]*IpamInfo, len(*cfgList))
-
 	log.Debugf("Allocating IPv%d pools for network %s (%s)", ipVer, n.Name(), n.ID())
 
-	for i, cfg := range *cfgList {
+	*infoList, err = n.ipamAllocatePools(ctx, ipVer, ipam, *cfgList)
+	return err
+}
+
+// ipamAllocatePools requests a pool, a gateway and the auxiliary addresses
+// for each of the passed configurations. The requests are bound to ctx, while
+// on failure the pools allocated so far are released without it.
+func (n *network) ipamAllocatePools(ctx context.Context, ipVer int, ipam ipamapi.Ipam, cfgList []*IpamConf) ([]*IpamInfo, error) {
+	var err error
+
+	req := ipamWithContext(ctx, ipam)
+
+	infoList := make([]*IpamInfo, len(cfgList))
+
+	for i, cfg := range cfgList {
 		if err = cfg.Validate(); err != nil {
-			return err
+			return infoList, err
 		}
 		d := &IpamInfo{}
-		(*infoList)[i] = d
+		infoList[i] = d
 
 		d.AddressSpace = n.addrSpace
-		d.PoolID, d.Pool, d.Meta, err = n.requestPoolHelper(ipam, n.addrSpace, cfg.PreferredPool, cfg.SubPool, n.ipamOptions, ipVer == 6)
+		d.PoolID, d.Pool, d.Meta, err = n.requestPoolHelper(ctx, ipam, n.addrSpace, cfg.PreferredPool, cfg.SubPool, n.ipamOptions, ipVer == 6)
 		if err != nil {
-			return err
+			return infoList, err
 		}
 
 		defer func() {
@@ -1275,7 +1567,7 @@ func (n *network) ipamAllocateVersion(ipVer int, ipam ipamapi.Ipam) error {
 
 		if gws, ok := d.Meta[netlabel.Gateway]; ok {
 			if d.Gateway, err = types.ParseCIDR(gws); err != nil {
-				return types.BadRequestErrorf("failed to parse gateway address (%v) returned by ipam driver: %v", gws, err)
+				return infoList, types.BadRequestErrorf("failed to parse gateway address (%v) returned by ipam driver: %v", gws, err)
 			}
 		}
 
@@ -1286,8 +1578,8 @@ func (n *network) ipamAllocateVersion(ipVer int, ipam ipamapi.Ipam) error {
 			var gatewayOpts = map[string]string{
 				ipamapi.RequestAddressType: netlabel.Gateway,
 			}
-			if d.Gateway, _, err = ipam.RequestAddress(d.PoolID, net.ParseIP(cfg.Gateway), gatewayOpts); err != nil {
-				return types.InternalErrorf("failed to allocate gateway (%v): %v", cfg.Gateway, err)
+			if d.Gateway, _, err = req.RequestAddress(d.PoolID, net.ParseIP(cfg.Gateway), gatewayOpts); err != nil {
+				return infoList, types.InternalErrorf("failed to allocate gateway (%v): %v", cfg.Gateway, err)
 			}
 		}
 
@@ -1298,20 +1590,20 @@ func (n *network) ipamAllocateVersion(ipVer int, ipam ipamapi.Ipam) error {
 			d.IPAMData.AuxAddresses = make(map[string]*net.IPNet, len(cfg.AuxAddresses))
 			for k, v := range cfg.AuxAddresses {
 				if ip = net.ParseIP(v); ip == nil {
-					return types.BadRequestErrorf("non parsable secondary ip address (%s:%s) passed for network %s", k, v, n.Name())
+					return infoList, types.BadRequestErrorf("non parsable secondary ip address (%s:%s) passed for network %s", k, v, n.Name())
 				}
 				if !d.Pool.Contains(ip) {
-					return types.ForbiddenErrorf("auxilairy address: (%s:%s) must belong to the master pool: %s", k, v, d.Pool)
+					return infoList, types.ForbiddenErrorf("auxilairy address: (%s:%s) must belong to the master pool: %s", k, v, d.Pool)
 				}
 				// Attempt reservation in the container addressable pool, silent the error if address does not belong to that pool
-				if d.IPAMData.AuxAddresses[k], _, err = ipam.RequestAddress(d.PoolID, ip, nil); err != nil && err != ipamapi.ErrIPOutOfRange {
-					return types.InternalErrorf("failed to allocate secondary ip address (%s:%s): %v", k, v, err)
+				if d.IPAMData.AuxAddresses[k], _, err = req.RequestAddress(d.PoolID, ip, nil); err != nil && err != ipamapi.ErrIPOutOfRange {
+					return infoList, types.InternalErrorf("failed to allocate secondary ip address (%s:%s): %v", k, v, err)
 				}
 			}
 		}
 	}
 
-	return nil
+	return infoList, nil
 }
 
 func (n *network) ipamRelease() {
@@ -1346,7 +1638,13 @@ func (n *network) ipamReleaseVersion(ipVer int, ipam ipamapi.Ipam) {
 
 	log.Debugf("releasing IPv%d pools from network %s (%s)", ipVer, n.Name(), n.ID())
 
-	for _, d := range *infoList {
+	n.ipamReleasePools(ipam, *infoList)
+
+	*infoList = nil
+}
+
+func (n *network) ipamReleasePools(ipam ipamapi.Ipam, infoList []*IpamInfo) {
+	for _, d := range infoList {
 		if d.Gateway != nil {
 			if err := ipam.ReleaseAddress(d.PoolID, d.Gateway.IP); err != nil {
 				log.Warnf("Failed to release gateway ip address %s on delete of network %s (%s): %v", d.Gateway.IP, n.Name(), n.ID(), err)
@@ -1365,8 +1663,6 @@ func (n *network) ipamReleaseVersion(ipVer int, ipam ipamapi.Ipam) {
 			log.Warnf("Failed to release address pool %s on delete of network %s (%s): %v", d.PoolID, n.Name(), n.ID(), err)
 		}
 	}
-
-	*infoList = nil
 }
 
 func (n *network) getIPInfo(ipVer int) []*IpamInfo {
@@ -79,7 +79,22 @@ func (nDB *NetworkDB) RemoveKey(key []byte) {
 }
 
 func (nDB *NetworkDB) clusterInit() error {
-	config := memberlist.DefaultLANConfig()
+	var config *memberlist.Config
+	switch nDB.config.Profile {
+	case ProfileWAN:
+		config = memberlist.DefaultWANConfig()
+	default:
+		config = memberlist.DefaultLANConfig()
+	}
+	if nDB.config.GossipInterval != 0 {
+		config.GossipInterval = nDB.config.GossipInterval
+	}
+	if nDB.config.PushPullInterval != 0 {
+		config.PushPullInterval = nDB.config.PushPullInterval
+	}
+	if nDB.config.RetransmitMult != 0 {
+		config.RetransmitMult = nDB.config.RetransmitMult
+	}
 	config.Name = nDB.config.NodeName
 	config.AdvertiseAddr = nDB.config.AdvertiseAddr
 
@@ -111,7 +126,12 @@ func (nDB *NetworkDB) clusterInit() error {
 		RetransmitMult: config.RetransmitMult,
 	}
 
-	mlist, err := memberlist.Create(config)
+	transport := nDB.config.Transport
+	if transport == nil {
+		transport = memberlistTransport{}
+	}
+
+	mlist, err := transport.Create(config)
 	if err != nil {
 		return fmt.Errorf("failed to create memberlist: %v", err)
 	}
@@ -120,22 +140,41 @@ func (nDB *NetworkDB) clusterInit() error {
 	nDB.memberlist = mlist
 	nDB.mConfig = config
 
+	clock, _ := transport.(Clock)
 	for _, trigger := range []struct {
 		interval time.Duration
 		fn       func()
 	}{
-		{reapInterval, nDB.reapState},
+		{nDB.config.ReapInterval, nDB.reapState},
 		{config.GossipInterval, nDB.gossip},
 		{config.PushPullInterval, nDB.bulkSyncTables},
 	} {
-		t := time.NewTicker(trigger.interval)
-		go nDB.triggerFunc(trigger.interval, t.C, nDB.stopCh, trigger.fn)
-		nDB.tickers = append(nDB.tickers, t)
+		nDB.every(clock, trigger.interval, trigger.fn)
+	}
+
+	if nDB.config.SnapshotPath != "" {
+		interval := nDB.config.SnapshotInterval
+		if interval <= 0 {
+			interval = defaultSnapshotInterval
+		}
+		nDB.every(clock, interval, nDB.snapshotState)
 	}
 
 	return nil
 }
 
+// every runs f every interval until the NetworkDB leaves the cluster, on the
+// clock of the transport if it has one
+func (nDB *NetworkDB) every(clock Clock, interval time.Duration, f func()) {
+	if clock != nil {
+		clock.Every(interval, nDB.stopCh, f)
+		return
+	}
+	t := time.NewTicker(interval)
+	go nDB.triggerFunc(interval, t.C, nDB.stopCh, f)
+	nDB.tickers = append(nDB.tickers, t)
+}
+
 func (nDB *NetworkDB) clusterJoin(members []string) error {
 	mlist := nDB.memberlist
 
@@ -181,6 +220,7 @@ func (nDB *NetworkDB) triggerFunc(stagger time.Duration, C <-chan time.Time, sto
 }
 
 func (nDB *NetworkDB) reapState() {
+	nDB.expireProvisional()
 	nDB.reapNetworks()
 	nDB.reapTableEntries()
 }
@@ -190,7 +230,7 @@ func (nDB *NetworkDB) reapNetworks() {
 	nDB.Lock()
 	for name, nn := range nDB.networks {
 		for id, n := range nn {
-			if n.leaving && now.Sub(n.leaveTime) > reapInterval {
+			if n.leaving && now.Sub(n.leaveTime) > nDB.config.TombstoneLifetime {
 				delete(nn, id)
 				nDB.deleteNetworkNode(id, name)
 			}
@@ -211,7 +251,7 @@ func (nDB *NetworkDB) reapTableEntries() {
 			return false
 		}
 
-		if !entry.deleting || now.Sub(entry.deleteTime) <= reapInterval {
+		if !entry.deleting || now.Sub(entry.deleteTime) <= nDB.config.TombstoneLifetime {
 			return false
 		}
 
@@ -248,9 +288,14 @@ func (nDB *NetworkDB) gossip() {
 	}
 	nDB.RUnlock()
 
+	var queued int
+	defer func() {
+		broadcastQueueLength.With("table").Set(float64(queued))
+	}()
+
 	for nid, nodes := range networkNodes {
 		mNodes := nDB.mRandomNodes(3, nodes)
-		bytesAvail := udpSendBuf - compoundHeaderOverhead
+		bytesAvail := nDB.config.PacketBufferSize - compoundHeaderOverhead
 
 		nDB.RLock()
 		network, ok := thisNodeNetworks[nid]
@@ -270,6 +315,7 @@ func (nDB *NetworkDB) gossip() {
 			continue
 		}
 
+		queued += broadcastQ.NumQueued()
 		msgs := broadcastQ.GetBroadcasts(compoundOverhead, bytesAvail)
 		if len(msgs) == 0 {
 			continue
@@ -289,6 +335,7 @@ func (nDB *NetworkDB) gossip() {
 
 			// Send the compound message
 			if err := nDB.memberlist.SendToUDP(mnode, compound); err != nil {
+				gossipSendErrors.Inc()
 				logrus.Errorf("Failed to send gossip to %s: %s", mnode.Addr, err)
 			}
 		}
@@ -388,6 +435,8 @@ func (nDB *NetworkDB) bulkSync(nid string, nodes []string, all bool) ([]string,
 func (nDB *NetworkDB) bulkSyncNode(networks []string, node string, unsolicited bool) error {
 	var msgs [][]byte
 
+	defer bulkSyncDuration.Since(time.Now())
+
 	logrus.Debugf("%s: Initiating bulk sync for networks %v with node %s", nDB.config.NodeName, networks, node)
 
 	nDB.RLock()
@@ -405,8 +454,10 @@ func (nDB *NetworkDB) bulkSyncNode(networks []string, node string, unsolicited b
 			}
 
 			// Do not bulk sync state which is in the
-			// process of getting deleted.
-			if entry.deleting {
+			// process of getting deleted, nor the state
+			// restored from the snapshot which the peers
+			// did not confirm and may have reaped.
+			if entry.deleting || entry.provisional {
 				return false
 			}
 
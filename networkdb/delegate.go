@@ -14,7 +14,11 @@ type delegate struct {
 }
 
 func (d *delegate) NodeMeta(limit int) []byte {
-	return []byte{}
+	if len(d.nDB.meta) > limit {
+		logrus.Errorf("Node metadata of %d bytes exceeds the limit of %d", len(d.nDB.meta), limit)
+		return []byte{}
+	}
+	return d.nDB.meta
 }
 
 func (nDB *NetworkDB) handleNetworkEvent(nEvent *NetworkEvent) bool {
@@ -44,10 +48,14 @@ func (nDB *NetworkDB) handleNetworkEvent(nEvent *NetworkEvent) bool {
 		// We have the latest state. Ignore the event
 		// since it is stale.
 		if n.ltime >= nEvent.LTime {
+			if n.ltime == nEvent.LTime {
+				n.provisional = false
+			}
 			return false
 		}
 
 		n.ltime = nEvent.LTime
+		n.provisional = false
 		n.leaving = nEvent.Type == NetworkEventTypeLeave
 		if n.leaving {
 			n.leaveTime = time.Now()
@@ -95,6 +103,11 @@ func (nDB *NetworkDB) handleTableEvent(tEvent *TableEvent) bool {
 		// We have the latest state. Ignore the event
 		// since it is stale.
 		if e.ltime >= tEvent.LTime {
+			if e.ltime == tEvent.LTime && e.provisional {
+				nDB.Lock()
+				e.provisional = false
+				nDB.Unlock()
+			}
 			return false
 		}
 	}
@@ -255,6 +268,8 @@ func (nDB *NetworkDB) handleMessage(buf []byte, isBulkSync bool) {
 		return
 	}
 
+	messagesReceived.With(mType.String()).Inc()
+
 	switch mType {
 	case MessageTypeNetworkEvent:
 		nDB.handleNetworkMessage(data)
@@ -278,6 +293,7 @@ func (d *delegate) NotifyMsg(buf []byte) {
 }
 
 func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte {
+	broadcastQueueLength.With("network").Set(float64(d.nDB.networkBroadcasts.NumQueued()))
 	return d.nDB.networkBroadcasts.GetBroadcasts(overhead, limit)
 }
 
@@ -21,4 +21,9 @@ func (e *eventDelegate) NotifyLeave(n *memberlist.Node) {
 }
 
 func (e *eventDelegate) NotifyUpdate(n *memberlist.Node) {
+	e.nDB.Lock()
+	if _, ok := e.nDB.nodes[n.Name]; ok {
+		e.nDB.nodes[n.Name] = n
+	}
+	e.nDB.Unlock()
 }
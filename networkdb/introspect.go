@@ -0,0 +1,226 @@
+package networkdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+)
+
+// Introspector reads the state of a NetworkDB instance for debugging. It
+// cannot modify the tables nor the cluster membership.
+type Introspector interface {
+	// Nodes returns the nodes of the cluster, sorted by name
+	Nodes() []NodeInfo
+	// Networks returns the network attachments of the nodes
+	Networks() []NetworkInfo
+	// NetworkNodes returns the nodes attached to each network
+	NetworkNodes() map[string][]string
+	// Tables returns the tables with their entry counts
+	Tables() []TableInfo
+	// Entries returns the entries of a table on a network
+	Entries(tname, nid string) []EntryInfo
+}
+
+// States of the nodes returned by Nodes
+const (
+	// NodeStateActive is the state of the nodes in the cluster
+	NodeStateActive = "active"
+	// NodeStateLeft is the state of the nodes which left the cluster
+	// and whose network attachments are still known
+	NodeStateLeft = "left"
+)
+
+// NodeInfo describes a node of the cluster
+type NodeInfo struct {
+	Name  string `json:"name"`
+	Addr  string `json:"addr,omitempty"`
+	Port  uint16 `json:"port,omitempty"`
+	State string `json:"state"`
+	// Labels are the labels the node advertises
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// NetworkInfo describes the attachment of a node to a network
+type NetworkInfo struct {
+	NodeName    string           `json:"node_name"`
+	NetworkID   string           `json:"network_id"`
+	LTime       serf.LamportTime `json:"ltime"`
+	Leaving     bool             `json:"leaving"`
+	LeaveTime   time.Time        `json:"leave_time,omitempty"`
+	Provisional bool             `json:"provisional"`
+}
+
+// TableInfo describes the entries of a table
+type TableInfo struct {
+	Name     string `json:"name"`
+	Entries  int    `json:"entries"`
+	Deleting int    `json:"deleting"`
+	// Owners is the number of entries of the table per owner node
+	Owners map[string]int `json:"owners"`
+}
+
+// EntryInfo describes a table entry
+type EntryInfo struct {
+	Key         string           `json:"key"`
+	NodeName    string           `json:"node_name"`
+	LTime       serf.LamportTime `json:"ltime"`
+	Value       []byte           `json:"value"`
+	Deleting    bool             `json:"deleting"`
+	DeleteTime  time.Time        `json:"delete_time,omitempty"`
+	Provisional bool             `json:"provisional"`
+}
+
+// Nodes returns the nodes of the cluster and the nodes which left it but
+// are still attached to networks, sorted by name
+func (nDB *NetworkDB) Nodes() []NodeInfo {
+	nDB.RLock()
+	defer nDB.RUnlock()
+
+	var nodes []NodeInfo
+	for name, n := range nDB.nodes {
+		nodes = append(nodes, NodeInfo{
+			Name:   name,
+			Addr:   n.Addr.String(),
+			Port:   n.Port,
+			State:  NodeStateActive,
+			Labels: decodeNodeMeta(n),
+		})
+	}
+	for name := range nDB.networks {
+		if _, ok := nDB.nodes[name]; ok || name == nDB.config.NodeName {
+			continue
+		}
+		nodes = append(nodes, NodeInfo{
+			Name:  name,
+			State: NodeStateLeft,
+		})
+	}
+
+	sort.Sort(byNodeName(nodes))
+	return nodes
+}
+
+// Networks returns the network attachments of all the nodes, sorted by
+// node and network
+func (nDB *NetworkDB) Networks() []NetworkInfo {
+	nDB.RLock()
+	defer nDB.RUnlock()
+
+	var networks []NetworkInfo
+	for name, nn := range nDB.networks {
+		for nid, n := range nn {
+			networks = append(networks, NetworkInfo{
+				NodeName:    name,
+				NetworkID:   nid,
+				LTime:       n.ltime,
+				Leaving:     n.leaving,
+				LeaveTime:   n.leaveTime,
+				Provisional: n.provisional,
+			})
+		}
+	}
+
+	sort.Sort(byNodeNetwork(networks))
+	return networks
+}
+
+// NetworkNodes returns the nodes participating in each network
+func (nDB *NetworkDB) NetworkNodes() map[string][]string {
+	nDB.RLock()
+	defer nDB.RUnlock()
+
+	networkNodes := make(map[string][]string, len(nDB.networkNodes))
+	for nid, nodes := range nDB.networkNodes {
+		networkNodes[nid] = append([]string(nil), nodes...)
+		sort.Strings(networkNodes[nid])
+	}
+	return networkNodes
+}
+
+// Tables returns the entry counts of the tables, sorted by name
+func (nDB *NetworkDB) Tables() []TableInfo {
+	tables := make(map[string]*TableInfo)
+
+	nDB.RLock()
+	nDB.indexes[byTable].Walk(func(path string, v interface{}) bool {
+		entry, ok := v.(*entry)
+		if !ok {
+			return false
+		}
+
+		tname := strings.Split(path[1:], "/")[0]
+		t, ok := tables[tname]
+		if !ok {
+			t = &TableInfo{Name: tname, Owners: make(map[string]int)}
+			tables[tname] = t
+		}
+		t.Entries++
+		if entry.deleting {
+			t.Deleting++
+		}
+		t.Owners[entry.node]++
+		return false
+	})
+	nDB.RUnlock()
+
+	list := make([]TableInfo, 0, len(tables))
+	for _, t := range tables {
+		list = append(list, *t)
+	}
+	sort.Sort(byTableName(list))
+	return list
+}
+
+// Entries returns the entries of a table in a network, including the ones
+// being deleted, sorted by key
+func (nDB *NetworkDB) Entries(tname, nid string) []EntryInfo {
+	var entries []EntryInfo
+
+	nDB.RLock()
+	nDB.indexes[byTable].WalkPrefix(fmt.Sprintf("/%s/%s/", tname, nid), func(path string, v interface{}) bool {
+		entry, ok := v.(*entry)
+		if !ok {
+			return false
+		}
+
+		entries = append(entries, EntryInfo{
+			Key:         strings.Split(path[1:], "/")[2],
+			NodeName:    entry.node,
+			LTime:       entry.ltime,
+			Value:       entry.value,
+			Deleting:    entry.deleting,
+			DeleteTime:  entry.deleteTime,
+			Provisional: entry.provisional,
+		})
+		return false
+	})
+	nDB.RUnlock()
+
+	return entries
+}
+
+type byNodeName []NodeInfo
+
+func (n byNodeName) Len() int           { return len(n) }
+func (n byNodeName) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n byNodeName) Less(i, j int) bool { return n[i].Name < n[j].Name }
+
+type byNodeNetwork []NetworkInfo
+
+func (n byNodeNetwork) Len() int      { return len(n) }
+func (n byNodeNetwork) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n byNodeNetwork) Less(i, j int) bool {
+	if n[i].NodeName != n[j].NodeName {
+		return n[i].NodeName < n[j].NodeName
+	}
+	return n[i].NetworkID < n[j].NetworkID
+}
+
+type byTableName []TableInfo
+
+func (t byTableName) Len() int           { return len(t) }
+func (t byTableName) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byTableName) Less(i, j int) bool { return t[i].Name < t[j].Name }
@@ -0,0 +1,16 @@
+package networkdb
+
+import (
+	"github.com/docker/libnetwork/metrics"
+)
+
+var (
+	broadcastQueueLength = metrics.NewGaugeVec("libnetwork_networkdb_broadcast_queue_length",
+		"Number of messages queued for broadcast, by queue.", "queue")
+	messagesReceived = metrics.NewCounterVec("libnetwork_networkdb_messages_received_total",
+		"Number of gossip messages received, by message type.", "type")
+	gossipSendErrors = metrics.NewCounter("libnetwork_networkdb_gossip_send_errors_total",
+		"Number of gossip messages which could not be sent.")
+	bulkSyncDuration = metrics.NewHistogram("libnetwork_networkdb_bulk_sync_duration_seconds",
+		"Time taken to bulk sync the tables with a peer node.", nil)
+)
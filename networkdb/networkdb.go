@@ -37,7 +37,10 @@ type NetworkDB struct {
 	indexes map[int]*radix.Tree
 
 	// Memberlist we use to drive the cluster.
-	memberlist *memberlist.Memberlist
+	memberlist Cluster
+
+	// Encoded metadata this node advertises to the cluster.
+	meta []byte
 
 	// List of all peer nodes in the cluster not-limited to any
 	// network.
@@ -80,6 +83,13 @@ type NetworkDB struct {
 
 	// Reference to the memberlist's keyring to add & remove keys
 	keyring *memberlist.Keyring
+
+	// The time the state restored from the snapshot expires if
+	// not confirmed by the peers.
+	reconcileDeadline time.Time
+
+	// Serializes the writes of the snapshot.
+	snapshotLock sync.Mutex
 }
 
 // network describes the node/network attachment.
@@ -99,6 +109,10 @@ type network struct {
 	// The broadcast queue for table event gossip. This is only
 	// initialized for this node's network attachment entries.
 	tableBroadcasts *memberlist.TransmitLimitedQueue
+
+	// The attachment was restored from the snapshot and is not
+	// confirmed by the peers yet.
+	provisional bool
 }
 
 // Config represents the configuration of the networdb instance and
@@ -118,6 +132,102 @@ type Config struct {
 	// Keys to be added to the Keyring of the memberlist. Key at index
 	// 0 is the primary key
 	Keys [][]byte
+
+	// SnapshotPath is the file the state learned from the peers is
+	// periodically saved to and restored from on start. No snapshot
+	// is taken when it is empty.
+	SnapshotPath string
+
+	// SnapshotInterval is the interval the snapshot is taken at. Zero
+	// selects the default interval.
+	SnapshotInterval time.Duration
+
+	// Transport creates the cluster membership and messaging layer.
+	// Memberlist over the network is used when it is not set.
+	Transport Transport
+
+	// Profile selects the memberlist defaults, ProfileLAN or
+	// ProfileWAN. Empty selects ProfileLAN.
+	Profile string
+
+	// GossipInterval is the interval the queued events are gossiped
+	// at. Zero selects the default of the profile.
+	GossipInterval time.Duration
+
+	// PushPullInterval is the interval of the full state exchange with
+	// a random node and of the periodic bulk sync of the tables. Zero
+	// selects the default of the profile.
+	PushPullInterval time.Duration
+
+	// RetransmitMult is the multiplier of the number of times an event
+	// is gossiped. Zero selects the default of the profile.
+	RetransmitMult int
+
+	// ReapInterval is the interval the deleted state is reaped at. Zero
+	// selects the default interval.
+	ReapInterval time.Duration
+
+	// TombstoneLifetime is the time the deleted entries and the left
+	// networks are retained for before being reaped, so that the
+	// deletion reaches all the nodes. Zero selects the default lifetime.
+	TombstoneLifetime time.Duration
+
+	// PacketBufferSize is the maximum size of the table event gossip
+	// packets NetworkDB sends. It does not apply to the packets of
+	// memberlist itself, as the membership and network event gossip,
+	// which memberlist sizes on its own. Zero selects the default size.
+	PacketBufferSize int
+
+	// Labels are advertised to the other nodes in the memberlist node
+	// metadata. Encoded, they must fit in memberlist.MetaMaxSize bytes.
+	// They are set at New and cannot be updated afterwards.
+	Labels map[string]string
+}
+
+// Memberlist configuration profiles
+const (
+	// ProfileLAN is tuned for the nodes of a local network
+	ProfileLAN = "lan"
+	// ProfileWAN is tuned for the nodes spread over a wide area network
+	ProfileWAN = "wan"
+)
+
+// maxPacketBufferSize is the size of the buffer memberlist receives the
+// packets into, larger packets would be truncated.
+const maxPacketBufferSize = 65536
+
+// setDefaults validates the configuration and fills in the defaults of
+// the NetworkDB tunables not set
+func (c *Config) setDefaults() error {
+	switch c.Profile {
+	case "":
+		c.Profile = ProfileLAN
+	case ProfileLAN, ProfileWAN:
+	default:
+		return fmt.Errorf("invalid networkdb profile %q", c.Profile)
+	}
+
+	if c.GossipInterval < 0 || c.PushPullInterval < 0 || c.ReapInterval < 0 || c.TombstoneLifetime < 0 {
+		return fmt.Errorf("networkdb intervals can not be negative")
+	}
+	if c.RetransmitMult < 0 {
+		return fmt.Errorf("invalid networkdb retransmit multiplier %d", c.RetransmitMult)
+	}
+	if c.PacketBufferSize < 0 || c.PacketBufferSize > maxPacketBufferSize ||
+		(c.PacketBufferSize != 0 && c.PacketBufferSize <= compoundHeaderOverhead+compoundOverhead) {
+		return fmt.Errorf("invalid networkdb packet buffer size %d", c.PacketBufferSize)
+	}
+
+	if c.ReapInterval == 0 {
+		c.ReapInterval = reapInterval
+	}
+	if c.TombstoneLifetime == 0 {
+		c.TombstoneLifetime = reapInterval
+	}
+	if c.PacketBufferSize == 0 {
+		c.PacketBufferSize = udpSendBuf
+	}
+	return nil
 }
 
 // entry defines a table entry
@@ -137,11 +247,19 @@ type entry struct {
 
 	// The wall clock time when this node learned about this deletion.
 	deleteTime time.Time
+
+	// The entry was restored from the snapshot and is not confirmed
+	// by the peers yet.
+	provisional bool
 }
 
 // New creates a new instance of NetworkDB using the Config passed by
-// the caller.
+// the caller. The Config is copied, it is not modified.
 func New(c *Config) (*NetworkDB, error) {
+	config := *c
+	config.Keys = append([][]byte(nil), c.Keys...)
+	c = &config
+
 	nDB := &NetworkDB{
 		config:         c,
 		indexes:        make(map[int]*radix.Tree),
@@ -152,9 +270,23 @@ func New(c *Config) (*NetworkDB, error) {
 		broadcaster:    events.NewBroadcaster(),
 	}
 
+	if err := c.setDefaults(); err != nil {
+		return nil, err
+	}
+
+	meta, err := encodeNodeMeta(c.Labels)
+	if err != nil {
+		return nil, err
+	}
+	nDB.meta = meta
+
 	nDB.indexes[byTable] = radix.New()
 	nDB.indexes[byNetwork] = radix.New()
 
+	if err := nDB.loadSnapshot(); err != nil {
+		logrus.Errorf("Could not restore networkdb snapshot: %v", err)
+	}
+
 	if err := nDB.clusterInit(); err != nil {
 		return nil, err
 	}
@@ -171,6 +303,8 @@ func (nDB *NetworkDB) Join(members []string) error {
 // Close destroys this NetworkDB instance by leave the cluster,
 // stopping timers, canceling goroutines etc.
 func (nDB *NetworkDB) Close() {
+	nDB.snapshotState()
+
 	if err := nDB.clusterLeave(); err != nil {
 		logrus.Errorf("Could not close DB %s: %v", nDB.config.NodeName, err)
 	}
@@ -378,7 +512,7 @@ func (nDB *NetworkDB) JoinNetwork(nid string) error {
 			nDB.RUnlock()
 			return num
 		},
-		RetransmitMult: 4,
+		RetransmitMult: nDB.mConfig.RetransmitMult,
 	}
 	nDB.networkNodes[nid] = append(nDB.networkNodes[nid], nDB.config.NodeName)
 	networkNodes := nDB.networkNodes[nid]
@@ -1,16 +1,22 @@
 package networkdb
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/go-events"
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/serf/serf"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -379,6 +385,340 @@ func TestNetworkDBBulkSync(t *testing.T) {
 	closeNetworkDBInstances(dbs)
 }
 
+func (db *NetworkDB) isProvisional(tname, nid, key string) bool {
+	db.RLock()
+	defer db.RUnlock()
+
+	e, ok := db.indexes[byTable].Get(fmt.Sprintf("/%s/%s/%s", tname, nid, key))
+	return ok && e.(*entry).provisional
+}
+
+func TestNetworkDBSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "networkdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dbs := createNetworkDBInstances(t, 2, "node")
+	dbs[1].config.SnapshotPath = filepath.Join(dir, "snapshot.json")
+
+	for _, nid := range []string{"network1", "network2"} {
+		err = dbs[0].JoinNetwork(nid)
+		assert.NoError(t, err)
+		err = dbs[1].JoinNetwork(nid)
+		assert.NoError(t, err)
+	}
+
+	for _, key := range []string{"key1", "key2"} {
+		err = dbs[0].CreateEntry("test_table", "network1", key, []byte("value"))
+		assert.NoError(t, err)
+	}
+	err = dbs[0].CreateEntry("test_table", "network2", "key3", []byte("value"))
+	assert.NoError(t, err)
+
+	dbs[1].verifyEntryExistence(t, "test_table", "network1", "key1", "value", true)
+	dbs[1].verifyEntryExistence(t, "test_table", "network1", "key2", "value", true)
+	dbs[1].verifyEntryExistence(t, "test_table", "network2", "key3", "value", true)
+
+	tableClock := dbs[1].tableClock.Time()
+	dbs[1].Close()
+	dbs[0].Close()
+
+	db, err := New(&Config{
+		NodeName:     "node2",
+		BindPort:     int(atomic.AddInt32(&dbPort, 1)),
+		SnapshotPath: filepath.Join(dir, "snapshot.json"),
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.True(t, db.tableClock.Time() >= tableClock)
+	for _, key := range []string{"key1", "key2"} {
+		db.verifyEntryExistence(t, "test_table", "network1", key, "value", true)
+		assert.True(t, db.isProvisional("test_table", "network1", key))
+	}
+	assert.True(t, db.isProvisional("test_table", "network2", "key3"))
+	_, ok := db.networks["node1"]["network1"]
+	assert.True(t, ok)
+
+	err = db.JoinNetwork("network1")
+	assert.NoError(t, err)
+
+	// The peer confirms key1 and deleted key2 while this node was down
+	e, err := db.getEntry("test_table", "network1", "key1")
+	require.NoError(t, err)
+	db.handleTableEvent(&TableEvent{
+		Type:      TableEventTypeCreate,
+		LTime:     e.ltime,
+		NodeName:  "node1",
+		NetworkID: "network1",
+		TableName: "test_table",
+		Key:       "key1",
+		Value:     []byte("value"),
+	})
+	assert.False(t, db.isProvisional("test_table", "network1", "key1"))
+
+	e, err = db.getEntry("test_table", "network1", "key2")
+	require.NoError(t, err)
+	db.handleTableEvent(&TableEvent{
+		Type:      TableEventTypeDelete,
+		LTime:     e.ltime + 1,
+		NodeName:  "node1",
+		NetworkID: "network1",
+		TableName: "test_table",
+		Key:       "key2",
+		Value:     []byte("value"),
+	})
+	db.verifyEntryExistence(t, "test_table", "network1", "key2", "", false)
+
+	// network2 is not joined again, its entries are not confirmed
+	ch, cancel := db.Watch("test_table", "network2", "")
+	defer cancel()
+
+	db.Lock()
+	db.reconcileDeadline = time.Now().Add(-time.Second)
+	db.Unlock()
+	db.expireProvisional()
+
+	testWatch(t, ch, DeleteEvent{}, "test_table", "network2", "key3", "value")
+	e, err = db.getEntry("test_table", "network2", "key3")
+	require.NoError(t, err)
+	assert.True(t, e.deleting)
+	e, err = db.getEntry("test_table", "network1", "key1")
+	require.NoError(t, err)
+	assert.False(t, e.deleting)
+}
+
+func TestNetworkDBProvisionalNetworkNewerJoin(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 1, "node")
+	db := dbs[0]
+	defer closeNetworkDBInstances(dbs)
+
+	db.Lock()
+	db.networks["node2"] = map[string]*network{
+		"network1": {id: "network1", ltime: 5, provisional: true},
+	}
+	db.Unlock()
+
+	// The peer joined the network again while this node was down
+	db.handleNetworkEvent(&NetworkEvent{
+		Type:      NetworkEventTypeJoin,
+		LTime:     7,
+		NodeName:  "node2",
+		NetworkID: "network1",
+	})
+
+	db.Lock()
+	db.reconcileDeadline = time.Now().Add(-time.Second)
+	db.Unlock()
+	db.expireProvisional()
+
+	db.RLock()
+	n := db.networks["node2"]["network1"]
+	db.RUnlock()
+	assert.False(t, n.provisional)
+	assert.False(t, n.leaving)
+	assert.Equal(t, serf.LamportTime(7), n.ltime)
+}
+
+func TestNetworkDBSnapshotNotBulkSynced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "networkdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The snapshot holds the entry of a node which is gone since
+	path := filepath.Join(dir, "snapshot.json")
+	buf, err := json.Marshal(&snapshot{
+		NodeName:     "node2",
+		NetworkClock: 10,
+		TableClock:   10,
+		Networks:     []snapshotNetwork{{NodeName: "node9", NetworkID: "network1", LTime: 5}},
+		Entries: []snapshotEntry{{
+			TableName: "test_table",
+			NetworkID: "network1",
+			Key:       "stale",
+			NodeName:  "node9",
+			LTime:     5,
+			Value:     []byte("value"),
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, buf, 0600))
+
+	sim := NewSimNetwork(1)
+	db1, err := New(&Config{NodeName: "node1", Transport: sim})
+	require.NoError(t, err)
+	defer db1.Close()
+	db2, err := New(&Config{NodeName: "node2", Transport: sim, SnapshotPath: path})
+	require.NoError(t, err)
+	defer db2.Close()
+	assert.True(t, db2.isProvisional("test_table", "network1", "stale"))
+
+	require.NoError(t, db2.Join([]string{sim.Addr("node1")}))
+	require.NoError(t, db1.JoinNetwork("network1"))
+	require.NoError(t, db2.JoinNetwork("network1"))
+	require.NoError(t, db2.CreateEntry("test_table", "network1", "key1", []byte("value")))
+	require.True(t, stepUntil(sim, 5*time.Second, func() bool {
+		return len(db2.findCommonNetworks("node1")) == 1
+	}))
+
+	_, err = db2.bulkSync("network1", []string{"node1"}, true)
+	require.NoError(t, err)
+
+	db1.verifyEntryExistence(t, "test_table", "network1", "key1", "value", true)
+	_, err = db1.GetEntry("test_table", "network1", "stale")
+	assert.Error(t, err)
+}
+
+func TestNetworkDBIntrospection(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 2, "node")
+
+	for _, db := range dbs {
+		err := db.JoinNetwork("network1")
+		assert.NoError(t, err)
+	}
+	dbs[0].verifyNetworkExistence(t, "node2", "network1", true)
+
+	for _, key := range []string{"key1", "key2"} {
+		err := dbs[0].CreateEntry("test_table", "network1", key, []byte("value"))
+		assert.NoError(t, err)
+	}
+	err := dbs[1].CreateEntry("test_table", "network1", "key3", []byte("value"))
+	assert.NoError(t, err)
+	dbs[1].verifyEntryExistence(t, "test_table", "network1", "key1", "value", true)
+	dbs[1].verifyEntryExistence(t, "test_table", "network1", "key2", "value", true)
+	err = dbs[0].DeleteEntry("test_table", "network1", "key2")
+	assert.NoError(t, err)
+	dbs[1].verifyEntryExistence(t, "test_table", "network1", "key2", "", false)
+
+	nodes := dbs[1].Nodes()
+	require.Len(t, nodes, 2)
+	assert.Equal(t, "node1", nodes[0].Name)
+	assert.Equal(t, NodeStateActive, nodes[0].State)
+	assert.Equal(t, dbs[0].config.BindPort, int(nodes[0].Port))
+
+	networks := dbs[1].Networks()
+	require.Len(t, networks, 2)
+	assert.Equal(t, "node1", networks[0].NodeName)
+	assert.Equal(t, "node2", networks[1].NodeName)
+	assert.Equal(t, "network1", networks[1].NetworkID)
+
+	assert.Equal(t, map[string][]string{"network1": {"node1", "node2"}}, dbs[1].NetworkNodes())
+
+	tables := dbs[1].Tables()
+	require.Len(t, tables, 1)
+	assert.Equal(t, TableInfo{
+		Name:     "test_table",
+		Entries:  3,
+		Deleting: 1,
+		Owners:   map[string]int{"node1": 2, "node2": 1},
+	}, tables[0])
+
+	entries := dbs[1].Entries("test_table", "network1")
+	require.Len(t, entries, 3)
+	for i, key := range []string{"key1", "key2", "key3"} {
+		assert.Equal(t, key, entries[i].Key)
+	}
+	assert.True(t, entries[1].Deleting)
+	assert.True(t, entries[1].LTime > entries[0].LTime)
+	assert.Equal(t, "node2", entries[2].NodeName)
+	assert.Empty(t, dbs[1].Entries("test_table", "network2"))
+
+	closeNetworkDBInstances(dbs)
+}
+
+func TestNetworkDBConfigTunables(t *testing.T) {
+	sim := NewSimNetwork(1)
+
+	_, err := New(&Config{NodeName: "node0", Transport: sim, Profile: "moon"})
+	assert.Error(t, err)
+	_, err = New(&Config{NodeName: "node0", Transport: sim, PacketBufferSize: maxPacketBufferSize + 1})
+	assert.Error(t, err)
+
+	config := &Config{
+		NodeName:          "node1",
+		Transport:         sim,
+		Profile:           ProfileWAN,
+		RetransmitMult:    6,
+		TombstoneLifetime: time.Hour,
+	}
+	db, err := New(config)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// The defaults are filled in a copy of the configuration
+	assert.Equal(t, time.Duration(0), config.ReapInterval)
+	assert.Equal(t, 0, config.PacketBufferSize)
+
+	wan := memberlist.DefaultWANConfig()
+	assert.Equal(t, wan.GossipInterval, db.mConfig.GossipInterval)
+	assert.Equal(t, wan.PushPullInterval, db.mConfig.PushPullInterval)
+	assert.Equal(t, 6, db.mConfig.RetransmitMult)
+	assert.Equal(t, reapInterval, db.config.ReapInterval)
+	assert.Equal(t, udpSendBuf, db.config.PacketBufferSize)
+
+	err = db.JoinNetwork("network1")
+	require.NoError(t, err)
+	assert.Equal(t, 6, db.networks["node1"]["network1"].tableBroadcasts.RetransmitMult)
+
+	err = db.CreateEntry("test_table", "network1", "key1", []byte("value"))
+	require.NoError(t, err)
+	err = db.DeleteEntry("test_table", "network1", "key1")
+	require.NoError(t, err)
+
+	// The tombstone outlives the default reap interval
+	db.Lock()
+	e, ok := db.indexes[byTable].Get("/test_table/network1/key1")
+	require.True(t, ok)
+	e.(*entry).deleteTime = time.Now().Add(-2 * reapInterval)
+	db.Unlock()
+
+	db.reapTableEntries()
+	db.RLock()
+	_, ok = db.indexes[byTable].Get("/test_table/network1/key1")
+	db.RUnlock()
+	assert.True(t, ok)
+}
+
+func TestNetworkDBNodeLabels(t *testing.T) {
+	sim := NewSimNetwork(1)
+
+	labels := map[string]string{"zone": strings.Repeat("a", memberlist.MetaMaxSize)}
+	_, err := New(&Config{NodeName: "node0", Transport: sim, Labels: labels})
+	assert.Error(t, err)
+
+	var dbs []*NetworkDB
+	for i, zone := range []string{"a", "b"} {
+		db, err := New(&Config{
+			NodeName:  fmt.Sprintf("node%d", i+1),
+			Transport: sim,
+			Labels:    map[string]string{"zone": zone},
+		})
+		require.NoError(t, err)
+		dbs = append(dbs, db)
+	}
+	defer closeNetworkDBInstances(dbs)
+
+	_, err = dbs[1].memberlist.Join([]string{sim.Addr("node1")})
+	require.NoError(t, err)
+
+	sim.Step(0)
+	nodeLabels, ok := dbs[0].NodeLabels("node2")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"zone": "b"}, nodeLabels)
+
+	nodeLabels, ok = dbs[1].NodeLabels("node1")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"zone": "a"}, nodeLabels)
+
+	_, ok = dbs[0].NodeLabels("node3")
+	assert.False(t, ok)
+
+	nodes := dbs[1].Nodes()
+	require.Len(t, nodes, 2)
+	assert.Equal(t, "node1", nodes[0].Name)
+	assert.Equal(t, map[string]string{"zone": "a"}, nodes[0].Labels)
+}
+
 func TestNetworkDBCRUDMediumCluster(t *testing.T) {
 	n := 5
 
@@ -0,0 +1,58 @@
+package networkdb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/memberlist"
+)
+
+// nodeMeta is the metadata a node advertises to the cluster through
+// memberlist
+type nodeMeta struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// encodeNodeMeta encodes the metadata of this node. It fails if the
+// metadata does not fit in a memberlist node message.
+func encodeNodeMeta(labels map[string]string) ([]byte, error) {
+	if len(labels) == 0 {
+		return []byte{}, nil
+	}
+
+	buf, err := json.Marshal(&nodeMeta{Labels: labels})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the node labels: %v", err)
+	}
+	if len(buf) > memberlist.MetaMaxSize {
+		return nil, fmt.Errorf("node labels take %d bytes, more than the limit of %d", len(buf), memberlist.MetaMaxSize)
+	}
+	return buf, nil
+}
+
+// decodeNodeMeta returns the labels in the metadata of a node
+func decodeNodeMeta(n *memberlist.Node) map[string]string {
+	if len(n.Meta) == 0 {
+		return nil
+	}
+
+	var meta nodeMeta
+	if err := json.Unmarshal(n.Meta, &meta); err != nil {
+		logrus.Warnf("Invalid metadata advertised by node %s: %v", n.Name, err)
+		return nil
+	}
+	return meta.Labels
+}
+
+// NodeLabels returns the labels advertised by a node of the cluster. The
+// boolean is false if the node is not known.
+func (nDB *NetworkDB) NodeLabels(name string) (map[string]string, bool) {
+	nDB.RLock()
+	n, ok := nDB.nodes[name]
+	nDB.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return decodeNodeMeta(n), true
+}
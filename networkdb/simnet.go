@@ -0,0 +1,606 @@
+package networkdb
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// SimNetwork is an in-process network the NetworkDB instances can run on
+// instead of memberlist, by setting it as their Transport. It is meant to
+// simulate large clusters in tests: the messages can be delayed and lost,
+// and the nodes partitioned. Failure detection is not simulated, the nodes
+// which cannot communicate stay members of the cluster until they are
+// declared failed with Fail.
+//
+// The network has its own clock, which only advances with Step. The
+// periodic tasks of the nodes and of their NetworkDB instances, and the
+// delayed messages, run on this clock.
+type SimNetwork struct {
+	sync.Mutex
+	latency  time.Duration
+	loss     float64
+	rand     *rand.Rand
+	nodes    map[string]*simNode // by node name
+	addrs    map[string]*simNode // by node address
+	groups   map[string]int      // partition by node name
+	parts    int
+	clusters int
+	nextIP   uint32
+
+	// Time elapsed since the network was created and the timers to run,
+	// by expiration
+	now    time.Duration
+	timers simTimers
+	seq    uint64
+	// Number of messages and events queued to the nodes and not processed
+	// yet, idle is signaled when it drops to zero
+	pending int
+	idle    *sync.Cond
+}
+
+// simTimer is a function to run at a time of the SimNetwork clock
+type simTimer struct {
+	at  time.Duration
+	seq uint64
+	fn  func()
+}
+
+// simTimers is a heap of timers, by expiration then by creation order
+type simTimers []*simTimer
+
+func (t simTimers) Len() int { return len(t) }
+func (t simTimers) Less(i, j int) bool {
+	if t[i].at != t[j].at {
+		return t[i].at < t[j].at
+	}
+	return t[i].seq < t[j].seq
+}
+func (t simTimers) Swap(i, j int)       { t[i], t[j] = t[j], t[i] }
+func (t *simTimers) Push(x interface{}) { *t = append(*t, x.(*simTimer)) }
+func (t *simTimers) Pop() interface{} {
+	old := *t
+	n := len(old)
+	x := old[n-1]
+	*t = old[:n-1]
+	return x
+}
+
+// simNode is the cluster layer of a NetworkDB instance on a SimNetwork
+type simNode struct {
+	sim     *SimNetwork
+	node    *memberlist.Node
+	conf    *memberlist.Config
+	cluster int
+	// The node left the cluster or was declared failed
+	down bool
+	// The node was shut down, its queue is not processed anymore
+	stopped bool
+
+	// Messages and events to be processed by the node, in order
+	queue  []func()
+	signal chan struct{}
+	stopCh chan struct{}
+	sync.Mutex
+}
+
+// NewSimNetwork returns a simulated network. The seed makes the random
+// choices of the network, which nodes to gossip to and which messages to
+// lose, reproducible.
+func NewSimNetwork(seed int64) *SimNetwork {
+	s := &SimNetwork{
+		rand:   rand.New(rand.NewSource(seed)),
+		nodes:  make(map[string]*simNode),
+		addrs:  make(map[string]*simNode),
+		groups: make(map[string]int),
+		nextIP: 10<<24 + 1,
+	}
+	s.idle = sync.NewCond(&s.Mutex)
+	return s
+}
+
+// Step advances the clock of the network by d. The timers expiring within
+// d run in order, each once the nodes processed the messages and events
+// queued to them. Step returns once the nodes processed the messages sent
+// by the last timer.
+func (s *SimNetwork) Step(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	end := s.now + d
+	for {
+		for s.pending > 0 {
+			s.idle.Wait()
+		}
+		if len(s.timers) == 0 || s.timers[0].at > end {
+			break
+		}
+		t := heap.Pop(&s.timers).(*simTimer)
+		s.now = t.at
+		s.Unlock()
+		t.fn()
+		s.Lock()
+	}
+	s.now = end
+}
+
+// Every implements the Clock interface. The first run is staggered by a
+// random fraction of the interval, so that the nodes do not run in lockstep.
+func (s *SimNetwork) Every(interval time.Duration, stop <-chan struct{}, fn func()) {
+	s.Lock()
+	defer s.Unlock()
+
+	var tick func()
+	tick = func() {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		s.Lock()
+		s.afterFuncLocked(interval, tick)
+		s.Unlock()
+		fn()
+	}
+	s.afterFuncLocked(time.Duration(s.rand.Int63n(int64(interval))), tick)
+}
+
+// afterFuncLocked schedules fn to run after d on the clock of the network.
+// Caller should hold the SimNetwork lock.
+func (s *SimNetwork) afterFuncLocked(d time.Duration, fn func()) {
+	s.seq++
+	heap.Push(&s.timers, &simTimer{at: s.now + d, seq: s.seq, fn: fn})
+}
+
+// done accounts for n messages or events processed or dropped by a node
+func (s *SimNetwork) done(n int) {
+	s.Lock()
+	s.pending -= n
+	if s.pending == 0 {
+		s.idle.Broadcast()
+	}
+	s.Unlock()
+}
+
+// SetLatency sets the time the best effort messages take to reach their
+// destination. The reliable messages are delivered without delay, so that
+// the exchanges waiting for an answer, as the bulk sync, complete without
+// the clock advancing.
+func (s *SimNetwork) SetLatency(latency time.Duration) {
+	s.Lock()
+	s.latency = latency
+	s.Unlock()
+}
+
+// SetLoss sets the probability, between 0 and 1, of losing a best effort
+// message. Reliable messages are not lost.
+func (s *SimNetwork) SetLoss(loss float64) {
+	s.Lock()
+	s.loss = loss
+	s.Unlock()
+}
+
+// Partition isolates the passed nodes from the other nodes. Each call
+// creates a new partition, the nodes can only communicate with the nodes
+// of the same partition.
+func (s *SimNetwork) Partition(nodes ...string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.parts++
+	for _, name := range nodes {
+		s.groups[name] = s.parts
+	}
+}
+
+// Heal removes all the partitions
+func (s *SimNetwork) Heal() {
+	s.Lock()
+	s.groups = make(map[string]int)
+	s.Unlock()
+}
+
+// Fail declares a node failed, as the failure detection would. The node is
+// removed from the cluster without leaving it and its messages are lost.
+func (s *SimNetwork) Fail(name string) {
+	s.Lock()
+	n, ok := s.nodes[name]
+	if !ok || n.down {
+		s.Unlock()
+		return
+	}
+	n.down = true
+	members := s.membersLocked(n.cluster, n)
+	s.Unlock()
+
+	for _, m := range members {
+		m.notifyLeave(n.node)
+	}
+}
+
+// Addr returns the address of a node to join the cluster through
+func (s *SimNetwork) Addr(name string) string {
+	s.Lock()
+	defer s.Unlock()
+
+	if n, ok := s.nodes[name]; ok {
+		return net.JoinHostPort(n.node.Addr.String(), strconv.Itoa(int(n.node.Port)))
+	}
+	return ""
+}
+
+// Create implements the Transport interface
+func (s *SimNetwork) Create(conf *memberlist.Config) (Cluster, error) {
+	s.Lock()
+	if _, ok := s.nodes[conf.Name]; ok {
+		s.Unlock()
+		return nil, fmt.Errorf("node %s already exists in the simulated network", conf.Name)
+	}
+
+	ip := make(net.IP, 4)
+	ip[0], ip[1], ip[2], ip[3] = byte(s.nextIP>>24), byte(s.nextIP>>16), byte(s.nextIP>>8), byte(s.nextIP)
+	s.nextIP++
+
+	node := &memberlist.Node{
+		Name: conf.Name,
+		Addr: ip,
+		Port: uint16(conf.BindPort),
+	}
+	if conf.Delegate != nil {
+		node.Meta = conf.Delegate.NodeMeta(memberlist.MetaMaxSize)
+	}
+
+	s.clusters++
+	n := &simNode{
+		sim:     s,
+		node:    node,
+		conf:    conf,
+		cluster: s.clusters,
+		signal:  make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+	}
+	s.nodes[conf.Name] = n
+	s.addrs[net.JoinHostPort(ip.String(), strconv.Itoa(conf.BindPort))] = n
+	s.Unlock()
+
+	// The node knows about itself, as with memberlist
+	if conf.Events != nil {
+		n.notifyJoin(node)
+	}
+
+	go n.run()
+	n.gossip()
+
+	return n, nil
+}
+
+// membersLocked returns the nodes up in a cluster, but the excluded one.
+// Caller should hold the SimNetwork lock.
+func (s *SimNetwork) membersLocked(cluster int, exclude *simNode) []*simNode {
+	var members []*simNode
+	for _, n := range s.nodes {
+		if n != exclude && !n.down && n.cluster == cluster {
+			members = append(members, n)
+		}
+	}
+	return members
+}
+
+// reachableLocked tells whether the nodes can communicate. Caller should
+// hold the SimNetwork lock.
+func (s *SimNetwork) reachableLocked(from, to *simNode) bool {
+	return !from.down && !to.down && s.groups[from.node.Name] == s.groups[to.node.Name]
+}
+
+// send delivers a message to a node. Best effort messages are delayed by the
+// network latency and may be lost, reliable messages fail if the node is
+// unreachable.
+func (s *SimNetwork) send(from *simNode, to string, msg []byte, reliable bool) error {
+	s.Lock()
+	n, ok := s.nodes[to]
+	if !ok {
+		s.Unlock()
+		return fmt.Errorf("unknown node %s", to)
+	}
+	if !s.reachableLocked(from, n) {
+		s.Unlock()
+		if reliable {
+			return fmt.Errorf("node %s is unreachable", to)
+		}
+		return nil
+	}
+	if !reliable && s.loss > 0 && s.rand.Float64() < s.loss {
+		s.Unlock()
+		return nil
+	}
+	var latency time.Duration
+	if !reliable {
+		latency = s.latency
+	}
+	s.Unlock()
+
+	buf := make([]byte, len(msg))
+	copy(buf, msg)
+	n.deliver(latency, func() {
+		if n.conf.Delegate != nil {
+			n.conf.Delegate.NotifyMsg(buf)
+		}
+	})
+	return nil
+}
+
+// deliver queues a function to be run by the node after a delay on the
+// clock of the network
+func (n *simNode) deliver(delay time.Duration, fn func()) {
+	if delay > 0 {
+		s := n.sim
+		s.Lock()
+		s.afterFuncLocked(delay, func() { n.enqueue(fn) })
+		s.Unlock()
+		return
+	}
+	n.enqueue(fn)
+}
+
+func (n *simNode) enqueue(fn func()) {
+	s := n.sim
+	s.Lock()
+	n.Lock()
+	if n.stopped {
+		n.Unlock()
+		s.Unlock()
+		return
+	}
+	n.queue = append(n.queue, fn)
+	s.pending++
+	n.Unlock()
+	s.Unlock()
+
+	select {
+	case n.signal <- struct{}{}:
+	default:
+	}
+}
+
+// run processes the messages and events of the node in order
+func (n *simNode) run() {
+	for {
+		select {
+		case <-n.signal:
+		case <-n.stopCh:
+			return
+		}
+
+		for {
+			n.Lock()
+			if len(n.queue) == 0 {
+				n.Unlock()
+				break
+			}
+			fn := n.queue[0]
+			n.queue = n.queue[1:]
+			n.Unlock()
+
+			fn()
+			n.sim.done(1)
+		}
+	}
+}
+
+// gossip schedules the periodic sending of the broadcasts of the node to
+// random members and the exchange of the state with a random member, as
+// memberlist does
+func (n *simNode) gossip() {
+	gossipInterval := n.conf.GossipInterval
+	if gossipInterval <= 0 {
+		gossipInterval = 200 * time.Millisecond
+	}
+	pushPullInterval := n.conf.PushPullInterval
+	if pushPullInterval <= 0 {
+		pushPullInterval = 30 * time.Second
+	}
+
+	n.sim.Every(gossipInterval, n.stopCh, n.gossipBroadcasts)
+	n.sim.Every(pushPullInterval, n.stopCh, func() {
+		if peers := n.randomMembers(1); len(peers) > 0 {
+			n.pushPull(peers[0])
+		}
+	})
+}
+
+func (n *simNode) gossipBroadcasts() {
+	if n.conf.Delegate == nil {
+		return
+	}
+
+	for _, peer := range n.randomMembers(n.conf.GossipNodes) {
+		msgs := n.conf.Delegate.GetBroadcasts(compoundOverhead, udpSendBuf-compoundHeaderOverhead)
+		if len(msgs) == 0 {
+			return
+		}
+		for _, msg := range msgs {
+			n.sim.send(n, peer.node.Name, msg, false)
+		}
+	}
+}
+
+// randomMembers returns up to k random members of the cluster of the node
+func (n *simNode) randomMembers(k int) []*simNode {
+	s := n.sim
+	s.Lock()
+	defer s.Unlock()
+
+	if n.down {
+		return nil
+	}
+	members := s.membersLocked(n.cluster, n)
+	if k <= 0 || len(members) == 0 {
+		return nil
+	}
+	// Sort first so that the choice only depends on the seed
+	sort.Sort(bySimNodeName(members))
+	for i := len(members) - 1; i > 0; i-- {
+		j := s.rand.Intn(i + 1)
+		members[i], members[j] = members[j], members[i]
+	}
+	if len(members) > k {
+		members = members[:k]
+	}
+	return members
+}
+
+// pushPull exchanges the complete state of the node with a peer
+func (n *simNode) pushPull(peer *simNode) error {
+	s := n.sim
+	s.Lock()
+	reachable := s.reachableLocked(n, peer)
+	s.Unlock()
+	if !reachable {
+		return fmt.Errorf("node %s is unreachable", peer.node.Name)
+	}
+
+	if n.conf.Delegate == nil || peer.conf.Delegate == nil {
+		return nil
+	}
+
+	local := n.conf.Delegate.LocalState(true)
+	remote := peer.conf.Delegate.LocalState(true)
+	peer.deliver(0, func() { peer.conf.Delegate.MergeRemoteState(local, true) })
+	n.conf.Delegate.MergeRemoteState(remote, true)
+	return nil
+}
+
+func (n *simNode) notifyJoin(node *memberlist.Node) {
+	if n.conf.Events != nil {
+		n.deliver(0, func() { n.conf.Events.NotifyJoin(node) })
+	}
+}
+
+func (n *simNode) notifyLeave(node *memberlist.Node) {
+	if n.conf.Events != nil {
+		n.deliver(0, func() { n.conf.Events.NotifyLeave(node) })
+	}
+}
+
+// Join merges the cluster of the node with the cluster of the existing
+// members, which can be passed by name or address
+func (n *simNode) Join(existing []string) (int, error) {
+	s := n.sim
+	var (
+		contacted int
+		lastErr   error
+	)
+
+	for _, addr := range existing {
+		s.Lock()
+		peer, ok := s.addrs[addr]
+		if !ok {
+			peer, ok = s.nodes[addr]
+		}
+		if !ok || !s.reachableLocked(n, peer) {
+			s.Unlock()
+			lastErr = fmt.Errorf("failed to join %s: node is unreachable", addr)
+			continue
+		}
+
+		var joined, members []*simNode
+		if peer.cluster != n.cluster {
+			joined = s.membersLocked(n.cluster, nil)
+			members = s.membersLocked(peer.cluster, nil)
+			for _, j := range joined {
+				j.cluster = peer.cluster
+			}
+		}
+		s.Unlock()
+
+		// The joining node learns about the members before Join
+		// returns, as with memberlist
+		for _, j := range joined {
+			for _, m := range members {
+				if j == n && n.conf.Events != nil {
+					n.conf.Events.NotifyJoin(m.node)
+				} else {
+					j.notifyJoin(m.node)
+				}
+				m.notifyJoin(j.node)
+			}
+		}
+
+		if err := n.pushPull(peer); err != nil {
+			lastErr = err
+			continue
+		}
+		contacted++
+	}
+
+	if contacted == 0 {
+		return 0, lastErr
+	}
+	return contacted, nil
+}
+
+// Leave notifies the members of the cluster that the node leaves
+func (n *simNode) Leave(timeout time.Duration) error {
+	s := n.sim
+	s.Lock()
+	if n.down {
+		s.Unlock()
+		return nil
+	}
+	members := s.membersLocked(n.cluster, n)
+	n.down = true
+	s.Unlock()
+
+	for _, m := range members {
+		m.notifyLeave(n.node)
+	}
+	return nil
+}
+
+// Shutdown stops the node and removes it from the network
+func (n *simNode) Shutdown() error {
+	s := n.sim
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.nodes[n.node.Name]; !ok {
+		return nil
+	}
+	n.down = true
+	delete(s.nodes, n.node.Name)
+	delete(s.addrs, net.JoinHostPort(n.node.Addr.String(), strconv.Itoa(int(n.node.Port))))
+	delete(s.groups, n.node.Name)
+	close(n.stopCh)
+
+	// Drop the messages and events the node will not process
+	n.Lock()
+	n.stopped = true
+	s.pending -= len(n.queue)
+	n.queue = nil
+	n.Unlock()
+	if s.pending == 0 {
+		s.idle.Broadcast()
+	}
+	return nil
+}
+
+func (n *simNode) SendToUDP(to *memberlist.Node, msg []byte) error {
+	return n.sim.send(n, to.Name, msg, false)
+}
+
+func (n *simNode) SendToTCP(to *memberlist.Node, msg []byte) error {
+	return n.sim.send(n, to.Name, msg, true)
+}
+
+type bySimNodeName []*simNode
+
+func (n bySimNodeName) Len() int           { return len(n) }
+func (n bySimNodeName) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n bySimNodeName) Less(i, j int) bool { return n[i].node.Name < n[j].node.Name }
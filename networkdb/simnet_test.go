@@ -0,0 +1,185 @@
+package networkdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createSimNetworkDBInstances(t *testing.T, sim *SimNetwork, num int, namePrefix string) []*NetworkDB {
+	var dbs []*NetworkDB
+	for i := 0; i < num; i++ {
+		db, err := New(&Config{
+			NodeName:  fmt.Sprintf("%s%d", namePrefix, i+1),
+			BindPort:  7946,
+			Transport: sim,
+		})
+		require.NoError(t, err)
+
+		if i != 0 {
+			_, err = db.memberlist.Join([]string{sim.Addr(dbs[0].config.NodeName)})
+			require.NoError(t, err)
+		}
+
+		dbs = append(dbs, db)
+	}
+	sim.Step(0)
+
+	return dbs
+}
+
+// stepUntil advances the clock of the simulated network until cond holds,
+// for at most max
+func stepUntil(sim *SimNetwork, max time.Duration, cond func() bool) bool {
+	const step = 100 * time.Millisecond
+	for elapsed := time.Duration(0); elapsed < max; elapsed += step {
+		if cond() {
+			return true
+		}
+		sim.Step(step)
+	}
+	return cond()
+}
+
+func stepUntilNetworkNodes(t *testing.T, sim *SimNetwork, dbs []*NetworkDB, nid string) {
+	require.True(t, stepUntil(sim, 5*time.Second, func() bool {
+		for _, db := range dbs {
+			db.RLock()
+			n := len(db.networkNodes[nid])
+			db.RUnlock()
+			if n != len(dbs) {
+				return false
+			}
+		}
+		return true
+	}), "network %s did not converge", nid)
+}
+
+func (db *NetworkDB) countEntries(tname, nid string) int {
+	var n int
+	for _, e := range db.Entries(tname, nid) {
+		if !e.Deleting {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSimNetworkConvergence(t *testing.T) {
+	sim := NewSimNetwork(1)
+	sim.SetLatency(time.Millisecond)
+	sim.SetLoss(0.1)
+
+	n := 100
+	dbs := createSimNetworkDBInstances(t, sim, n, "node")
+	defer closeNetworkDBInstances(dbs)
+
+	for _, db := range dbs {
+		err := db.JoinNetwork("network1")
+		assert.NoError(t, err)
+	}
+	for i, db := range dbs {
+		err := db.CreateEntry("test_table", "network1", fmt.Sprintf("key%d", i), []byte("value"))
+		assert.NoError(t, err)
+	}
+
+	converged := func() bool {
+		for _, db := range dbs {
+			if db.countEntries("test_table", "network1") != n {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Gossip alone may miss the messages which were lost, the periodic
+	// bulk sync repairs the tables
+	assert.True(t, stepUntil(sim, 10*time.Minute, converged))
+}
+
+func (db *NetworkDB) hasEntry(tname, nid, key string) bool {
+	_, err := db.GetEntry(tname, nid, key)
+	return err == nil
+}
+
+func TestSimNetworkPartition(t *testing.T) {
+	sim := NewSimNetwork(1)
+	dbs := createSimNetworkDBInstances(t, sim, 4, "node")
+	defer closeNetworkDBInstances(dbs)
+
+	for _, db := range dbs {
+		err := db.JoinNetwork("network1")
+		assert.NoError(t, err)
+	}
+	stepUntilNetworkNodes(t, sim, dbs, "network1")
+
+	sim.Partition("node3", "node4")
+
+	err := dbs[0].CreateEntry("test_table", "network1", "key1", []byte("value"))
+	assert.NoError(t, err)
+	assert.True(t, stepUntil(sim, 5*time.Second, func() bool {
+		return dbs[1].hasEntry("test_table", "network1", "key1")
+	}))
+
+	// Give the gossip the time to cross the partition, if it could
+	sim.Step(5 * time.Second)
+	for _, db := range dbs[2:] {
+		_, err = db.GetEntry("test_table", "network1", "key1")
+		assert.Error(t, err)
+	}
+
+	// The bulk sync fails through the partition
+	_, err = dbs[2].bulkSync("network1", []string{"node1"}, true)
+	assert.Error(t, err)
+
+	sim.Heal()
+	_, err = dbs[2].bulkSync("network1", []string{"node1"}, true)
+	assert.NoError(t, err)
+	value, err := dbs[2].GetEntry("test_table", "network1", "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(value))
+}
+
+func TestSimNetworkReap(t *testing.T) {
+	sim := NewSimNetwork(1)
+	dbs := createSimNetworkDBInstances(t, sim, 3, "node")
+	defer closeNetworkDBInstances(dbs)
+
+	for _, db := range dbs {
+		err := db.JoinNetwork("network1")
+		assert.NoError(t, err)
+	}
+	stepUntilNetworkNodes(t, sim, dbs, "network1")
+
+	err := dbs[1].CreateEntry("test_table", "network1", "key1", []byte("value"))
+	assert.NoError(t, err)
+	assert.True(t, stepUntil(sim, 5*time.Second, func() bool {
+		return dbs[0].hasEntry("test_table", "network1", "key1")
+	}))
+
+	// The failed node entries are deleted, then reaped
+	sim.Fail("node2")
+	sim.Step(0)
+	assert.Equal(t, 0, dbs[0].countEntries("test_table", "network1"))
+	dbs[0].RLock()
+	_, ok := dbs[0].nodes["node2"]
+	dbs[0].RUnlock()
+	assert.False(t, ok)
+
+	dbs[0].reapTableEntries()
+	_, err = dbs[0].GetEntry("test_table", "network1", "key1")
+	assert.NoError(t, err, "entry reaped before the reap interval")
+
+	dbs[0].Lock()
+	e, ok := dbs[0].indexes[byTable].Get("/test_table/network1/key1")
+	require.True(t, ok)
+	e.(*entry).deleteTime = time.Now().Add(-dbs[0].config.TombstoneLifetime - time.Second)
+	dbs[0].Unlock()
+
+	dbs[0].reapTableEntries()
+	_, err = dbs[0].GetEntry("test_table", "network1", "key1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,264 @@
+package networkdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	defaultSnapshotInterval = 30 * time.Second
+	// reconcileTimeout is the time the state restored from the snapshot
+	// has to be confirmed by the peers before it is expired
+	reconcileTimeout = 2 * time.Minute
+)
+
+// snapshot is the state of the peer nodes saved on disk so that it can be
+// restored when the node restarts. The state of this node is not saved, it
+// is created again by the NetworkDB users.
+type snapshot struct {
+	NodeName     string            `json:"node_name"`
+	NetworkClock serf.LamportTime  `json:"network_clock"`
+	TableClock   serf.LamportTime  `json:"table_clock"`
+	Networks     []snapshotNetwork `json:"networks"`
+	Entries      []snapshotEntry   `json:"entries"`
+}
+
+type snapshotNetwork struct {
+	NodeName  string           `json:"node_name"`
+	NetworkID string           `json:"network_id"`
+	LTime     serf.LamportTime `json:"ltime"`
+}
+
+type snapshotEntry struct {
+	TableName string           `json:"table_name"`
+	NetworkID string           `json:"network_id"`
+	Key       string           `json:"key"`
+	NodeName  string           `json:"node_name"`
+	LTime     serf.LamportTime `json:"ltime"`
+	Value     []byte           `json:"value"`
+}
+
+// saveSnapshot writes the state of the peer nodes and the lamport clocks
+// to the snapshot file
+func (nDB *NetworkDB) saveSnapshot() error {
+	path := nDB.config.SnapshotPath
+	if path == "" {
+		return nil
+	}
+
+	s := snapshot{
+		NodeName:     nDB.config.NodeName,
+		NetworkClock: nDB.networkClock.Time(),
+		TableClock:   nDB.tableClock.Time(),
+	}
+
+	nDB.RLock()
+	for name, nn := range nDB.networks {
+		if name == nDB.config.NodeName {
+			continue
+		}
+		for nid, n := range nn {
+			if n.leaving {
+				continue
+			}
+			s.Networks = append(s.Networks, snapshotNetwork{
+				NodeName:  name,
+				NetworkID: nid,
+				LTime:     n.ltime,
+			})
+		}
+	}
+	nDB.indexes[byTable].Walk(func(path string, v interface{}) bool {
+		entry, ok := v.(*entry)
+		if !ok || entry.node == nDB.config.NodeName || entry.deleting {
+			return false
+		}
+
+		params := strings.Split(path[1:], "/")
+		s.Entries = append(s.Entries, snapshotEntry{
+			TableName: params[0],
+			NetworkID: params[1],
+			Key:       params[2],
+			NodeName:  entry.node,
+			LTime:     entry.ltime,
+			Value:     entry.value,
+		})
+		return false
+	})
+	nDB.RUnlock()
+
+	buf, err := json.Marshal(&s)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %v", err)
+	}
+
+	nDB.snapshotLock.Lock()
+	defer nDB.snapshotLock.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %v", err)
+	}
+
+	// Write to a temporary file first so that a crash does not leave a
+	// truncated snapshot behind
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+
+	return nil
+}
+
+// loadSnapshot restores the state saved in the snapshot file. The restored
+// network attachments and table entries are provisional: they are expired
+// if the peers do not confirm them within reconcileTimeout.
+func (nDB *NetworkDB) loadSnapshot() error {
+	path := nDB.config.SnapshotPath
+	if path == "" {
+		return nil
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read snapshot: %v", err)
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %v", err)
+	}
+
+	if s.NodeName != nDB.config.NodeName {
+		logrus.Infof("Ignoring networkdb snapshot of node %s as this node is %s", s.NodeName, nDB.config.NodeName)
+		return nil
+	}
+
+	// Events of this node must be newer than the ones it sent before the
+	// restart
+	nDB.networkClock.Witness(s.NetworkClock)
+	nDB.tableClock.Witness(s.TableClock)
+
+	nDB.Lock()
+	defer nDB.Unlock()
+
+	for _, sn := range s.Networks {
+		if sn.NodeName == nDB.config.NodeName {
+			continue
+		}
+
+		nodeNetworks, ok := nDB.networks[sn.NodeName]
+		if !ok {
+			nodeNetworks = make(map[string]*network)
+			nDB.networks[sn.NodeName] = nodeNetworks
+		}
+		nodeNetworks[sn.NetworkID] = &network{
+			id:          sn.NetworkID,
+			ltime:       sn.LTime,
+			provisional: true,
+		}
+		nDB.addNetworkNode(sn.NetworkID, sn.NodeName)
+	}
+
+	for _, se := range s.Entries {
+		if se.NodeName == nDB.config.NodeName {
+			continue
+		}
+
+		entry := &entry{
+			ltime:       se.LTime,
+			node:        se.NodeName,
+			value:       se.Value,
+			provisional: true,
+		}
+		nDB.indexes[byTable].Insert(fmt.Sprintf("/%s/%s/%s", se.TableName, se.NetworkID, se.Key), entry)
+		nDB.indexes[byNetwork].Insert(fmt.Sprintf("/%s/%s/%s", se.NetworkID, se.TableName, se.Key), entry)
+	}
+
+	nDB.reconcileDeadline = time.Now().Add(reconcileTimeout)
+	logrus.Infof("Restored %d network attachments and %d table entries from networkdb snapshot", len(s.Networks), len(s.Entries))
+	return nil
+}
+
+func (nDB *NetworkDB) snapshotState() {
+	if err := nDB.saveSnapshot(); err != nil {
+		logrus.Errorf("Could not save networkdb snapshot: %v", err)
+	}
+}
+
+// expireProvisional expires the state restored from the snapshot which was
+// not confirmed by the peers in time. The network attachments are marked
+// as leaving and the table entries as deleting, so that they are removed by
+// reapNetworks and reapTableEntries.
+func (nDB *NetworkDB) expireProvisional() {
+	now := time.Now()
+
+	nDB.Lock()
+	defer nDB.Unlock()
+
+	if nDB.reconcileDeadline.IsZero() || now.Before(nDB.reconcileDeadline) {
+		return
+	}
+	nDB.reconcileDeadline = time.Time{}
+
+	for _, nn := range nDB.networks {
+		for _, n := range nn {
+			if n.provisional {
+				n.provisional = false
+				n.leaving = true
+				n.leaveTime = now
+			}
+		}
+	}
+
+	var (
+		paths   []string
+		entries []*entry
+	)
+	nDB.indexes[byTable].Walk(func(path string, v interface{}) bool {
+		entry, ok := v.(*entry)
+		if ok && entry.provisional {
+			paths = append(paths, path)
+			entries = append(entries, entry)
+		}
+		return false
+	})
+
+	for i, path := range paths {
+		params := strings.Split(path[1:], "/")
+		tname := params[0]
+		nid := params[1]
+		key := params[2]
+
+		entry := &entry{
+			ltime:      entries[i].ltime,
+			node:       entries[i].node,
+			value:      entries[i].value,
+			deleting:   true,
+			deleteTime: now,
+		}
+
+		nDB.indexes[byTable].Insert(fmt.Sprintf("/%s/%s/%s", tname, nid, key), entry)
+		nDB.indexes[byNetwork].Insert(fmt.Sprintf("/%s/%s/%s", nid, tname, key), entry)
+
+		nDB.broadcaster.Write(makeEvent(opDelete, tname, nid, key, entry.value))
+	}
+
+	if len(paths) > 0 {
+		logrus.Infof("Expired %d table entries restored from networkdb snapshot", len(paths))
+	}
+}
@@ -0,0 +1,52 @@
+package networkdb
+
+import (
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// Cluster is the membership and messaging layer NetworkDB runs on. It
+// delivers the cluster events and the messages to the delegates set in the
+// memberlist configuration it is created with.
+type Cluster interface {
+	// Join joins the cluster through the passed existing members and
+	// returns the number of members contacted.
+	Join(existing []string) (int, error)
+
+	// Leave broadcasts the leave of this node to the cluster.
+	Leave(timeout time.Duration) error
+
+	// Shutdown stops the participation of this node in the cluster.
+	Shutdown() error
+
+	// SendToUDP sends a best effort message to a node.
+	SendToUDP(to *memberlist.Node, msg []byte) error
+
+	// SendToTCP sends a reliable message to a node.
+	SendToTCP(to *memberlist.Node, msg []byte) error
+}
+
+// Transport creates the cluster layer of the NetworkDB instances.
+type Transport interface {
+	Create(conf *memberlist.Config) (Cluster, error)
+}
+
+// Clock is implemented by the transports which run the periodic tasks of the
+// NetworkDB instances on their own time, such as SimNetwork. NetworkDB uses
+// tickers when the transport does not implement it.
+type Clock interface {
+	// Every runs fn every interval until stop is closed.
+	Every(interval time.Duration, stop <-chan struct{}, fn func())
+}
+
+// memberlistTransport runs the NetworkDB instances over memberlist
+type memberlistTransport struct{}
+
+func (memberlistTransport) Create(conf *memberlist.Config) (Cluster, error) {
+	mlist, err := memberlist.Create(conf)
+	if err != nil {
+		return nil, err
+	}
+	return mlist, nil
+}
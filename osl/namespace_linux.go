@@ -186,6 +186,34 @@ func GenerateKey(containerID string) string {
 	return basePath() + "/" + containerID[:maxLen]
 }
 
+// NamespaceKeys returns the keys of all the namespace files present under
+// the sandbox base path, whether or not they are owned by a sandbox.
+func NamespaceKeys() ([]string, error) {
+	dir, err := ioutil.ReadDir(basePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(dir))
+	for _, v := range dir {
+		keys = append(keys, filepath.Join(basePath(), v.Name()))
+	}
+
+	return keys, nil
+}
+
+// RemoveNamespace unmounts and removes the namespace file for the passed
+// key. It is meant to clean up namespaces which are not owned by any
+// sandbox.
+func RemoveNamespace(key string) error {
+	removeFromGarbagePaths(key)
+	unmountNamespaceFile(key)
+	return os.Remove(key)
+}
+
 // NewSandbox provides a new sandbox instance created in an os specific way
 // provided a key which uniquely identifies the sandbox
 func NewSandbox(key string, osCreate, isRestore bool) (Sandbox, error) {
@@ -0,0 +1,46 @@
+// Package pluginutils provides helpers shared by the remote driver and IPAM
+// plugin clients
+package pluginutils
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
+)
+
+// CallContext runs the plugin call fn and waits for it to complete or for
+// ctx to be done. The plugin client cannot abort a request in flight, so an
+// abandoned call is left to complete in the background.
+func CallContext(ctx context.Context, method string, fn func() error) error {
+	// A context which can never be done, such as context.Background
+	if ctx == nil || ctx.Done() == nil {
+		return fn()
+	}
+	if err := ctx.Err(); err != nil {
+		return abandonedError(method, err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		log.Warnf("Abandoning %s plugin call: %v", method, ctx.Err())
+		return abandonedError(method, ctx.Err())
+	}
+}
+
+// abandonedError returns the error of a plugin call abandoned because of
+// the context error err
+func abandonedError(method string, err error) error {
+	if err == context.DeadlineExceeded {
+		return types.TimeoutErrorf("remote: %s timed out", method)
+	}
+	return fmt.Errorf("remote: %s: %v", method, err)
+}
@@ -0,0 +1,33 @@
+package pluginutils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
+)
+
+func TestCallContext(t *testing.T) {
+	callErr := errors.New("call failed")
+	if err := CallContext(context.Background(), "Test.Call", func() error { return callErr }); err != callErr {
+		t.Fatalf("Expected the call error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	if err := CallContext(ctx, "Test.Call", func() error { called = true; return nil }); err == nil || called {
+		t.Fatalf("Expected the call to be skipped with a canceled context, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	block := make(chan struct{})
+	defer close(block)
+	err := CallContext(ctx, "Test.Call", func() error { <-block; return nil })
+	if _, ok := err.(types.TimeoutError); !ok {
+		t.Fatalf("Expected a timeout error, got %v", err)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net"
 	"os"
 	"sync"
+
+	"github.com/docker/libnetwork/metrics"
 )
 
 const (
@@ -27,6 +29,11 @@ var (
 	once               sync.Once
 	instance           *PortAllocator
 	createInstance     = func() { instance = newInstance() }
+
+	allocatedPorts = metrics.NewGaugeVec("libnetwork_portallocator_allocated_ports",
+		"Number of allocated host ports, by protocol.", "proto")
+	exhaustedRequests = metrics.NewCounterVec("libnetwork_portallocator_exhausted_total",
+		"Number of port requests which failed because all the ports in the range were allocated, by protocol.", "proto")
 )
 
 // ErrPortAlreadyAllocated is the returned error information when a requested port is already being used
@@ -163,6 +170,7 @@ func (p *PortAllocator) RequestPortInRange(ip net.IP, proto string, portStart, p
 	if portStart > 0 && portStart == portEnd {
 		if _, ok := mapping.p[portStart]; !ok {
 			mapping.p[portStart] = struct{}{}
+			allocatedPorts.With(proto).Inc()
 			return portStart, nil
 		}
 		return 0, newErrPortAlreadyAllocated(ipstr, portStart)
@@ -170,8 +178,12 @@ func (p *PortAllocator) RequestPortInRange(ip net.IP, proto string, portStart, p
 
 	port, err := mapping.findPort(portStart, portEnd)
 	if err != nil {
+		if err == ErrAllPortsAllocated {
+			exhaustedRequests.With(proto).Inc()
+		}
 		return 0, err
 	}
+	allocatedPorts.With(proto).Inc()
 	return port, nil
 }
 
@@ -187,7 +199,10 @@ func (p *PortAllocator) ReleasePort(ip net.IP, proto string, port int) error {
 	if !ok {
 		return nil
 	}
-	delete(protomap[proto].p, port)
+	if _, ok := protomap[proto].p[port]; ok {
+		delete(protomap[proto].p, port)
+		allocatedPorts.With(proto).Dec()
+	}
 	return nil
 }
 
@@ -207,6 +222,9 @@ func (p *PortAllocator) newPortMap() *portMap {
 func (p *PortAllocator) ReleaseAll() error {
 	p.mutex.Lock()
 	p.ipMap = ipMapping{}
+	for _, proto := range []string{"tcp", "udp"} {
+		allocatedPorts.With(proto).Set(0)
+	}
 	p.mutex.Unlock()
 	return nil
 }
@@ -39,6 +39,10 @@ type PortMapper struct {
 	lock            sync.Mutex
 
 	Allocator *portallocator.PortAllocator
+
+	// KeepProxies leaves the userland proxies running when the daemon
+	// exits, so that they are adopted when the mappings are restored
+	KeepProxies bool
 }
 
 // New returns a new instance of PortMapper
@@ -67,6 +71,35 @@ func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int, usePr
 
 // MapRange maps the specified container transport address to the host's network address and transport port range
 func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy bool) (host net.Addr, err error) {
+	return pm.mapRange(container, hostIP, hostPortStart, hostPortEnd, useProxy, 0)
+}
+
+// Restore maps again the specified container transport address to the host's
+// network address and transport port it was mapped to before a restart. The
+// userland proxy process identified by proxyPid is adopted if it is still
+// serving the mapping, otherwise a new proxy is started.
+func (pm *PortMapper) Restore(container net.Addr, hostIP net.IP, hostPort int, useProxy bool, proxyPid int) error {
+	_, err := pm.mapRange(container, hostIP, hostPort, hostPort, useProxy, proxyPid)
+	return err
+}
+
+// ProxyPid returns the pid of the userland proxy process serving the
+// specified host transport address, or 0 if there is no such process
+func (pm *PortMapper) ProxyPid(host net.Addr) int {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	m, ok := pm.currentMappings[getKey(host)]
+	if !ok {
+		return 0
+	}
+	if p, ok := m.userlandProxy.(proxyProcess); ok {
+		return p.pid()
+	}
+	return 0
+}
+
+func (pm *PortMapper) mapRange(container net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy bool, proxyPid int) (host net.Addr, err error) {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 
@@ -89,13 +122,9 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 			container: container,
 		}
 
-		if useProxy {
-			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			m.userlandProxy = newDummyProxy(proto, hostIP, allocatedHostPort)
+		m.userlandProxy, err = newMappingProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port, useProxy, pm.KeepProxies, proxyPid)
+		if err != nil {
+			return nil, err
 		}
 	case *net.UDPAddr:
 		proto = "udp"
@@ -109,13 +138,9 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 			container: container,
 		}
 
-		if useProxy {
-			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			m.userlandProxy = newDummyProxy(proto, hostIP, allocatedHostPort)
+		m.userlandProxy, err = newMappingProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port, useProxy, pm.KeepProxies, proxyPid)
+		if err != nil {
+			return nil, err
 		}
 	default:
 		return nil, ErrUnknownBackendAddressType
@@ -2,6 +2,7 @@ package portmapper
 
 import (
 	"net"
+	"os"
 	"strings"
 	"testing"
 
@@ -268,3 +269,51 @@ func TestMapUDPDummyListen(t *testing.T) {
 		}
 	}
 }
+
+func TestRestore(t *testing.T) {
+	pm := New()
+	hostIP := net.ParseIP("192.168.0.1")
+	hostAddr := &net.TCPAddr{IP: hostIP, Port: 8080}
+	srcAddr := &net.TCPAddr{Port: 1080, IP: net.ParseIP("172.16.0.1")}
+
+	// The pid does not belong to a userland proxy, a new one is started
+	if err := pm.Restore(srcAddr, hostIP, 8080, true, os.Getpid()); err != nil {
+		t.Fatalf("Failed to restore mapping: %v", err)
+	}
+	defer pm.Unmap(hostAddr)
+
+	if _, ok := pm.currentMappings[getKey(hostAddr)].userlandProxy.(*mockProxyCommand); !ok {
+		t.Fatal("Expected a new userland proxy for the restored mapping")
+	}
+
+	if _, err := pm.Map(srcAddr, hostIP, 8080, true); err == nil {
+		t.Fatal("Restored port should be reserved")
+	}
+
+	if pid := pm.ProxyPid(hostAddr); pid != 0 {
+		t.Fatalf("Unexpected proxy pid %d", pid)
+	}
+}
+
+func TestIsProxyCmdline(t *testing.T) {
+	args := proxyArgs("tcp", net.ParseIP("0.0.0.0"), 8080, net.ParseIP("172.17.0.2"), 80)
+
+	cmdline := []byte("/usr/bin/docker-proxy\x00" + strings.Join(args, "\x00") + "\x00")
+	if !isProxyCmdline(cmdline, args) {
+		t.Fatalf("Expected %q to match the proxy arguments", cmdline)
+	}
+
+	other := proxyArgs("tcp", net.ParseIP("0.0.0.0"), 8081, net.ParseIP("172.17.0.2"), 80)
+	if isProxyCmdline(cmdline, other) {
+		t.Fatalf("Expected %q not to match the proxy arguments of another mapping", cmdline)
+	}
+
+	cmdline = []byte("/bin/sleep\x00" + strings.Join(args, "\x00") + "\x00")
+	if isProxyCmdline(cmdline, args) {
+		t.Fatalf("Expected %q not to match the proxy command", cmdline)
+	}
+
+	if p := adoptProxy(os.Getpid(), args); p != nil {
+		t.Fatal("Expected the test process not to be adopted")
+	}
+}
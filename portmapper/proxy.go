@@ -7,7 +7,9 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -19,6 +21,12 @@ type userlandProxy interface {
 	Stop() error
 }
 
+// proxyProcess is implemented by the userland proxies running as a
+// separate process
+type proxyProcess interface {
+	pid() int
+}
+
 // proxyCommand wraps an exec.Cmd to run the userland TCP and UDP
 // proxies as separate processes.
 type proxyCommand struct {
@@ -32,14 +40,7 @@ func newProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.
 		return nil, err
 	}
 
-	args := []string{
-		cmd,
-		"-proto", proto,
-		"-host-ip", hostIP.String(),
-		"-host-port", strconv.Itoa(hostPort),
-		"-container-ip", containerIP.String(),
-		"-container-port", strconv.Itoa(containerPort),
-	}
+	args := append([]string{cmd}, proxyArgs(proto, hostIP, hostPort, containerIP, containerPort)...)
 
 	return &proxyCommand{
 		cmd: &exec.Cmd{
@@ -52,6 +53,47 @@ func newProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.
 	}, nil
 }
 
+// detach unties the proxy from the daemon process, so that it keeps serving
+// the mapping across a daemon restart and is adopted when the mapping is
+// restored. The proxies of the mappings which are not restored are stopped
+// with StopProxy.
+func (p *proxyCommand) detach() {
+	p.cmd.SysProcAttr = nil
+}
+
+// proxyArgs returns the userland proxy command line arguments for a mapping
+func proxyArgs(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) []string {
+	return []string{
+		"-proto", proto,
+		"-host-ip", hostIP.String(),
+		"-host-port", strconv.Itoa(hostPort),
+		"-container-ip", containerIP.String(),
+		"-container-port", strconv.Itoa(containerPort),
+	}
+}
+
+// newMappingProxy returns the userland proxy for a new mapping. A running
+// proxy process is adopted when proxyPid refers to a proxy serving the same
+// mapping. A new proxy process outlives the daemon only if keep is set.
+func newMappingProxy(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, useProxy, keep bool, proxyPid int) (userlandProxy, error) {
+	if !useProxy {
+		return newDummyProxy(proto, hostIP, hostPort), nil
+	}
+	if proxyPid != 0 {
+		if p := adoptProxy(proxyPid, proxyArgs(proto, hostIP, hostPort, containerIP, containerPort)); p != nil {
+			return p, nil
+		}
+	}
+	p, err := newProxy(proto, hostIP, hostPort, containerIP, containerPort)
+	if err != nil {
+		return nil, err
+	}
+	if pc, ok := p.(*proxyCommand); ok && keep {
+		pc.detach()
+	}
+	return p, nil
+}
+
 func (p *proxyCommand) Start() error {
 	r, w, err := os.Pipe()
 	if err != nil {
@@ -100,6 +142,87 @@ func (p *proxyCommand) Stop() error {
 	return nil
 }
 
+func (p *proxyCommand) pid() int {
+	if p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
+// adoptedProxy is a userland proxy process started before a restart which
+// is still serving its mapping
+type adoptedProxy struct {
+	process *os.Process
+}
+
+// adoptProxy returns the proxy process with the specified pid if it runs the
+// userland proxy with the specified arguments, nil otherwise
+func adoptProxy(pid int, args []string) userlandProxy {
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || !isProxyCmdline(cmdline, args) {
+		return nil
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return nil
+	}
+	return &adoptedProxy{process: process}
+}
+
+// StopProxy stops the userland proxy process identified by proxyPid if it
+// still serves the mapping of the container transport address to the host
+// address and port. It cleans up the proxies which were running before a
+// restart and whose mapping is not restored.
+func StopProxy(container net.Addr, hostIP net.IP, hostPort int, proxyPid int) error {
+	if proxyPid == 0 {
+		return nil
+	}
+
+	var args []string
+	switch c := container.(type) {
+	case *net.TCPAddr:
+		args = proxyArgs("tcp", hostIP, hostPort, c.IP, c.Port)
+	case *net.UDPAddr:
+		args = proxyArgs("udp", hostIP, hostPort, c.IP, c.Port)
+	default:
+		return ErrUnknownBackendAddressType
+	}
+
+	if p := adoptProxy(proxyPid, args); p != nil {
+		return p.Stop()
+	}
+	return nil
+}
+
+// isProxyCmdline tells whether the NUL separated command line runs the
+// userland proxy with the specified arguments
+func isProxyCmdline(cmdline []byte, args []string) bool {
+	fields := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	if len(fields) != len(args)+1 || filepath.Base(fields[0]) != userlandProxyCommandName {
+		return false
+	}
+	for i, a := range args {
+		if fields[i+1] != a {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *adoptedProxy) Start() error {
+	return nil
+}
+
+// Stop signals the process, which is not a child of this one and cannot be
+// waited for
+func (p *adoptedProxy) Stop() error {
+	return p.process.Signal(os.Interrupt)
+}
+
+func (p *adoptedProxy) pid() int {
+	return p.process.Pid
+}
+
 // dummyProxy just listen on some port, it is needed to prevent accidental
 // port allocations on bound port, because without userland proxy we using
 // iptables rules and not net.Listen
@@ -0,0 +1,173 @@
+package portmapper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/reexec"
+)
+
+const proxyParentName = "portmapper-proxy-parent"
+
+func init() {
+	// The test binary is copied as the userland proxy, and re-executed
+	// as the daemon starting it
+	if filepath.Base(os.Args[0]) == userlandProxyCommandName {
+		fakeProxyMain()
+	}
+	reexec.Register(proxyParentName, proxyParentMain)
+	reexec.Init()
+}
+
+// fakeProxyMain reports a successful start and serves until interrupted
+func fakeProxyMain() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+
+	f := os.NewFile(3, "signal-parent")
+	fmt.Fprint(f, "0\n")
+	f.Close()
+
+	<-ch
+	os.Exit(0)
+}
+
+// proxyParentMain starts a userland proxy, kept running after the exit
+// if the first argument is "keep", prints its pid and exits
+func proxyParentMain() {
+	keep := len(os.Args) > 1 && os.Args[1] == "keep"
+	newProxy = newProxyCommand
+	p, err := newMappingProxy("tcp", net.ParseIP("127.0.0.1"), 18080, net.ParseIP("127.0.0.2"), 80, true, keep, 0)
+	if err == nil {
+		err = p.Start()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(p.(proxyProcess).pid())
+	os.Exit(0)
+}
+
+// processRunning tells whether the process exists and is not a zombie
+func processRunning(pid int) bool {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// startDaemonProxy copies the test binary as the userland proxy in dir and
+// returns the pid of the proxy started by a daemon process which exited
+func startDaemonProxy(t *testing.T, dir string, keep bool) int {
+	self, err := os.Open("/proc/self/exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer self.Close()
+	proxy, err := os.OpenFile(filepath.Join(dir, userlandProxyCommandName), os.O_CREATE|os.O_WRONLY, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(proxy, self)
+	proxy.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{proxyParentName}
+	if keep {
+		args = append(args, "keep")
+	}
+	parent := reexec.Command(args...)
+	parent.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"))
+	parent.Stderr = os.Stderr
+	out, err := parent.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parent.Start(); err != nil {
+		t.Fatal(err)
+	}
+	line, _ := bufio.NewReader(out).ReadString('\n')
+	if err := parent.Wait(); err != nil {
+		t.Fatalf("Daemon process failed to start the proxy: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("Invalid proxy pid %q: %v", line, err)
+	}
+	return pid
+}
+
+func TestProxyDiesWithDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "portmapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pid := startDaemonProxy(t, dir, false)
+	defer syscall.Kill(pid, syscall.SIGKILL)
+
+	for i := 0; i < 50 && processRunning(pid); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if processRunning(pid) {
+		t.Fatal("Expected the proxy to be stopped along with the process which started it")
+	}
+}
+
+func TestProxyOutlivesDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "portmapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pid := startDaemonProxy(t, dir, true)
+	defer syscall.Kill(pid, syscall.SIGKILL)
+
+	// Give a death signal the time to be delivered
+	time.Sleep(200 * time.Millisecond)
+	if !processRunning(pid) {
+		t.Fatal("Expected the proxy to outlive the process which started it")
+	}
+
+	container := &net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 80}
+	if p := adoptProxy(pid, proxyArgs("tcp", net.ParseIP("127.0.0.1"), 18080, container.IP, container.Port)); p == nil {
+		t.Fatal("Expected the proxy to be adopted")
+	}
+
+	// A proxy serving another mapping is left alone
+	if err := StopProxy(container, net.ParseIP("127.0.0.1"), 18081, pid); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !processRunning(pid) {
+		t.Fatal("Expected the proxy of another mapping not to be stopped")
+	}
+
+	if err := StopProxy(container, net.ParseIP("127.0.0.1"), 18080, pid); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50 && processRunning(pid); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if processRunning(pid) {
+		t.Fatal("Expected the proxy to be stopped")
+	}
+}
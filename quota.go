@@ -0,0 +1,176 @@
+package libnetwork
+
+import (
+	"math"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// NetworkQuota limits the resources handed out by a network. A zero value
+// for any of the fields means no limit.
+type NetworkQuota struct {
+	// MaxEndpoints is the maximum number of endpoints on the network
+	MaxEndpoints uint64
+	// MaxEndpointsPerSandbox is the maximum number of endpoints of the
+	// network a single sandbox can join
+	MaxEndpointsPerSandbox uint64
+	// MaxAddresses is the maximum number of IPv4 and IPv6 addresses,
+	// including the secondary ones, reserved by the network endpoints
+	MaxAddresses uint64
+}
+
+// QuotaUsage reports the resources of a network counted against its quota
+type QuotaUsage struct {
+	Endpoints uint64
+	Addresses uint64
+}
+
+// NetworkOptionQuota function returns an option setter for the endpoint and
+// address quota of the network
+func NetworkOptionQuota(quota NetworkQuota) NetworkOption {
+	return func(n *network) {
+		n.quota = quota
+	}
+}
+
+// check returns a ForbiddenError if the passed endpoint and address counts
+// exceed the quota
+func (q NetworkQuota) check(endpoints, addresses uint64) error {
+	if q.MaxEndpoints != 0 && endpoints > q.MaxEndpoints {
+		return types.ForbiddenErrorf("network endpoint quota exceeded (%d)", q.MaxEndpoints)
+	}
+	if q.MaxAddresses != 0 && addresses > q.MaxAddresses {
+		return types.ForbiddenErrorf("network address quota exceeded (%d)", q.MaxAddresses)
+	}
+	return nil
+}
+
+// validate returns a BadRequestError if a limit of the quota is out of
+// range, as a negative limit converted to an unsigned one
+func (q NetworkQuota) validate() error {
+	for _, max := range []uint64{q.MaxEndpoints, q.MaxEndpointsPerSandbox, q.MaxAddresses} {
+		if max > math.MaxInt64 {
+			return types.BadRequestErrorf("invalid network quota limit %d", int64(max))
+		}
+	}
+	return nil
+}
+
+func (n *network) Quota() NetworkQuota {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.quota
+}
+
+func (n *network) QuotaUsage() QuotaUsage {
+	ec := n.getEpCnt()
+	if ec == nil {
+		return QuotaUsage{}
+	}
+
+	ec.Lock()
+	defer ec.Unlock()
+
+	return QuotaUsage{Endpoints: ec.Count, Addresses: ec.Addresses}
+}
+
+// checkQuota verifies ahead of any allocation that the endpoint fits in the
+// network quota. The quota is strictly enforced once the endpoint is counted.
+func (n *network) checkQuota(ep *endpoint) error {
+	quota := n.Quota()
+	usage := n.QuotaUsage()
+	return quota.check(usage.Endpoints+1, usage.Addresses+ep.requestedAddressCount())
+}
+
+// checkQuotaUpdate verifies the quota the network is updated to: it must be
+// valid as at creation, and not below the resources the network already
+// hands out
+func (n *network) checkQuotaUpdate(quota NetworkQuota) error {
+	if err := quota.validate(); err != nil {
+		return err
+	}
+
+	usage := n.QuotaUsage()
+	if err := quota.check(usage.Endpoints, usage.Addresses); err != nil {
+		return types.ForbiddenErrorf("network quota below the current usage of network %s: %v", n.Name(), err)
+	}
+
+	max := quota.MaxEndpointsPerSandbox
+	if max == 0 {
+		return nil
+	}
+	c := n.getController()
+	c.Lock()
+	sandboxes := make([]*sandbox, 0, len(c.sandboxes))
+	for _, sb := range c.sandboxes {
+		sandboxes = append(sandboxes, sb)
+	}
+	c.Unlock()
+	for _, sb := range sandboxes {
+		if cnt := n.sandboxEndpointCount(sb); cnt > max {
+			return types.ForbiddenErrorf("network endpoint quota per sandbox (%d) below the %d endpoints sandbox %s joined on network %s", max, cnt, sb.ContainerID(), n.Name())
+		}
+	}
+	return nil
+}
+
+// checkSandboxQuota returns a ForbiddenError if the sandbox already joined
+// the maximum number of endpoints of the network
+func (n *network) checkSandboxQuota(sb *sandbox) error {
+	max := n.Quota().MaxEndpointsPerSandbox
+	if max == 0 {
+		return nil
+	}
+
+	if cnt := n.sandboxEndpointCount(sb); cnt >= max {
+		return types.ForbiddenErrorf("sandbox %s reached the endpoint quota (%d) of network %s", sb.ContainerID(), max, n.Name())
+	}
+	return nil
+}
+
+// sandboxEndpointCount returns the number of endpoints of the network the
+// sandbox joined
+func (n *network) sandboxEndpointCount(sb *sandbox) uint64 {
+	var cnt uint64
+	for _, ep := range sb.getConnectedEndpoints() {
+		if ep.getNetwork().ID() == n.ID() {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// requestedAddressCount returns the number of addresses the endpoint will
+// reserve once created
+func (ep *endpoint) requestedAddressCount() uint64 {
+	n := ep.getNetwork()
+	if n.hasSpecialDriver() {
+		return 0
+	}
+
+	cnt := 1 + uint64(ep.secondaryCnt)
+	if n.enableIPv6 && len(n.getIPInfo(6)) > 0 {
+		cnt += 1 + uint64(ep.secondaryCntV6)
+	}
+	return cnt
+}
+
+// addressCount returns the number of IPAM addresses held by the endpoint
+func (ep *endpoint) addressCount() uint64 {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.iface == nil {
+		return 0
+	}
+
+	cnt := uint64(len(ep.iface.addrs) + len(ep.iface.addrsv6))
+	if ep.iface.addr != nil {
+		cnt++
+	}
+	if ep.iface.addrv6 != nil && ep.iface.addrv6.IP.IsGlobalUnicast() {
+		cnt++
+	}
+	return cnt
+}
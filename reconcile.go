@@ -0,0 +1,227 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/datastore"
+)
+
+// DriftKind identifies a class of inconsistency between the state recorded
+// in the datastore and the state programmed in the kernel.
+type DriftKind string
+
+const (
+	// DriftStaleNetwork is a network left marked for deletion in the store
+	DriftStaleNetwork DriftKind = "stale-network"
+	// DriftEndpointCount is a network whose endpoint count does not match
+	// the endpoints found in the store
+	DriftEndpointCount DriftKind = "endpoint-count"
+	// DriftStaleEndpoint is an endpoint attached to a sandbox which no
+	// longer exists
+	DriftStaleEndpoint DriftKind = "stale-endpoint"
+	// DriftStaleSandbox is a sandbox present in the store but not known
+	// to the controller
+	DriftStaleSandbox DriftKind = "stale-sandbox"
+	// DriftMissingNamespace is a sandbox whose network namespace is gone
+	DriftMissingNamespace DriftKind = "missing-namespace"
+	// DriftOrphanNamespace is a network namespace not owned by any sandbox
+	DriftOrphanNamespace DriftKind = "orphan-namespace"
+	// DriftDanglingVeth is a veth pair left behind in the host namespace
+	DriftDanglingVeth DriftKind = "dangling-veth"
+	// DriftStaleIptablesRule is a rule in a DOCKER chain pointing to an
+	// address not owned by any endpoint
+	DriftStaleIptablesRule DriftKind = "stale-iptables-rule"
+	// DriftStaleIPVSService is an ipvs service not backing any service
+	// binding
+	DriftStaleIPVSService DriftKind = "stale-ipvs-service"
+)
+
+// Drift describes a single inconsistency found by Reconcile
+type Drift struct {
+	Kind     DriftKind
+	Resource string
+	Detail   string
+	Repaired bool
+	Error    string
+}
+
+// ReconcileReport is the result of a Reconcile run
+type ReconcileReport struct {
+	DryRun bool
+	Drifts []*Drift
+}
+
+// reconcileState is the expected state derived from the datastore and the
+// controller, against which the kernel state is compared.
+type reconcileState struct {
+	sandboxKeys map[string]*sandbox
+	addresses   map[string]bool
+	ifaceNames  map[string]bool
+	fwMarks     map[uint32]bool
+}
+
+func (r *ReconcileReport) add(kind DriftKind, resource, detail string, repair func() error) {
+	d := &Drift{Kind: kind, Resource: resource, Detail: detail}
+	r.Drifts = append(r.Drifts, d)
+
+	if r.DryRun || repair == nil {
+		return
+	}
+
+	if err := repair(); err != nil {
+		d.Error = err.Error()
+		log.Warnf("Failed to repair %s %s: %v", kind, resource, err)
+		return
+	}
+	d.Repaired = true
+}
+
+// Reconcile compares the networks, endpoints, sandboxes and service bindings
+// recorded in the datastore with the namespaces, interfaces, iptables rules
+// and ipvs services present in the kernel. Each difference is reported and,
+// unless dryRun is set, repaired.
+func (c *controller) Reconcile(dryRun bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{DryRun: dryRun}
+
+	networks, err := c.getNetworksFromStore()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve networks from store: %v", err)
+	}
+
+	st := &reconcileState{
+		sandboxKeys: make(map[string]*sandbox),
+		addresses:   make(map[string]bool),
+		ifaceNames:  make(map[string]bool),
+		fwMarks:     make(map[uint32]bool),
+	}
+
+	c.Lock()
+	for _, sb := range c.sandboxes {
+		st.sandboxKeys[sb.Key()] = sb
+	}
+	for _, s := range c.serviceBindings {
+		s.Lock()
+		for _, lb := range s.loadBalancers {
+			st.fwMarks[lb.fwMark] = true
+		}
+		s.Unlock()
+	}
+	c.Unlock()
+
+	for _, n := range networks {
+		if n.inDelete {
+			n := n
+			report.add(DriftStaleNetwork, n.ID(), fmt.Sprintf("network %s is marked for deletion", n.Name()),
+				func() error { return n.delete(true) })
+			continue
+		}
+		c.reconcileEndpoints(n, st, report)
+	}
+
+	c.reconcileSandboxStore(report)
+
+	c.reconcileOS(st, report)
+
+	return report, nil
+}
+
+// sandboxKeyInUse returns whether the namespace key belongs to a sandbox of
+// the controller or to one being created
+func (c *controller) sandboxKeyInUse(key string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.newSandboxKeys[key]; ok {
+		return true
+	}
+	for _, sb := range c.sandboxes {
+		if sb.Key() == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *controller) reconcileEndpoints(n *network, st *reconcileState, report *ReconcileReport) {
+	epl, err := n.getEndpointsFromStore()
+	if err != nil {
+		log.Warnf("Could not get list of endpoints in network %s during reconcile: %v", n.Name(), err)
+		return
+	}
+
+	// The address count is repaired along with the endpoint count, so
+	// that the quota usage matches the endpoints found in the store.
+	var addrs uint64
+	for _, ep := range epl {
+		addrs += ep.addressCount()
+	}
+	if ec := n.getEpCnt(); ec != nil {
+		if usage := n.QuotaUsage(); usage.Endpoints != uint64(len(epl)) || usage.Addresses != addrs {
+			report.add(DriftEndpointCount, n.ID(),
+				fmt.Sprintf("network %s records %d endpoints and %d addresses, found %d and %d",
+					n.Name(), usage.Endpoints, usage.Addresses, len(epl), addrs),
+				func() error { return ec.setCounts(uint64(len(epl)), addrs) })
+		}
+	}
+
+	// Only endpoints of local scope networks are bound to sandboxes
+	// of this controller.
+	local := n.DataScope() == datastore.LocalScope
+
+	for _, ep := range epl {
+		ep.Lock()
+		sid := ep.sandboxID
+		iface := ep.iface
+		ep.Unlock()
+
+		if local && sid != "" {
+			if _, err := c.SandboxByID(sid); err != nil {
+				ep := ep
+				report.add(DriftStaleEndpoint, ep.ID(),
+					fmt.Sprintf("endpoint %s on network %s is attached to missing sandbox %s", ep.Name(), n.Name(), sid),
+					func() error { return ep.Delete(true) })
+				continue
+			}
+		}
+
+		if iface == nil {
+			continue
+		}
+		addrs := append([]*net.IPNet{iface.addr, iface.addrv6}, iface.addrs...)
+		addrs = append(addrs, iface.addrsv6...)
+		for _, a := range addrs {
+			if a != nil {
+				st.addresses[a.IP.String()] = true
+			}
+		}
+		if iface.srcName != "" {
+			st.ifaceNames[iface.srcName] = true
+		}
+	}
+}
+
+func (c *controller) reconcileSandboxStore(report *ReconcileReport) {
+	store := c.getStore(datastore.LocalScope)
+	if store == nil {
+		return
+	}
+
+	kvol, err := store.List(datastore.Key(sandboxPrefix), &sbState{c: c})
+	if err != nil {
+		if err != datastore.ErrKeyNotFound {
+			log.Warnf("Could not get list of sandboxes from store during reconcile: %v", err)
+		}
+		return
+	}
+
+	for _, kvo := range kvol {
+		sbs := kvo.(*sbState)
+		if _, err := c.SandboxByID(sbs.ID); err == nil {
+			continue
+		}
+		report.add(DriftStaleSandbox, sbs.ID, fmt.Sprintf("sandbox of container %s is not known to the controller", sbs.Cid),
+			func() error { return c.deleteFromStore(sbs) })
+	}
+}
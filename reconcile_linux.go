@@ -0,0 +1,206 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/ipvs"
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/osl"
+	"github.com/vishvananda/netlink"
+)
+
+// dockerChain is the chain programmed by the bridge driver for port
+// mappings and inter container links.
+const dockerChain = "DOCKER"
+
+// vethName matches the names the bridge and overlay drivers generate for
+// the veth interfaces they create.
+var vethName = regexp.MustCompile(`^veth[0-9a-f]{7}$`)
+
+func (c *controller) reconcileOS(st *reconcileState, report *ReconcileReport) {
+	c.reconcileNamespaces(st, report)
+	reconcileVeths(st, report)
+	for _, table := range []iptables.Table{iptables.Nat, iptables.Filter} {
+		reconcileIptables(table, st, report)
+	}
+	reconcileIPVS(st, report)
+}
+
+func (c *controller) reconcileNamespaces(st *reconcileState, report *ReconcileReport) {
+	for key, sb := range st.sandboxKeys {
+		if sb.config.useDefaultSandBox || sb.osSbox == nil {
+			continue
+		}
+		if _, err := os.Stat(key); os.IsNotExist(err) {
+			report.add(DriftMissingNamespace, sb.ID(),
+				fmt.Sprintf("network namespace %s of container %s does not exist", key, sb.ContainerID()), nil)
+		}
+	}
+
+	keys, err := osl.NamespaceKeys()
+	if err != nil {
+		log.Warnf("Could not list network namespaces during reconcile: %v", err)
+		return
+	}
+
+	for _, key := range keys {
+		name := filepath.Base(key)
+		// Namespaces with a "-" in the name are created and owned by
+		// drivers (e.g. overlay), not by sandboxes.
+		if name == "default" || strings.Contains(name, "-") {
+			continue
+		}
+		// The sandboxes created since the reconcile started own
+		// namespaces missing from the sandbox keys.
+		if c.sandboxKeyInUse(key) {
+			continue
+		}
+		key := key
+		report.add(DriftOrphanNamespace, key, fmt.Sprintf("network namespace %s is not owned by any sandbox", key),
+			func() error { return osl.RemoveNamespace(key) })
+	}
+}
+
+func reconcileVeths(st *reconcileState, report *ReconcileReport) {
+	links, err := ns.NlHandle().LinkList()
+	if err != nil {
+		log.Warnf("Could not list interfaces during reconcile: %v", err)
+		return
+	}
+
+	byIndex := make(map[int]netlink.Link, len(links))
+	for _, l := range links {
+		byIndex[l.Attrs().Index] = l
+	}
+
+	seen := make(map[int]bool)
+	for _, l := range links {
+		// Leave alone the veths created by anything but libnetwork
+		if _, ok := l.(*netlink.Veth); !ok || !vethName.MatchString(l.Attrs().Name) {
+			continue
+		}
+		attrs := l.Attrs()
+		peer, ok := byIndex[attrs.ParentIndex]
+		if !ok || seen[attrs.Index] {
+			continue
+		}
+		pattrs := peer.Attrs()
+		if !vethName.MatchString(pattrs.Name) {
+			continue
+		}
+		// A pair whose both ends are still in the host namespace and
+		// neither end is attached to a bridge was never moved into a
+		// sandbox, or was left behind by an interrupted cleanup.
+		if pattrs.ParentIndex != attrs.Index || attrs.MasterIndex != 0 || pattrs.MasterIndex != 0 {
+			continue
+		}
+		seen[attrs.Index] = true
+		seen[pattrs.Index] = true
+		if st.ifaceNames[attrs.Name] || st.ifaceNames[pattrs.Name] {
+			continue
+		}
+		l := l
+		report.add(DriftDanglingVeth, attrs.Name, fmt.Sprintf("veth pair %s/%s is not used by any endpoint", attrs.Name, pattrs.Name),
+			func() error { return ns.NlHandle().LinkDel(l) })
+	}
+}
+
+func reconcileIptables(table iptables.Table, st *reconcileState, report *ReconcileReport) {
+	if !iptables.ExistChain(dockerChain, table) {
+		return
+	}
+
+	out, err := iptables.Raw("-t", string(table), "-S", dockerChain)
+	if err != nil {
+		log.Warnf("Could not list %s chain in %s table during reconcile: %v", dockerChain, table, err)
+		return
+	}
+
+	for _, rule := range strings.Split(string(out), "\n") {
+		args := strings.Fields(rule)
+		if len(args) < 2 || args[0] != "-A" {
+			continue
+		}
+		ip := ruleAddress(args)
+		if ip == "" || st.addresses[ip] {
+			continue
+		}
+		args[0] = "-D"
+		args = append([]string{"-t", string(table)}, args...)
+		report.add(DriftStaleIptablesRule, rule,
+			fmt.Sprintf("rule in %s table points to %s which is not owned by any endpoint", table, ip),
+			func() error {
+				_, err := iptables.Raw(args...)
+				return err
+			})
+	}
+}
+
+// ruleAddress returns the container address a DOCKER chain rule refers to,
+// or an empty string. The DNAT target is preferred, as the destination of
+// a DNAT rule is the host address a port is published on.
+func ruleAddress(args []string) string {
+	var dst string
+	for i := 0; i < len(args)-1; i++ {
+		switch args[i] {
+		case "--to-destination":
+			host := args[i+1]
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return host
+		case "-d":
+			if dst != "" {
+				continue
+			}
+			if ip, _, err := net.ParseCIDR(args[i+1]); err == nil {
+				dst = ip.String()
+			} else if ip := net.ParseIP(args[i+1]); ip != nil {
+				dst = ip.String()
+			}
+		}
+	}
+	return dst
+}
+
+func reconcileIPVS(st *reconcileState, report *ReconcileReport) {
+	for key, sb := range st.sandboxKeys {
+		if sb.config.useDefaultSandBox || sb.osSbox == nil {
+			continue
+		}
+		if _, err := os.Stat(key); err != nil {
+			continue
+		}
+
+		i, err := ipvs.New(key)
+		if err != nil {
+			log.Warnf("Could not open ipvs handle in sandbox %s during reconcile: %v", sb.ID(), err)
+			continue
+		}
+
+		svcs, err := i.GetServices()
+		if err != nil {
+			log.Warnf("Could not list ipvs services in sandbox %s during reconcile: %v", sb.ID(), err)
+			i.Close()
+			continue
+		}
+
+		for _, s := range svcs {
+			if s.FWMark == 0 || st.fwMarks[s.FWMark] {
+				continue
+			}
+			s := s
+			report.add(DriftStaleIPVSService, fmt.Sprintf("%s/%d", sb.ID(), s.FWMark),
+				fmt.Sprintf("ipvs service with fwmark %d in sandbox %s does not back any service", s.FWMark, sb.ID()),
+				func() error { return i.DelService(s) })
+		}
+		i.Close()
+	}
+}
@@ -0,0 +1,40 @@
+package libnetwork
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuleAddress(t *testing.T) {
+	for _, c := range []struct {
+		rule     string
+		expected string
+	}{
+		{"-A DOCKER ! -i docker0 -p tcp -m tcp --dport 8080 -j DNAT --to-destination 172.17.0.2:80", "172.17.0.2"},
+		// Port published on a specific host address
+		{"-A DOCKER -d 192.168.1.10/32 ! -i docker0 -p tcp -m tcp --dport 8080 -j DNAT --to-destination 172.17.0.2:80", "172.17.0.2"},
+		{"-A DOCKER -d 2001:db8::1/128 ! -i docker0 -p tcp -m tcp --dport 8080 -j DNAT --to-destination [2001:db8:1::2]:80", "2001:db8:1::2"},
+		{"-A DOCKER -d 172.17.0.2/32 ! -i docker0 -o docker0 -p tcp -m tcp --dport 80 -j ACCEPT", "172.17.0.2"},
+		{"-A DOCKER -i docker0 -j RETURN", ""},
+	} {
+		if ip := ruleAddress(strings.Fields(c.rule)); ip != c.expected {
+			t.Fatalf("Expected %q for rule %q, got %q", c.expected, c.rule, ip)
+		}
+	}
+}
+
+func TestVethName(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"veth1a2b3c4":  true,
+		"vethf00d123":  true,
+		"veth1a2b3c":   false,
+		"veth1a2b3c4d": false,
+		"vethXYZ1234":  false,
+		"eth0":         false,
+		"cni0veth1234": false,
+	} {
+		if vethName.MatchString(name) != expected {
+			t.Fatalf("Expected match of %q to be %v", name, expected)
+		}
+	}
+}
@@ -0,0 +1,6 @@
+// +build !linux
+
+package libnetwork
+
+func (c *controller) reconcileOS(st *reconcileState, report *ReconcileReport) {
+}
@@ -4,6 +4,7 @@ package resolvconf
 import (
 	"bytes"
 	"io/ioutil"
+	"os"
 	"regexp"
 	"strings"
 	"sync"
@@ -36,6 +37,16 @@ var (
 	optionsRegexp     = regexp.MustCompile(`^\s*options\s*(([^\s]+\s*)*)$`)
 )
 
+const (
+	// DefaultPath is the path of the host resolv.conf
+	DefaultPath = "/etc/resolv.conf"
+	// ResolvedPath is the path of the resolv.conf systemd-resolved
+	// maintains with the upstream nameservers it uses
+	ResolvedPath = "/run/systemd/resolve/resolv.conf"
+	// resolvedStubIP is the address of the systemd-resolved stub listener
+	resolvedStubIP = "127.0.0.53"
+)
+
 var lastModified struct {
 	sync.Mutex
 	sha256   string
@@ -74,6 +85,44 @@ func GetSpecific(path string) (*File, error) {
 	return &File{Content: resolv, Hash: hash}, nil
 }
 
+// Path returns the path of the host resolv.conf listing the upstream
+// nameservers. When the host resolv.conf only points to the local
+// systemd-resolved stub listener, which the containers cannot reach, that
+// is the resolv.conf maintained by systemd-resolved.
+func Path() string {
+	return detectPath(DefaultPath, ResolvedPath)
+}
+
+func detectPath(path, resolvedPath string) string {
+	resolv, err := ioutil.ReadFile(path)
+	if err != nil {
+		return path
+	}
+	if !IsResolvedStub(resolv) {
+		return path
+	}
+	if _, err := os.Stat(resolvedPath); err != nil {
+		logrus.Warnf("%s points to the systemd-resolved stub listener but %s is not available: %v", path, resolvedPath, err)
+		return path
+	}
+	return resolvedPath
+}
+
+// IsResolvedStub tells whether the resolv.conf only lists the local
+// systemd-resolved stub listener as nameserver
+func IsResolvedStub(resolvConf []byte) bool {
+	nameservers := GetNameservers(resolvConf, types.IP)
+	if len(nameservers) == 0 {
+		return false
+	}
+	for _, ns := range nameservers {
+		if ns != resolvedStubIP {
+			return false
+		}
+	}
+	return true
+}
+
 // GetIfChanged retrieves the host /etc/resolv.conf file, checks against the last hash
 // and, if modified since last check, returns the bytes and new hash.
 // This feature is used by the resolv.conf updater for containers
@@ -298,3 +298,39 @@ func TestFilterResolvDns(t *testing.T) {
 		}
 	}
 }
+
+func TestDetectPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolvconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := dir + "/resolv.conf"
+	resolvedPath := dir + "/resolved.conf"
+
+	if p := detectPath(path, resolvedPath); p != path {
+		t.Fatalf("Expected %s for a missing resolv.conf, got %s", path, p)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("nameserver 127.0.0.53\nsearch example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p := detectPath(path, resolvedPath); p != path {
+		t.Fatalf("Expected %s when systemd-resolved resolv.conf is missing, got %s", path, p)
+	}
+
+	if err := ioutil.WriteFile(resolvedPath, []byte("nameserver 10.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p := detectPath(path, resolvedPath); p != resolvedPath {
+		t.Fatalf("Expected %s for the systemd-resolved stub, got %s", resolvedPath, p)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("nameserver 127.0.0.53\nnameserver 8.8.8.8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p := detectPath(path, resolvedPath); p != path {
+		t.Fatalf("Expected %s when not only the stub is listed, got %s", path, p)
+	}
+}
@@ -0,0 +1,89 @@
+package resolvconf
+
+import (
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultWatchInterval is the interval a resolv.conf file is polled at
+	// for changes when inotify is not available
+	DefaultWatchInterval = 5 * time.Second
+	// watchSettle is the time given to the writers to finish updating
+	// the file after a change notification
+	watchSettle = 100 * time.Millisecond
+)
+
+// Watch calls fn with the new content of the resolv.conf file at path each
+// time it changes, till stop is closed. The changes are detected through
+// inotify where available, by polling the file every interval otherwise.
+func Watch(path string, interval time.Duration, stop <-chan struct{}, fn func(*File)) {
+	var lastHash string
+	if rc, err := GetSpecific(path); err == nil {
+		lastHash = rc.Hash
+	}
+
+	check := func() {
+		rc, err := GetSpecific(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logrus.Debugf("Failed to read %s while watching it: %v", path, err)
+			}
+			return
+		}
+		if rc.Hash == lastHash {
+			return
+		}
+		lastHash = rc.Hash
+		fn(rc)
+	}
+
+	var (
+		notify  <-chan struct{}
+		stopped <-chan struct{}
+		tick    <-chan time.Time
+		ticker  *time.Ticker
+	)
+	poll := func() {
+		ticker = time.NewTicker(interval)
+		tick = ticker.C
+	}
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+	n, err := newNotifier(path)
+	if err != nil {
+		logrus.Debugf("Polling %s for changes, inotify not available: %v", path, err)
+		poll()
+	} else {
+		defer n.close()
+		notify = n.C
+		stopped = n.done
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-stopped:
+			// The notifier failed reading the events, poll from now on
+			logrus.Debugf("Polling %s for changes, inotify stopped", path)
+			notify, stopped = nil, nil
+			poll()
+			check()
+		case <-tick:
+			check()
+		case <-notify:
+			select {
+			case <-time.After(watchSettle):
+			case <-stop:
+				return
+			}
+			check()
+		}
+	}
+}
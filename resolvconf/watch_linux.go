@@ -0,0 +1,129 @@
+package resolvconf
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// The file is often replaced rather than rewritten in place
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE
+
+// notifier signals on C the inotify events for a file. done is closed
+// once the notifier stops reading the events.
+type notifier struct {
+	C     chan struct{}
+	done  chan struct{}
+	stop  chan struct{}
+	names map[string]bool
+
+	// fd is a blocking inotify descriptor, which is closed by run and
+	// set to -1 under the lock
+	sync.Mutex
+	fd  int
+	wds []uint32
+}
+
+func newNotifier(path string) (*notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	n := &notifier{
+		C:     make(chan struct{}, 1),
+		done:  make(chan struct{}),
+		stop:  make(chan struct{}),
+		names: make(map[string]bool),
+		fd:    fd,
+	}
+
+	// The directories are watched, so that the replacement of the file is
+	// noticed. When the file is a symlink, as with the resolv.conf managed
+	// by systemd-resolved, the directory of the target is watched too.
+	paths := []string{path}
+	if target, err := filepath.EvalSymlinks(path); err == nil && target != path {
+		paths = append(paths, target)
+	}
+	for _, p := range paths {
+		wd, err := syscall.InotifyAddWatch(fd, filepath.Dir(p), inotifyMask)
+		if err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+		n.wds = append(n.wds, uint32(wd))
+		n.names[filepath.Base(p)] = true
+	}
+
+	go n.run()
+	return n, nil
+}
+
+func (n *notifier) run() {
+	defer close(n.done)
+	defer func() {
+		n.Lock()
+		syscall.Close(n.fd)
+		n.fd = -1
+		n.Unlock()
+	}()
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		cnt, err := syscall.Read(n.fd, buf)
+		select {
+		case <-n.stop:
+			return
+		default:
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || cnt <= 0 {
+			return
+		}
+
+		var changed bool
+		for off := 0; off+syscall.SizeofInotifyEvent <= cnt; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + syscall.SizeofInotifyEvent
+			off = start + int(ev.Len)
+			if off > cnt {
+				break
+			}
+			name := strings.TrimRight(string(buf[start:off]), "\x00")
+			if n.names[name] || ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				changed = true
+			}
+		}
+		if changed {
+			select {
+			case n.C <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// close stops the notifier. Closing the descriptor does not interrupt a
+// blocking read, so the watches are removed instead: the resulting
+// IN_IGNORED events wake up run, which then closes the descriptor.
+func (n *notifier) close() {
+	n.Lock()
+	defer n.Unlock()
+
+	select {
+	case <-n.stop:
+		return
+	default:
+	}
+	close(n.stop)
+
+	if n.fd < 0 {
+		return
+	}
+	for _, wd := range n.wds {
+		syscall.InotifyRmWatch(n.fd, wd)
+	}
+}
@@ -0,0 +1,101 @@
+package resolvconf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolvconf-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan *File, 10)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		Watch(path, 50*time.Millisecond, stop, func(rc *File) { changes <- rc })
+		close(done)
+	}()
+	// Let the watch start
+	time.Sleep(200 * time.Millisecond)
+
+	expectChange := func(ns string) {
+		select {
+		case rc := <-changes:
+			if got := GetNameservers(rc.Content, types.IP); len(got) != 1 || got[0] != ns {
+				t.Fatalf("Expected nameserver %s, got %v", ns, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Change to nameserver %s not noticed", ns)
+		}
+	}
+
+	// Rewrite in place
+	if err := ioutil.WriteFile(path, []byte("nameserver 2.2.2.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("2.2.2.2")
+
+	// Replace the file
+	tmp := filepath.Join(dir, "resolv.conf.tmp")
+	if err := ioutil.WriteFile(tmp, []byte("nameserver 3.3.3.3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("3.3.3.3")
+
+	// Writing the same content is not a change
+	if err := ioutil.WriteFile(path, []byte("nameserver 3.3.3.3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case rc := <-changes:
+		t.Fatalf("Unexpected change: %s", rc.Content)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not return once stopped")
+	}
+}
+
+func TestNotifierClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolvconf-notifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n, err := newNotifier(filepath.Join(dir, "resolv.conf"))
+	if err != nil {
+		t.Skipf("inotify not available: %v", err)
+	}
+
+	// The notifier is blocked reading the events
+	time.Sleep(100 * time.Millisecond)
+	n.close()
+	select {
+	case <-n.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notifier did not stop once closed")
+	}
+	n.close()
+}
@@ -0,0 +1,17 @@
+// +build !linux
+
+package resolvconf
+
+import "errors"
+
+type notifier struct {
+	C    chan struct{}
+	done chan struct{}
+}
+
+func newNotifier(path string) (*notifier, error) {
+	return nil, errors.New("inotify not supported on this platform")
+}
+
+func (n *notifier) close() {
+}
@@ -0,0 +1,80 @@
+package libnetwork
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/resolvconf"
+)
+
+// startResolvConfWatch starts propagating the changes of the host
+// resolv.conf to the sandboxes
+func (c *controller) startResolvConfWatch() {
+	interval := c.cfg.Daemon.ResolvConfWatchInterval
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = resolvconf.DefaultWatchInterval
+	}
+
+	c.Lock()
+	c.resolvConfStop = make(chan struct{})
+	stop := c.resolvConfStop
+	c.Unlock()
+
+	go resolvconf.Watch(c.hostResolvConf(), interval, stop, c.updateSandboxesDNS)
+}
+
+// hostResolvConf returns the path of the host resolv.conf the nameservers
+// of the containers are taken from
+func (c *controller) hostResolvConf() string {
+	if c.cfg != nil && c.cfg.Daemon.HostResolvConf != "" {
+		return c.cfg.Daemon.HostResolvConf
+	}
+	return resolvconf.Path()
+}
+
+func (c *controller) stopResolvConfWatch() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.resolvConfStop != nil {
+		close(c.resolvConfStop)
+		c.resolvConfStop = nil
+	}
+}
+
+func (c *controller) updateSandboxesDNS(rc *resolvconf.File) {
+	log.Infof("Host resolv.conf changed, updating the DNS configuration of the containers")
+
+	c.Lock()
+	sandboxes := make([]*sandbox, 0, len(c.sandboxes))
+	for _, sb := range c.sandboxes {
+		sandboxes = append(sandboxes, sb)
+	}
+	c.Unlock()
+
+	for _, sb := range sandboxes {
+		if err := sb.updateHostDNS(rc); err != nil {
+			log.Warnf("Failed to update the DNS configuration of container %s: %v", sb.ContainerID(), err)
+			continue
+		}
+		// Persist the new upstream servers of the embedded DNS server
+		if err := sb.storeUpdate(); err != nil {
+			log.Warnf("Failed to update the store state of container %s: %v", sb.ContainerID(), err)
+		}
+	}
+}
+
+// ipv6Enabled tells whether the sandbox is connected to an IPv6 network
+func (sb *sandbox) ipv6Enabled() bool {
+	for _, ep := range sb.getConnectedEndpoints() {
+		n := ep.getNetwork()
+		n.Lock()
+		enabled := n.enableIPv6
+		n.Unlock()
+		if enabled {
+			return true
+		}
+	}
+	return false
+}
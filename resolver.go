@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -30,6 +31,10 @@ type Resolver interface {
 	// SetExtServers configures the external nameservers the resolver
 	// should use to forward queries
 	SetExtServers([]string)
+	// SetForwardRules configures, per domain, the external nameservers
+	// the resolver should forward the queries for the names within the
+	// domain to, instead of the ones set by SetExtServers
+	SetForwardRules(map[string][]string)
 	// ResolverOptions returns resolv.conf options that should be set
 	ResolverOptions() []string
 }
@@ -47,22 +52,32 @@ const (
 	logInterval     = 2 * time.Second
 )
 
+// extDNSEntry is an external DNS server. Its address never changes, the
+// entry is replaced when the server is, so that it can be read without the
+// resolver extLock. The other fields require the extLock.
 type extDNSEntry struct {
-	ipStr string
+	ipStr        string
+	failures     int           // consecutive failed exchanges
+	backoffUntil time.Time     // unhealthy server is skipped till then
+	latency      time.Duration // moving average of the response time
+	idleConns    []idleConn    // TCP connections available for reuse
 }
 
 // resolver implements the Resolver interface
 type resolver struct {
-	sb         *sandbox
-	extDNSList [maxExtDNS]extDNSEntry
-	server     *dns.Server
-	conn       *net.UDPConn
-	tcpServer  *dns.Server
-	tcpListen  *net.TCPListener
-	err        error
-	count      int32
-	tStamp     time.Time
-	queryLock  sync.Mutex
+	sb           *sandbox
+	extDNSList   [maxExtDNS]*extDNSEntry
+	forwardRules []*forwardRule // most specific domain first
+	server       *dns.Server
+	conn         *net.UDPConn
+	tcpServer    *dns.Server
+	tcpListen    *net.TCPListener
+	limiter      *rateLimiter
+	err          error
+	count        int32
+	tStamp       time.Time
+	queryLock    sync.Mutex
+	extLock      sync.Mutex
 }
 
 func init() {
@@ -71,10 +86,15 @@ func init() {
 
 // NewResolver creates a new instance of the Resolver
 func NewResolver(sb *sandbox) Resolver {
-	return &resolver{
+	r := &resolver{
 		sb:  sb,
 		err: fmt.Errorf("setup not done yet"),
 	}
+	if sb != nil && sb.controller != nil && sb.controller.cfg != nil {
+		d := sb.controller.cfg.Daemon
+		r.limiter = newRateLimiter(d.ResolverRateLimit, d.ResolverRateBurst)
+	}
+	return r
 }
 
 func (r *resolver) SetupFunc() func() {
@@ -143,16 +163,109 @@ func (r *resolver) Stop() {
 	r.tStamp = time.Time{}
 	r.count = 0
 	r.queryLock = sync.Mutex{}
+
+	r.extLock.Lock()
+	for _, e := range r.extDNSList {
+		if e != nil {
+			e.closeIdleConns()
+		}
+	}
+	for _, fr := range r.forwardRules {
+		for _, e := range fr.servers {
+			e.closeIdleConns()
+		}
+	}
+	r.extLock.Unlock()
 }
 
 func (r *resolver) SetExtServers(dns []string) {
+	old := r.extServersKey()
+
 	l := len(dns)
 	if l > maxExtDNS {
 		l = maxExtDNS
 	}
-	for i := 0; i < l; i++ {
-		r.extDNSList[i].ipStr = dns[i]
+	r.extLock.Lock()
+	for i := 0; i < maxExtDNS; i++ {
+		var ipStr string
+		if i < l {
+			ipStr = dns[i]
+		}
+		// Keep the health and the connections of unchanged servers.
+		// The queries in flight keep using the replaced entries.
+		e := r.extDNSList[i]
+		if e != nil && e.ipStr == ipStr {
+			continue
+		}
+		if e != nil {
+			e.closeIdleConns()
+		}
+		r.extDNSList[i] = nil
+		if ipStr != "" {
+			r.extDNSList[i] = &extDNSEntry{ipStr: ipStr}
+		}
+	}
+	r.extLock.Unlock()
+
+	// Responses of the previous servers must not be served anymore
+	if old != r.extServersKey() {
+		r.cache().flush(old)
+	}
+}
+
+// extServersKey identifies the set of external servers in the cache
+func (r *resolver) extServersKey() string {
+	r.extLock.Lock()
+	defer r.extLock.Unlock()
+
+	return serversKey(r.defaultServers())
+}
+
+func (r *resolver) SetForwardRules(rules map[string][]string) {
+	var flushed []string
+
+	r.extLock.Lock()
+	old := make(map[string]*forwardRule, len(r.forwardRules))
+	for _, fr := range r.forwardRules {
+		old[fr.domain] = fr
+	}
+
+	var newRules []*forwardRule
+	for domain, servers := range rules {
+		fr := newForwardRule(domain, servers)
+		if fr == nil {
+			continue
+		}
+		// Keep the health and the connections of unchanged rules
+		if o, ok := old[fr.domain]; ok && serversKey(o.servers) == serversKey(fr.servers) {
+			fr = o
+		} else if ok {
+			flushed = append(flushed, serversKey(o.servers))
+			o.closeIdleConns()
+		}
+		delete(old, fr.domain)
+		newRules = append(newRules, fr)
+	}
+	for _, o := range old {
+		flushed = append(flushed, serversKey(o.servers))
+		o.closeIdleConns()
+	}
+
+	sort.Sort(byDomain(newRules))
+	r.forwardRules = newRules
+	r.extLock.Unlock()
+
+	// Responses of the servers of removed rules must not be served anymore
+	for _, key := range flushed {
+		r.cache().flush(key)
+	}
+}
+
+func (r *resolver) cache() *dnsCache {
+	if r.sb == nil || r.sb.controller == nil {
+		return nil
 	}
+	return r.sb.controller.dnsCache
 }
 
 func (r *resolver) NameServer() string {
@@ -183,7 +296,49 @@ func createRespMsg(query *dns.Msg) *dns.Msg {
 	return resp
 }
 
+func ipRecords(name string, addr []net.IP, ipType int) []dns.RR {
+	var rrs []dns.RR
+	if len(addr) > 1 {
+		addr = shuffleAddr(addr)
+	}
+	if ipType == types.IPv4 {
+		for _, ip := range addr {
+			rr := new(dns.A)
+			rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: respTTL}
+			rr.A = ip
+			rrs = append(rrs, rr)
+		}
+	} else {
+		for _, ip := range addr {
+			rr := new(dns.AAAA)
+			rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: respTTL}
+			rr.AAAA = ip
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+func cnameRecord(name, target string) dns.RR {
+	rr := new(dns.CNAME)
+	rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: respTTL}
+	rr.Target = target
+	return rr
+}
+
 func (r *resolver) handleIPQuery(name string, query *dns.Msg, ipType int) (*dns.Msg, error) {
+	if target := r.sb.resolveCNAME(name); target != "" {
+		// Answer with the CNAME record followed by the addresses of
+		// the canonical name
+		addr, _ := r.sb.ResolveName(target, ipType)
+		log.Debugf("Lookup for %s: CNAME %s, IP %v", name, target, addr)
+
+		resp := createRespMsg(query)
+		resp.Answer = append(resp.Answer, cnameRecord(name, target))
+		resp.Answer = append(resp.Answer, ipRecords(target, addr, ipType)...)
+		return resp, nil
+	}
+
 	addr, ipv6Miss := r.sb.ResolveName(name, ipType)
 	if addr == nil && ipv6Miss {
 		// Send a reply without any Answer sections
@@ -198,27 +353,73 @@ func (r *resolver) handleIPQuery(name string, query *dns.Msg, ipType int) (*dns.
 	log.Debugf("Lookup for %s: IP %v", name, addr)
 
 	resp := createRespMsg(query)
-	if len(addr) > 1 {
-		addr = shuffleAddr(addr)
-	}
-	if ipType == types.IPv4 {
-		for _, ip := range addr {
-			rr := new(dns.A)
-			rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: respTTL}
-			rr.A = ip
-			resp.Answer = append(resp.Answer, rr)
+	resp.Answer = append(resp.Answer, ipRecords(name, addr, ipType)...)
+	return resp, nil
+}
+
+func (r *resolver) handleCNAMEQuery(name string, query *dns.Msg) (*dns.Msg, error) {
+	target := r.sb.resolveCNAME(name)
+	if target == "" {
+		if r.sb.isLocalName(name) {
+			// The name is not an alias, reply without any Answer sections
+			return createRespMsg(query), nil
 		}
-	} else {
-		for _, ip := range addr {
-			rr := new(dns.AAAA)
-			rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: respTTL}
-			rr.AAAA = ip
-			resp.Answer = append(resp.Answer, rr)
+		return nil, nil
+	}
+
+	log.Debugf("Lookup for %s: CNAME %s", name, target)
+	resp := createRespMsg(query)
+	resp.Answer = append(resp.Answer, cnameRecord(name, target))
+	return resp, nil
+}
+
+func (r *resolver) handleTXTQuery(name string, query *dns.Msg) (*dns.Msg, error) {
+	txt := r.sb.ResolveTXT(name)
+	if len(txt) == 0 {
+		if r.sb.isLocalName(name) {
+			return createRespMsg(query), nil
 		}
+		return nil, nil
+	}
+
+	log.Debugf("Lookup for %s: TXT %v", name, txt)
+	resp := createRespMsg(query)
+	for _, t := range txt {
+		rr := new(dns.TXT)
+		rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: respTTL}
+		rr.Txt = splitTXT(t)
+		resp.Answer = append(resp.Answer, rr)
 	}
 	return resp, nil
 }
 
+// handleUnsupportedQuery replies with NOTIMP to the queries of the types the
+// embedded server does not serve, for the names it owns. Queries for the
+// other names are forwarded.
+func (r *resolver) handleUnsupportedQuery(name string, query *dns.Msg) *dns.Msg {
+	if !r.sb.isLocalName(name) {
+		return nil
+	}
+
+	log.Debugf("Query %s[%d] type not implemented", name, query.Question[0].Qtype)
+	resp := createRespMsg(query)
+	resp.Rcode = dns.RcodeNotImplemented
+	return resp
+}
+
+// nameErrorMsg returns an NXDOMAIN reply for the names within a network DNS
+// domain that are not found, which must not be forwarded
+func (r *resolver) nameErrorMsg(name string, query *dns.Msg) *dns.Msg {
+	if !r.sb.inDNSDomain(name) || r.hasForwardRule(name) {
+		return nil
+	}
+
+	log.Debugf("Name %s not found in the network DNS domain", name)
+	resp := createRespMsg(query)
+	resp.Rcode = dns.RcodeNameError
+	return resp
+}
+
 func (r *resolver) handlePTRQuery(ptr string, query *dns.Msg) (*dns.Msg, error) {
 	parts := []string{}
 
@@ -299,9 +500,10 @@ func truncateResp(resp *dns.Msg, maxSize int, isTCP bool) {
 
 func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 	var (
-		extConn net.Conn
-		resp    *dns.Msg
-		err     error
+		resp     *dns.Msg
+		err      error
+		answer   = QueryAnswerInternal
+		upstream string
 	)
 
 	if query == nil || len(query.Question) == 0 {
@@ -309,6 +511,24 @@ func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 	}
 	name := query.Question[0].Name
 
+	if l := r.sb.queryLog; l != nil {
+		start := time.Now()
+		defer func() {
+			l.add(newQueryLogEntry(start, w, query, resp, answer, upstream))
+		}()
+	}
+
+	if !r.allowQuery(w) {
+		resolverQueries.With(queryResultLimited).Inc()
+		answer = QueryAnswerLimited
+		resp = new(dns.Msg)
+		resp.SetRcode(query, dns.RcodeRefused)
+		if err = w.WriteMsg(resp); err != nil {
+			log.Errorf("error writing resolver resp, %s", err)
+		}
+		return
+	}
+
 	switch query.Question[0].Qtype {
 	case dns.TypeA:
 		resp, err = r.handleIPQuery(name, query, types.IPv4)
@@ -318,10 +538,21 @@ func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 		resp, err = r.handlePTRQuery(name, query)
 	case dns.TypeSRV:
 		resp, err = r.handleSRVQuery(name, query)
+	case dns.TypeCNAME:
+		resp, err = r.handleCNAMEQuery(name, query)
+	case dns.TypeTXT:
+		resp, err = r.handleTXTQuery(name, query)
+	default:
+		resp = r.handleUnsupportedQuery(name, query)
+	}
+	if resp == nil && err == nil {
+		resp = r.nameErrorMsg(name, query)
 	}
 
 	if err != nil {
+		resolverQueries.With(queryResultFailed).Inc()
 		log.Error(err)
+		answer = QueryAnswerFailed
 		return
 	}
 
@@ -339,69 +570,62 @@ func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 		}
 	}
 
+	extServers, servers := r.forwarders(name)
 	if resp != nil {
+		resolverQueries.With(queryResultLocal).Inc()
+		if resp.Len() > maxSize {
+			truncateResp(resp, maxSize, proto == "tcp")
+		}
+	} else if resp = r.cache().get(servers, query); resp != nil {
+		resolverQueries.With(queryResultCached).Inc()
+		answer = QueryAnswerCached
 		if resp.Len() > maxSize {
 			truncateResp(resp, maxSize, proto == "tcp")
 		}
 	} else {
-		for i := 0; i < maxExtDNS; i++ {
-			extDNS := &r.extDNSList[i]
-			if extDNS.ipStr == "" {
-				break
+		// limits the number of outstanding concurrent queries. The client
+		// is answered so that it does not wait for its timeout.
+		if r.forwardQueryStart() == false {
+			resolverQueries.With(queryResultDropped).Inc()
+			answer = QueryAnswerDropped
+			old := r.tStamp
+			r.tStamp = time.Now()
+			if r.tStamp.Sub(old) > logInterval {
+				log.Errorf("More than %v concurrent queries from %s", maxConcurrent, r.sb.ContainerID())
 			}
-			extConnect := func() {
-				addr := fmt.Sprintf("%s:%d", extDNS.ipStr, 53)
-				extConn, err = net.DialTimeout(proto, addr, extIOTimeout)
+			resp = new(dns.Msg)
+			resp.SetRcode(query, dns.RcodeServerFailure)
+			if err = w.WriteMsg(resp); err != nil {
+				log.Errorf("error writing resolver resp, %s", err)
 			}
+			return
+		}
+		defer r.forwardQueryEnd()
 
-			r.sb.execFunc(extConnect)
-			if err != nil {
-				log.Debugf("Connect failed, %s", err)
-				continue
-			}
+		for _, extDNS := range r.byHealth(extServers) {
 			log.Debugf("Query %s[%d] from %s, forwarding to %s:%s", name, query.Question[0].Qtype,
-				extConn.LocalAddr().String(), proto, extDNS.ipStr)
-
-			// Timeout has to be set for every IO operation.
-			extConn.SetDeadline(time.Now().Add(extIOTimeout))
-			co := &dns.Conn{
-				Conn:    extConn,
-				UDPSize: uint16(maxSize),
-			}
-			defer co.Close()
-
-			// limits the number of outstanding concurrent queries.
-			if r.forwardQueryStart() == false {
-				old := r.tStamp
-				r.tStamp = time.Now()
-				if r.tStamp.Sub(old) > logInterval {
-					log.Errorf("More than %v concurrent queries from %s", maxConcurrent, extConn.LocalAddr().String())
-				}
-				continue
-			}
+				r.sb.ContainerID(), proto, extDNS.ipStr)
 
-			err = co.WriteMsg(query)
+			start := time.Now()
+			resp, err = r.exchange(extDNS, proto, query, maxSize)
 			if err != nil {
-				r.forwardQueryEnd()
-				log.Debugf("Send to DNS server failed, %s", err)
-				continue
-			}
-
-			resp, err = co.ReadMsg()
-			// Truncated DNS replies should be sent to the client so that the
-			// client can retry over TCP
-			if err != nil && err != dns.ErrTruncated {
-				r.forwardQueryEnd()
-				log.Debugf("Read from DNS server failed, %s", err)
+				r.extServerFailed(extDNS, err)
+				log.Debugf("Forwarding to %s failed, %s", extDNS.ipStr, err)
 				continue
 			}
-
-			r.forwardQueryEnd()
+			r.extServerSucceeded(extDNS, time.Since(start))
+			resolverForwardDuration.Since(start)
+			resolverQueries.With(queryResultForwarded).Inc()
+			answer = QueryAnswerForwarded
+			upstream = extDNS.ipStr
 
 			resp.Compress = true
+			r.cache().set(servers, query, resp)
 			break
 		}
 		if resp == nil {
+			resolverQueries.With(queryResultFailed).Inc()
+			answer = QueryAnswerFailed
 			return
 		}
 	}
@@ -411,6 +635,24 @@ func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 	}
 }
 
+// allowQuery tells whether the client of the query is within its rate
+// limit
+func (r *resolver) allowQuery(w dns.ResponseWriter) bool {
+	client := ""
+	if addr := w.RemoteAddr(); addr != nil {
+		client = addr.String()
+		if host, _, err := net.SplitHostPort(client); err == nil {
+			client = host
+		}
+	}
+
+	ok, limited := r.limiter.allow(client, time.Now())
+	if limited > 0 {
+		log.Warnf("Refused %d queries over the rate limit from %s in container %s", limited, client, r.sb.ContainerID())
+	}
+	return ok
+}
+
 func (r *resolver) forwardQueryStart() bool {
 	r.queryLock.Lock()
 	defer r.queryLock.Unlock()
@@ -419,6 +661,7 @@ func (r *resolver) forwardQueryStart() bool {
 		return false
 	}
 	r.count++
+	resolverInflightQueries.Inc()
 
 	return true
 }
@@ -431,5 +674,6 @@ func (r *resolver) forwardQueryEnd() {
 		log.Errorf("Invalid concurrent query count")
 	} else {
 		r.count--
+		resolverInflightQueries.Dec()
 	}
 }
@@ -0,0 +1,219 @@
+package libnetwork
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultDNSCacheSize = 1024
+	maxCacheTTL         = 3600 // seconds
+	maxNegativeCacheTTL = 300  // seconds
+)
+
+// Results of the lookups counted by resolverCacheLookups
+const (
+	cacheResultHit  = "hit"
+	cacheResultMiss = "miss"
+)
+
+// dnsCacheKey identifies a cached response. Responses are cached per set
+// of external servers, as sandboxes may not share the same servers.
+type dnsCacheKey struct {
+	servers string
+	name    string
+	qtype   uint16
+	qclass  uint16
+}
+
+type dnsCacheEntry struct {
+	key    dnsCacheKey
+	msg    *dns.Msg
+	stored time.Time
+	expire time.Time
+}
+
+// dnsCache is a size limited LRU cache of the responses of the external DNS
+// servers. It is shared by the resolvers of all the sandboxes. Positive
+// responses are cached for the lowest TTL of their answers, negative ones
+// for the SOA negative TTL as per RFC 2308.
+type dnsCache struct {
+	size    int
+	entries map[dnsCacheKey]*list.Element
+	lru     *list.List
+	sync.Mutex
+}
+
+// newDNSCache returns a cache holding up to size responses. A zero size
+// selects the default size and a negative size disables the cache.
+func newDNSCache(size int) *dnsCache {
+	if size < 0 {
+		return nil
+	}
+	if size == 0 {
+		size = defaultDNSCacheSize
+	}
+	return &dnsCache{
+		size:    size,
+		entries: make(map[dnsCacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func cacheKey(servers string, q dns.Question) dnsCacheKey {
+	return dnsCacheKey{servers: servers, name: strings.ToLower(q.Name), qtype: q.Qtype, qclass: q.Qclass}
+}
+
+// get returns a copy of the cached response to the query with the TTLs
+// reduced by the time spent in the cache, or nil on a miss
+func (c *dnsCache) get(servers string, query *dns.Msg) *dns.Msg {
+	if c == nil || len(query.Question) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	key := cacheKey(servers, query.Question[0])
+
+	c.Lock()
+	elem, ok := c.entries[key]
+	if ok && now.After(elem.Value.(*dnsCacheEntry).expire) {
+		c.remove(elem)
+		ok = false
+	}
+	if !ok {
+		c.Unlock()
+		resolverCacheLookups.With(cacheResultMiss).Inc()
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	e := elem.Value.(*dnsCacheEntry)
+	resp := e.msg.Copy()
+	c.Unlock()
+
+	resolverCacheLookups.With(cacheResultHit).Inc()
+
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if h := rr.Header(); h.Rrtype != dns.TypeOPT {
+				if h.Ttl > elapsed {
+					h.Ttl -= elapsed
+				} else {
+					h.Ttl = 0
+				}
+			}
+		}
+	}
+	resp.Id = query.Id
+	resp.Question = query.Question
+
+	return resp
+}
+
+// set caches the response to the query if it is cacheable
+func (c *dnsCache) set(servers string, query, resp *dns.Msg) {
+	if c == nil || len(query.Question) == 0 {
+		return
+	}
+
+	ttl := cacheTTL(resp)
+	if ttl == 0 {
+		return
+	}
+
+	now := time.Now()
+	e := &dnsCacheEntry{
+		key:    cacheKey(servers, query.Question[0]),
+		msg:    resp.Copy(),
+		stored: now,
+		expire: now.Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.entries[e.key]; ok {
+		c.remove(elem)
+	}
+	for c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	resolverCacheEntries.Inc()
+}
+
+// flush removes the responses of the specified set of external servers
+func (c *dnsCache) flush(servers string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	for key, elem := range c.entries {
+		if key.servers == servers {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *dnsCache) len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.lru.Len()
+}
+
+// remove must be called with the cache lock held
+func (c *dnsCache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*dnsCacheEntry).key)
+	c.lru.Remove(elem)
+	resolverCacheEntries.Dec()
+}
+
+// cacheTTL returns for how many seconds the response can be cached, zero
+// if it must not be cached
+func cacheTTL(resp *dns.Msg) uint32 {
+	if resp == nil || resp.Truncated {
+		return 0
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+		if len(resp.Answer) != 0 {
+			ttl := uint32(maxCacheTTL)
+			for _, rr := range resp.Answer {
+				if rr.Header().Ttl < ttl {
+					ttl = rr.Header().Ttl
+				}
+			}
+			return ttl
+		}
+		// No data, cache it as a negative response
+		fallthrough
+	case dns.RcodeNameError:
+		for _, rr := range resp.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl := soa.Hdr.Ttl
+				if soa.Minttl < ttl {
+					ttl = soa.Minttl
+				}
+				if ttl > maxNegativeCacheTTL {
+					ttl = maxNegativeCacheTTL
+				}
+				return ttl
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,177 @@
+package libnetwork
+
+import (
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultDNSQueryLogSize = 1000
+	// queryLogWatchBuffer is the number of entries a watcher can lag
+	// behind before the newer entries are dropped for it
+	queryLogWatchBuffer = 64
+)
+
+// The ways a query was answered by the embedded DNS server
+const (
+	QueryAnswerInternal  = "internal"
+	QueryAnswerCached    = "cached"
+	QueryAnswerForwarded = "forwarded"
+	QueryAnswerDropped   = "dropped"
+	QueryAnswerLimited   = "limited"
+	QueryAnswerFailed    = "failed"
+)
+
+// DNSQueryLogEntry records a query served by the embedded DNS server of a
+// sandbox
+type DNSQueryLogEntry struct {
+	Time     time.Time     `json:"time"`
+	Client   string        `json:"client"`
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Answer   string        `json:"answer"`
+	Upstream string        `json:"upstream,omitempty"`
+	Rcode    string        `json:"rcode,omitempty"`
+	Latency  time.Duration `json:"latency"`
+}
+
+// dnsQueryLog keeps the last queries of a sandbox in a ring buffer and
+// sends the new ones to the watchers
+type dnsQueryLog struct {
+	entries  []DNSQueryLogEntry
+	next     int
+	full     bool
+	watchers map[chan DNSQueryLogEntry]struct{}
+	closed   bool
+	sync.Mutex
+}
+
+// OptionDNSQueryLog function returns an option setter to have the embedded
+// DNS server record the queries of the sandbox, keeping the last size ones
+func OptionDNSQueryLog(size int) SandboxOption {
+	return func(sb *sandbox) {
+		if sb.queryLog == nil {
+			sb.queryLog = newDNSQueryLog(size)
+		}
+	}
+}
+
+func newDNSQueryLog(size int) *dnsQueryLog {
+	if size <= 0 {
+		size = defaultDNSQueryLogSize
+	}
+	return &dnsQueryLog{
+		entries:  make([]DNSQueryLogEntry, size),
+		watchers: make(map[chan DNSQueryLogEntry]struct{}),
+	}
+}
+
+func newQueryLogEntry(start time.Time, w dns.ResponseWriter, query, resp *dns.Msg, answer, upstream string) DNSQueryLogEntry {
+	e := DNSQueryLogEntry{
+		Time:     start,
+		Name:     query.Question[0].Name,
+		Type:     dns.Type(query.Question[0].Qtype).String(),
+		Answer:   answer,
+		Upstream: upstream,
+		Latency:  time.Since(start),
+	}
+	if addr := w.RemoteAddr(); addr != nil {
+		e.Client = addr.String()
+	}
+	if resp != nil {
+		e.Rcode = dns.RcodeToString[resp.Rcode]
+	}
+	return e
+}
+
+func (l *dnsQueryLog) add(e DNSQueryLogEntry) {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+
+	for ch := range l.watchers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// list returns the recorded queries, oldest first
+func (l *dnsQueryLog) list() []DNSQueryLogEntry {
+	l.Lock()
+	defer l.Unlock()
+
+	if !l.full {
+		return append([]DNSQueryLogEntry(nil), l.entries[:l.next]...)
+	}
+	entries := make([]DNSQueryLogEntry, 0, len(l.entries))
+	entries = append(entries, l.entries[l.next:]...)
+	return append(entries, l.entries[:l.next]...)
+}
+
+// watch returns a channel the new queries are sent to and the function to
+// stop the watch. The channel is closed when the watch is stopped or the
+// sandbox deleted.
+func (l *dnsQueryLog) watch() (<-chan DNSQueryLogEntry, func()) {
+	l.Lock()
+	defer l.Unlock()
+
+	ch := make(chan DNSQueryLogEntry, queryLogWatchBuffer)
+	if l.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	l.watchers[ch] = struct{}{}
+
+	return ch, func() {
+		l.Lock()
+		defer l.Unlock()
+		if _, ok := l.watchers[ch]; ok {
+			delete(l.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (l *dnsQueryLog) close() {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.closed = true
+	for ch := range l.watchers {
+		delete(l.watchers, ch)
+		close(ch)
+	}
+}
+
+func (sb *sandbox) DNSQueryLog() ([]DNSQueryLogEntry, error) {
+	if sb.queryLog == nil {
+		return nil, types.NotFoundErrorf("DNS query log not enabled for sandbox %s", sb.ID())
+	}
+	return sb.queryLog.list(), nil
+}
+
+func (sb *sandbox) WatchDNSQueryLog() (<-chan DNSQueryLogEntry, func(), error) {
+	if sb.queryLog == nil {
+		return nil, nil, types.NotFoundErrorf("DNS query log not enabled for sandbox %s", sb.ID())
+	}
+	ch, cancel := sb.queryLog.watch()
+	return ch, cancel, nil
+}
@@ -0,0 +1,100 @@
+package libnetwork
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimitPruneInterval is the interval the buckets of the clients that
+// went idle are released at
+const rateLimitPruneInterval = time.Minute
+
+// tokenBucket holds the queries a client is allowed to send
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits the rate of the queries each client of the embedded
+// DNS server sends, with a token bucket per source address. Each client can
+// send burst queries at once, then rate queries per second.
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	clients   map[string]*tokenBucket
+	lastPrune time.Time
+	limited   int // queries refused since the last report
+	lastLog   time.Time
+	sync.Mutex
+}
+
+// newRateLimiter returns a limiter allowing rate queries per second per
+// client with bursts of burst queries. A zero or negative rate disables the
+// limit. A zero burst selects twice the rate.
+func newRateLimiter(rate, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 2 * rate
+	}
+	return &rateLimiter{
+		rate:    float64(rate),
+		burst:   float64(burst),
+		clients: make(map[string]*tokenBucket),
+	}
+}
+
+// allow tells whether the client may send a query at now. When the query
+// is refused it also returns the number of refused queries to report,
+// which is non zero at most once every logInterval.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, int) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.lastPrune) > rateLimitPruneInterval {
+		l.prune(now)
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	l.limited++
+	if now.Sub(l.lastLog) <= logInterval {
+		return false, 0
+	}
+	report := l.limited
+	l.limited = 0
+	l.lastLog = now
+	return false, report
+}
+
+// prune releases the buckets which refilled, the clients having been idle
+// long enough
+func (l *rateLimiter) prune(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.clients {
+		if now.Sub(b.last) >= refill {
+			delete(l.clients, client)
+		}
+	}
+	l.lastPrune = now
+}
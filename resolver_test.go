@@ -0,0 +1,539 @@
+package libnetwork
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newCacheQuery(name string, qtype uint16) *dns.Msg {
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(name), qtype)
+	return q
+}
+
+func newCacheResp(query *dns.Msg, ttl uint32) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	rr := new(dns.A)
+	rr.Hdr = dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}
+	rr.A = net.ParseIP("192.0.2.1")
+	resp.Answer = append(resp.Answer, rr)
+	return resp
+}
+
+func newNegativeCacheResp(query *dns.Msg, rcode int, ttl, minTTL uint32) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetRcode(query, rcode)
+	soa := new(dns.SOA)
+	soa.Hdr = dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl}
+	soa.Ns = "ns.example.com."
+	soa.Mbox = "admin.example.com."
+	soa.Minttl = minTTL
+	resp.Ns = append(resp.Ns, soa)
+	return resp
+}
+
+func TestDNSCacheTTL(t *testing.T) {
+	q := newCacheQuery("www.example.com", dns.TypeA)
+
+	positive := newCacheResp(q, 120)
+	rr := new(dns.A)
+	rr.Hdr = dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30}
+	positive.Answer = append(positive.Answer, rr)
+
+	truncated := newCacheResp(q, 120)
+	truncated.Truncated = true
+
+	noSOA := new(dns.Msg)
+	noSOA.SetRcode(q, dns.RcodeNameError)
+
+	failure := new(dns.Msg)
+	failure.SetRcode(q, dns.RcodeServerFailure)
+
+	input := []struct {
+		resp *dns.Msg
+		ttl  uint32
+	}{
+		{positive, 30},
+		{newCacheResp(q, 2*maxCacheTTL), maxCacheTTL},
+		{newCacheResp(q, 0), 0},
+		{truncated, 0},
+		{newNegativeCacheResp(q, dns.RcodeNameError, 60, 10), 10},
+		{newNegativeCacheResp(q, dns.RcodeNameError, 10, 60), 10},
+		{newNegativeCacheResp(q, dns.RcodeSuccess, 60, 20), 20},
+		{newNegativeCacheResp(q, dns.RcodeNameError, 3600, 3600), maxNegativeCacheTTL},
+		{noSOA, 0},
+		{failure, 0},
+	}
+
+	for i, in := range input {
+		if ttl := cacheTTL(in.resp); ttl != in.ttl {
+			t.Fatalf("Unexpected cache TTL for response %d: expected %d, got %d", i, in.ttl, ttl)
+		}
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	c := newDNSCache(2)
+	servers := "192.0.2.53"
+
+	q1 := newCacheQuery("one.example.com", dns.TypeA)
+	if resp := c.get(servers, q1); resp != nil {
+		t.Fatal("Unexpected hit on an empty cache")
+	}
+
+	c.set(servers, q1, newCacheResp(q1, 60))
+	q := newCacheQuery("ONE.example.com", dns.TypeA)
+	q.Id = 1234
+	resp := c.get(servers, q)
+	if resp == nil {
+		t.Fatal("Expected a cache hit")
+	}
+	if resp.Id != q.Id || resp.Question[0].Name != q.Question[0].Name {
+		t.Fatalf("Cached response does not match the query: %v", resp)
+	}
+	if c.get("198.51.100.53", q1) != nil {
+		t.Fatal("Responses of other servers must not be served")
+	}
+	if c.get(servers, newCacheQuery("one.example.com", dns.TypeAAAA)) != nil {
+		t.Fatal("Responses of other query types must not be served")
+	}
+
+	// The TTLs are reduced by the time spent in the cache
+	c.entries[cacheKey(servers, q1.Question[0])].Value.(*dnsCacheEntry).stored = time.Now().Add(-20 * time.Second)
+	if resp = c.get(servers, q1); resp.Answer[0].Header().Ttl != 40 {
+		t.Fatalf("Expected a TTL of 40, got %d", resp.Answer[0].Header().Ttl)
+	}
+
+	// Expired entries are removed
+	c.entries[cacheKey(servers, q1.Question[0])].Value.(*dnsCacheEntry).expire = time.Now().Add(-time.Second)
+	if c.get(servers, q1) != nil || c.len() != 0 {
+		t.Fatal("Expected the expired response to be removed")
+	}
+
+	// The least recently used entry is evicted
+	q2 := newCacheQuery("two.example.com", dns.TypeA)
+	q3 := newCacheQuery("three.example.com", dns.TypeA)
+	c.set(servers, q1, newCacheResp(q1, 60))
+	c.set(servers, q2, newCacheResp(q2, 60))
+	c.get(servers, q1)
+	c.set(servers, q3, newCacheResp(q3, 60))
+	if c.len() != 2 || c.get(servers, q2) != nil || c.get(servers, q1) == nil || c.get(servers, q3) == nil {
+		t.Fatal("Expected the least recently used response to be evicted")
+	}
+
+	// Flushing only removes the responses of the specified servers
+	c.set("198.51.100.53", q2, newCacheResp(q2, 60))
+	c.flush(servers)
+	if c.len() != 1 || c.get("198.51.100.53", q2) == nil {
+		t.Fatal("Expected only the responses of the flushed servers to be removed")
+	}
+}
+
+func TestDNSCacheDisabled(t *testing.T) {
+	if c := newDNSCache(0); c == nil || c.size != defaultDNSCacheSize {
+		t.Fatal("Expected a cache of the default size")
+	}
+
+	c := newDNSCache(-1)
+	q := newCacheQuery("www.example.com", dns.TypeA)
+	c.set("192.0.2.53", q, newCacheResp(q, 60))
+	if c.get("192.0.2.53", q) != nil || c.len() != 0 {
+		t.Fatal("Expected the cache to be disabled")
+	}
+	c.flush("192.0.2.53")
+}
+
+func newTestResolver(servers ...string) *resolver {
+	r := &resolver{}
+	for i, s := range servers {
+		r.extDNSList[i] = &extDNSEntry{ipStr: s}
+	}
+	return r
+}
+
+func extServerNames(list []*extDNSEntry) []string {
+	var names []string
+	for _, e := range list {
+		names = append(names, e.ipStr)
+	}
+	return names
+}
+
+func TestExtServersHealth(t *testing.T) {
+	r := newTestResolver("192.0.2.1", "192.0.2.2", "192.0.2.3")
+	e1, e2, e3 := r.extDNSList[0], r.extDNSList[1], r.extDNSList[2]
+
+	if names := extServerNames(r.byHealth(r.defaultServers())); !reflect.DeepEqual(names, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}) {
+		t.Fatalf("Unexpected server order %v", names)
+	}
+
+	// A server stays in use until it fails maxExtDNSFailures times in a row
+	for i := 0; i < maxExtDNSFailures-1; i++ {
+		r.extServerFailed(e1, errors.New("timeout"))
+	}
+	if names := extServerNames(r.byHealth(r.defaultServers())); names[0] != "192.0.2.1" {
+		t.Fatalf("Unexpected server order %v", names)
+	}
+
+	r.extServerFailed(e1, errors.New("timeout"))
+	if names := extServerNames(r.byHealth(r.defaultServers())); !reflect.DeepEqual(names, []string{"192.0.2.2", "192.0.2.3"}) {
+		t.Fatalf("Unhealthy server should be skipped, got %v", names)
+	}
+	if b := e1.backoffUntil.Sub(time.Now()); b <= 0 || b > extDNSBackoff {
+		t.Fatalf("Unexpected backoff %v", b)
+	}
+
+	// The backoff grows with the failures
+	r.extServerFailed(e1, errors.New("timeout"))
+	if b := e1.backoffUntil.Sub(time.Now()); b <= extDNSBackoff || b > 2*extDNSBackoff {
+		t.Fatalf("Unexpected backoff %v", b)
+	}
+	for i := 0; i < 20; i++ {
+		r.extServerFailed(e1, errors.New("timeout"))
+	}
+	if b := e1.backoffUntil.Sub(time.Now()); b <= 0 || b > maxExtDNSBackoff {
+		t.Fatalf("Unexpected backoff %v", b)
+	}
+
+	// Once the backoff expired the server is probed after the healthy ones
+	e1.backoffUntil = time.Now().Add(-time.Second)
+	if names := extServerNames(r.byHealth(r.defaultServers())); !reflect.DeepEqual(names, []string{"192.0.2.2", "192.0.2.3", "192.0.2.1"}) {
+		t.Fatalf("Unexpected server order %v", names)
+	}
+
+	// Servers backing off are tried when no other server is available
+	for i := 0; i < maxExtDNSFailures; i++ {
+		r.extServerFailed(e2, errors.New("timeout"))
+		r.extServerFailed(e3, errors.New("timeout"))
+	}
+	e1.backoffUntil = time.Now().Add(time.Hour)
+	e3.backoffUntil = time.Now().Add(time.Minute)
+	if names := extServerNames(r.byHealth(r.defaultServers())); !reflect.DeepEqual(names, []string{"192.0.2.2", "192.0.2.3", "192.0.2.1"}) {
+		t.Fatalf("Unexpected server order %v", names)
+	}
+
+	// A success marks the server healthy again
+	r.extServerSucceeded(e1, 10*time.Millisecond)
+	r.extServerSucceeded(e1, 20*time.Millisecond)
+	if names := extServerNames(r.byHealth(r.defaultServers())); !reflect.DeepEqual(names, []string{"192.0.2.1"}) {
+		t.Fatalf("Unexpected server order %v", names)
+	}
+	if e1.latency <= 10*time.Millisecond || e1.latency >= 20*time.Millisecond {
+		t.Fatalf("Unexpected latency %v", e1.latency)
+	}
+}
+
+// testConn is a connection to an external server which is never used
+type testConn struct {
+	net.Conn
+	remote net.Addr
+	closed bool
+}
+
+func (c *testConn) RemoteAddr() net.Addr { return c.remote }
+func (c *testConn) Close() error         { c.closed = true; return nil }
+
+func TestExtConnReuse(t *testing.T) {
+	r := newTestResolver("192.0.2.1")
+	e := r.extDNSList[0]
+
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	c1 := &testConn{remote: remote}
+	r.releaseExtConn(e, "tcp", &dns.Conn{Conn: c1})
+	if len(e.idleConns) != 1 || c1.closed {
+		t.Fatal("Expected the TCP connection to be kept")
+	}
+
+	co, reused, err := r.extConn(e, "tcp", 0)
+	if err != nil || !reused || co.Conn != c1 || len(e.idleConns) != 0 {
+		t.Fatalf("Expected the idle connection to be reused, got %v %v %v", co, reused, err)
+	}
+
+	// Idle connections are only kept up to a limit and for a while
+	var conns []*testConn
+	for i := 0; i < maxIdleExtConns+1; i++ {
+		c := &testConn{remote: remote}
+		conns = append(conns, c)
+		r.releaseExtConn(e, "tcp", &dns.Conn{Conn: c})
+	}
+	if len(e.idleConns) != maxIdleExtConns || !conns[maxIdleExtConns].closed {
+		t.Fatal("Expected the connections above the limit to be closed")
+	}
+	r.extLock.Lock()
+	e.closeIdleConns()
+	r.extLock.Unlock()
+	for _, c := range conns {
+		if !c.closed {
+			t.Fatal("Expected all the idle connections to be closed")
+		}
+	}
+
+	// Connections to a server which was since replaced are not kept
+	c2 := &testConn{remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.9"), Port: 53}}
+	r.releaseExtConn(e, "tcp", &dns.Conn{Conn: c2})
+	if !c2.closed {
+		t.Fatal("Expected the connection to the replaced server to be closed")
+	}
+
+	// UDP connections are never kept
+	c3 := &testConn{remote: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}}
+	r.releaseExtConn(e, "udp", &dns.Conn{Conn: c3})
+	if !c3.closed {
+		t.Fatal("Expected the UDP connection to be closed")
+	}
+}
+
+func TestForwardRules(t *testing.T) {
+	r := newTestResolver("192.0.2.1", "192.0.2.2")
+
+	r.SetForwardRules(map[string][]string{
+		"corp.example":         {"10.0.0.53", "10.0.1.53"},
+		"LAB.corp.example.":    {"10.1.0.53"},
+		"invalid.example.":     {"not-an-ip"},
+		"0.10.in-addr.arpa.":   {"10.0.0.53", "bad", "10.0.1.53"},
+		"partial.example.com.": {"bad", "10.2.0.53"},
+	})
+
+	input := []struct {
+		name    string
+		servers string
+	}{
+		{"corp.example.", "10.0.0.53,10.0.1.53"},
+		{"www.Corp.Example.", "10.0.0.53,10.0.1.53"},
+		{"host.lab.corp.example.", "10.1.0.53"},
+		{"lab.corp.example.", "10.1.0.53"},
+		{"notcorp.example.", "192.0.2.1,192.0.2.2"},
+		{"www.invalid.example.", "192.0.2.1,192.0.2.2"},
+		{"5.0.0.10.in-addr.arpa.", "10.0.0.53,10.0.1.53"},
+		{"x.partial.example.com.", "10.2.0.53"},
+		{"www.docker.com.", "192.0.2.1,192.0.2.2"},
+	}
+	for _, i := range input {
+		if _, key := r.forwarders(i.name); key != i.servers {
+			t.Fatalf("Unexpected servers for %s: expected %s, got %s", i.name, i.servers, key)
+		}
+	}
+
+	// The health of the servers of unchanged rules is kept
+	servers, _ := r.forwarders("corp.example.")
+	r.extServerFailed(servers[0], errors.New("timeout"))
+	r.SetForwardRules(map[string][]string{
+		"corp.example.":     {"10.0.0.53", "10.0.1.53"},
+		"lab.corp.example.": {"10.1.1.53"},
+	})
+	if s, _ := r.forwarders("corp.example."); s[0] != servers[0] || s[0].failures != 1 {
+		t.Fatal("Expected the unchanged rule to be kept")
+	}
+	if _, key := r.forwarders("lab.corp.example."); key != "10.1.1.53" {
+		t.Fatalf("Expected the rule to be updated, got %s", key)
+	}
+	if _, key := r.forwarders("0.0.10.in-addr.arpa."); key != "192.0.2.1,192.0.2.2" {
+		t.Fatalf("Expected the rule to be removed, got %s", key)
+	}
+
+	r.SetForwardRules(nil)
+	if _, key := r.forwarders("corp.example."); key != "192.0.2.1,192.0.2.2" {
+		t.Fatalf("Expected all the rules to be removed, got %s", key)
+	}
+}
+
+func TestSplitDNSName(t *testing.T) {
+	reqName, networkName := splitDNSName("web.prod.internal.")
+	if !reflect.DeepEqual(reqName, []string{"web.prod.internal", "web.prod", "web"}) ||
+		!reflect.DeepEqual(networkName, []string{"", "internal", "prod.internal"}) {
+		t.Fatalf("Unexpected split: %v %v", reqName, networkName)
+	}
+
+	reqName, networkName = splitDNSName("web")
+	if !reflect.DeepEqual(reqName, []string{"web"}) || !reflect.DeepEqual(networkName, []string{""}) {
+		t.Fatalf("Unexpected split: %v %v", reqName, networkName)
+	}
+}
+
+func TestCollectTXT(t *testing.T) {
+	txt := collectTXT(map[string][]string{
+		"ep2": {"version=1.2", "tier=back"},
+		"ep1": {"version=1.2"},
+		"ep3": {"version=1.3"},
+	})
+	if !reflect.DeepEqual(txt, []string{"version=1.2", "tier=back", "version=1.3"}) {
+		t.Fatalf("Unexpected TXT records: %v", txt)
+	}
+
+	if txt := collectTXT(nil); txt != nil {
+		t.Fatalf("Expected no TXT records, got: %v", txt)
+	}
+}
+
+func TestSplitTXT(t *testing.T) {
+	if strs := splitTXT("version=1.2"); !reflect.DeepEqual(strs, []string{"version=1.2"}) {
+		t.Fatalf("Unexpected character strings: %v", strs)
+	}
+
+	long := strings.Repeat("a", 2*maxTXTStringLen+10)
+	strs := splitTXT(long)
+	if len(strs) != 3 || len(strs[0]) != maxTXTStringLen || len(strs[2]) != 10 || strings.Join(strs, "") != long {
+		t.Fatalf("Unexpected character strings for a long record: %d", len(strs))
+	}
+
+	// The record must pack in a DNS message
+	rr := new(dns.TXT)
+	rr.Hdr = dns.RR_Header{Name: "web.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: respTTL}
+	rr.Txt = strs
+	m := new(dns.Msg)
+	m.SetQuestion("web.", dns.TypeTXT)
+	m.Answer = append(m.Answer, rr)
+	if _, err := m.Pack(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDNSQueryLog(t *testing.T) {
+	l := newDNSQueryLog(3)
+
+	if entries := l.list(); len(entries) != 0 {
+		t.Fatalf("Expected an empty log, got %d entries", len(entries))
+	}
+
+	ch, cancel := l.watch()
+	for _, name := range []string{"a.", "b.", "c.", "d."} {
+		l.add(DNSQueryLogEntry{Name: name, Answer: QueryAnswerInternal})
+	}
+
+	var names []string
+	for _, e := range l.list() {
+		names = append(names, e.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"b.", "c.", "d."}) {
+		t.Fatalf("Unexpected log content: %v", names)
+	}
+
+	for _, name := range []string{"a.", "b.", "c.", "d."} {
+		if e := <-ch; e.Name != name {
+			t.Fatalf("Expected %s from the watch, got %s", name, e.Name)
+		}
+	}
+
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatal("Expected the watch channel to be closed")
+	}
+	cancel()
+
+	ch, _ = l.watch()
+	l.close()
+	if _, ok := <-ch; ok {
+		t.Fatal("Expected the watch channel to be closed with the log")
+	}
+	if ch, _ = l.watch(); ch == nil {
+		t.Fatal("Expected a closed channel from a closed log")
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("Expected the watch channel to be closed with the log")
+	}
+
+	// A nil log does not record anything
+	var nl *dnsQueryLog
+	nl.add(DNSQueryLogEntry{Name: "a."})
+	nl.close()
+}
+
+func TestRateLimiter(t *testing.T) {
+	if l := newRateLimiter(0, 0); l != nil {
+		t.Fatal("Expected the limit to be disabled by default")
+	}
+	if l := newRateLimiter(-1, 0); l != nil {
+		t.Fatal("Expected a negative rate to disable the limit")
+	}
+	if ok, _ := (*rateLimiter)(nil).allow("10.0.0.2", time.Now()); !ok {
+		t.Fatal("Expected a disabled limiter to allow the queries")
+	}
+
+	l := newRateLimiter(10, 5)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.allow("10.0.0.2", now); !ok {
+			t.Fatalf("Expected query %d of the burst to be allowed", i)
+		}
+	}
+	ok, limited := l.allow("10.0.0.2", now)
+	if ok {
+		t.Fatal("Expected the query over the burst to be refused")
+	}
+	if limited != 1 {
+		t.Fatalf("Expected 1 refused query to be reported, got %d", limited)
+	}
+	if ok, limited = l.allow("10.0.0.2", now); ok || limited != 0 {
+		t.Fatalf("Expected the query to be refused without report, got %v, %d", ok, limited)
+	}
+
+	// Other clients have their own bucket
+	if ok, _ := l.allow("10.0.0.3", now); !ok {
+		t.Fatal("Expected the query of another client to be allowed")
+	}
+
+	// A token is added every 100ms
+	now = now.Add(100 * time.Millisecond)
+	if ok, _ := l.allow("10.0.0.2", now); !ok {
+		t.Fatal("Expected the query to be allowed after the refill")
+	}
+	if ok, _ := l.allow("10.0.0.2", now); ok {
+		t.Fatal("Expected the query to be refused")
+	}
+
+	now = now.Add(logInterval + time.Second)
+	l.allow("10.0.0.3", now)
+	if len(l.clients) != 2 {
+		t.Fatalf("Expected 2 clients, got %d", len(l.clients))
+	}
+	now = now.Add(rateLimitPruneInterval + time.Second)
+	l.allow("10.0.0.3", now)
+	if _, ok := l.clients["10.0.0.2"]; ok {
+		t.Fatal("Expected the bucket of the idle client to be released")
+	}
+}
+
+type tstDNSWriter struct {
+	msg *dns.Msg
+}
+
+func (w *tstDNSWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP(resolverIP), Port: 53}
+}
+func (w *tstDNSWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("172.17.0.2"), Port: 40000}
+}
+func (w *tstDNSWriter) WriteMsg(m *dns.Msg) error   { w.msg = m; return nil }
+func (w *tstDNSWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *tstDNSWriter) Close() error                { return nil }
+func (w *tstDNSWriter) TsigStatus() error           { return nil }
+func (w *tstDNSWriter) TsigTimersOnly(bool)         {}
+func (w *tstDNSWriter) Hijack()                     {}
+
+func TestConcurrentQueriesOverLimit(t *testing.T) {
+	r := newTestResolver("192.0.2.1")
+	r.sb = &sandbox{controller: &controller{}}
+	r.count = maxConcurrent
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	w := &tstDNSWriter{}
+	r.ServeDNS(w, q)
+
+	if w.msg == nil {
+		t.Fatal("Expected the query over the concurrency limit to be answered")
+	}
+	if w.msg.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected SERVFAIL, got %s", dns.RcodeToString[w.msg.Rcode])
+	}
+	if r.count != maxConcurrent {
+		t.Fatalf("Unexpected concurrent query count %d", r.count)
+	}
+}
@@ -0,0 +1,320 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/miekg/dns"
+)
+
+const (
+	maxExtDNSFailures = 3                // consecutive failures before backing off
+	extDNSBackoff     = 2 * time.Second  // initial backoff of an unhealthy server
+	maxExtDNSBackoff  = time.Minute      // maximum backoff of an unhealthy server
+	maxIdleExtConns   = 4                // idle TCP connections kept per server
+	extConnIdleTime   = 10 * time.Second // idle TCP connections are closed after
+	latencyWeight     = 0.2              // weight of a sample in the latency average
+)
+
+type idleConn struct {
+	conn  *dns.Conn
+	since time.Time
+}
+
+// forwardRule forwards the queries for the names within a domain to its
+// own external servers
+type forwardRule struct {
+	domain  string
+	servers []*extDNSEntry
+}
+
+// newForwardRule returns the rule for the domain, nil if none of the
+// servers is valid
+func newForwardRule(domain string, servers []string) *forwardRule {
+	fr := &forwardRule{domain: strings.ToLower(dns.Fqdn(domain))}
+	for _, s := range servers {
+		if net.ParseIP(s) == nil {
+			log.Warnf("Ignoring invalid DNS server %q for domain %s", s, fr.domain)
+			continue
+		}
+		if len(fr.servers) == maxExtDNS {
+			break
+		}
+		fr.servers = append(fr.servers, &extDNSEntry{ipStr: s})
+	}
+	if len(fr.servers) == 0 {
+		return nil
+	}
+	return fr
+}
+
+// closeIdleConns must be called with the resolver extLock held
+func (fr *forwardRule) closeIdleConns() {
+	for _, e := range fr.servers {
+		e.closeIdleConns()
+	}
+}
+
+// byDomain orders the rules from the most to the least specific domain
+type byDomain []*forwardRule
+
+func (b byDomain) Len() int      { return len(b) }
+func (b byDomain) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byDomain) Less(i, j int) bool {
+	li, lj := dns.CountLabel(b[i].domain), dns.CountLabel(b[j].domain)
+	if li != lj {
+		return li > lj
+	}
+	return b[i].domain < b[j].domain
+}
+
+// forwarders returns the external servers the queries for the name are
+// forwarded to, those of the most specific forwarding rule matching the
+// name or else the default ones, and the key identifying them in the cache
+func (r *resolver) forwarders(name string) ([]*extDNSEntry, string) {
+	name = strings.ToLower(name)
+
+	r.extLock.Lock()
+	defer r.extLock.Unlock()
+
+	for _, fr := range r.forwardRules {
+		if dns.IsSubDomain(fr.domain, name) {
+			return fr.servers, serversKey(fr.servers)
+		}
+	}
+
+	servers := r.defaultServers()
+	return servers, serversKey(servers)
+}
+
+// hasForwardRule tells whether the queries for the name are forwarded
+// following a per domain rule
+func (r *resolver) hasForwardRule(name string) bool {
+	name = strings.ToLower(name)
+
+	r.extLock.Lock()
+	defer r.extLock.Unlock()
+
+	for _, fr := range r.forwardRules {
+		if dns.IsSubDomain(fr.domain, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultServers must be called with the resolver extLock held
+func (r *resolver) defaultServers() []*extDNSEntry {
+	var servers []*extDNSEntry
+	for _, e := range r.extDNSList {
+		if e == nil {
+			break
+		}
+		servers = append(servers, e)
+	}
+	return servers
+}
+
+// serversKey must be called with the resolver extLock held
+func serversKey(servers []*extDNSEntry) string {
+	ips := make([]string, 0, len(servers))
+	for _, e := range servers {
+		ips = append(ips, e.ipStr)
+	}
+	return strings.Join(ips, ",")
+}
+
+// byHealth returns the servers a query should be forwarded to: the healthy
+// ones in configuration order followed by the unhealthy ones which are due
+// to be probed again. Servers backing off are only returned, soonest probed
+// first, when no other server is available.
+func (r *resolver) byHealth(servers []*extDNSEntry) []*extDNSEntry {
+	now := time.Now()
+
+	r.extLock.Lock()
+	defer r.extLock.Unlock()
+
+	var healthy, probe, backoff []*extDNSEntry
+	for _, e := range servers {
+		switch {
+		case e.failures < maxExtDNSFailures:
+			healthy = append(healthy, e)
+		case !now.Before(e.backoffUntil):
+			probe = append(probe, e)
+		default:
+			backoff = append(backoff, e)
+		}
+	}
+
+	if len(healthy) == 0 && len(probe) == 0 {
+		sort.Sort(byBackoff(backoff))
+		return backoff
+	}
+	return append(healthy, probe...)
+}
+
+type byBackoff []*extDNSEntry
+
+func (b byBackoff) Len() int           { return len(b) }
+func (b byBackoff) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byBackoff) Less(i, j int) bool { return b[i].backoffUntil.Before(b[j].backoffUntil) }
+
+// extServerSucceeded marks the server healthy and accounts for the latency
+// of its response
+func (r *resolver) extServerSucceeded(e *extDNSEntry, rtt time.Duration) {
+	r.extLock.Lock()
+	defer r.extLock.Unlock()
+
+	if e.failures >= maxExtDNSFailures {
+		log.Infof("External DNS server %s is reachable again", e.ipStr)
+	}
+	e.failures = 0
+	e.backoffUntil = time.Time{}
+	if e.latency == 0 {
+		e.latency = rtt
+	} else {
+		e.latency = time.Duration(latencyWeight*float64(rtt) + (1-latencyWeight)*float64(e.latency))
+	}
+}
+
+// extServerFailed accounts for a failed exchange with the server. After
+// maxExtDNSFailures consecutive failures the server is skipped for an
+// exponentially growing period, after which it is probed again.
+func (r *resolver) extServerFailed(e *extDNSEntry, err error) {
+	resolverUpstreamFailures.With(e.ipStr).Inc()
+
+	r.extLock.Lock()
+	defer r.extLock.Unlock()
+
+	e.failures++
+	if e.failures < maxExtDNSFailures {
+		return
+	}
+
+	backoff := maxExtDNSBackoff
+	if n := uint(e.failures - maxExtDNSFailures); n < 6 {
+		if b := extDNSBackoff << n; b < backoff {
+			backoff = b
+		}
+	}
+	e.backoffUntil = time.Now().Add(backoff)
+	log.Debugf("External DNS server %s failed %d times, last error %v: skipping it for %v", e.ipStr, e.failures, err, backoff)
+}
+
+// exchange forwards the query to the external server and returns its
+// response. TCP connections to the server are reused across queries.
+func (r *resolver) exchange(e *extDNSEntry, proto string, query *dns.Msg, maxSize int) (*dns.Msg, error) {
+	co, reused, err := r.extConn(e, proto, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := exchangeConn(co, query)
+	if err != nil && reused {
+		// The server may have closed the idle connection
+		co.Close()
+		if co, err = r.dialExtConn(e, proto, maxSize); err != nil {
+			return nil, err
+		}
+		resp, err = exchangeConn(co, query)
+	}
+	if err != nil {
+		co.Close()
+		return nil, err
+	}
+
+	r.releaseExtConn(e, proto, co)
+	return resp, nil
+}
+
+func exchangeConn(co *dns.Conn, query *dns.Msg) (*dns.Msg, error) {
+	// Timeout has to be set for every IO operation.
+	co.SetDeadline(time.Now().Add(extIOTimeout))
+
+	if err := co.WriteMsg(query); err != nil {
+		return nil, fmt.Errorf("send to DNS server failed, %s", err)
+	}
+
+	resp, err := co.ReadMsg()
+	// Truncated DNS replies should be sent to the client so that the
+	// client can retry over TCP
+	if err != nil && err != dns.ErrTruncated {
+		return nil, fmt.Errorf("read from DNS server failed, %s", err)
+	}
+	if resp.Id != query.Id {
+		return nil, fmt.Errorf("DNS server response id %d does not match query id %d", resp.Id, query.Id)
+	}
+
+	return resp, nil
+}
+
+// extConn returns an idle TCP connection to the server if there is one,
+// a new connection otherwise
+func (r *resolver) extConn(e *extDNSEntry, proto string, maxSize int) (*dns.Conn, bool, error) {
+	if proto == "tcp" {
+		now := time.Now()
+
+		r.extLock.Lock()
+		for len(e.idleConns) > 0 {
+			ic := e.idleConns[len(e.idleConns)-1]
+			e.idleConns = e.idleConns[:len(e.idleConns)-1]
+			if now.Sub(ic.since) < extConnIdleTime {
+				r.extLock.Unlock()
+				return ic.conn, true, nil
+			}
+			ic.conn.Close()
+		}
+		r.extLock.Unlock()
+	}
+
+	co, err := r.dialExtConn(e, proto, maxSize)
+	return co, false, err
+}
+
+func (r *resolver) dialExtConn(e *extDNSEntry, proto string, maxSize int) (*dns.Conn, error) {
+	r.extLock.Lock()
+	addr := net.JoinHostPort(e.ipStr, dnsPort)
+	r.extLock.Unlock()
+
+	var (
+		extConn net.Conn
+		err     error
+	)
+	extConnect := func() {
+		extConn, err = net.DialTimeout(proto, addr, extIOTimeout)
+	}
+
+	r.sb.execFunc(extConnect)
+	if err != nil {
+		return nil, fmt.Errorf("connect failed, %s", err)
+	}
+
+	return &dns.Conn{Conn: extConn, UDPSize: uint16(maxSize)}, nil
+}
+
+// releaseExtConn keeps the TCP connection for reuse, as long as the server
+// did not change in the meantime
+func (r *resolver) releaseExtConn(e *extDNSEntry, proto string, co *dns.Conn) {
+	if proto == "tcp" {
+		r.extLock.Lock()
+		if co.RemoteAddr().String() == net.JoinHostPort(e.ipStr, dnsPort) && len(e.idleConns) < maxIdleExtConns {
+			e.idleConns = append(e.idleConns, idleConn{conn: co, since: time.Now()})
+			r.extLock.Unlock()
+			return
+		}
+		r.extLock.Unlock()
+	}
+	co.Close()
+}
+
+// closeIdleConns must be called with the resolver extLock held
+func (e *extDNSEntry) closeIdleConns() {
+	for _, ic := range e.idleConns {
+		ic.conn.Close()
+	}
+	e.idleConns = nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -12,8 +13,10 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/etchosts"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/osl"
 	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/context"
 )
 
 // Sandbox provides the control over the network container entity. It is a one to one mapping with the container.
@@ -31,12 +34,18 @@ type Sandbox interface {
 	// Refresh leaves all the endpoints, resets and re-applies the options,
 	// re-joins all the endpoints without destroying the osl sandbox
 	Refresh(options ...SandboxOption) error
+	// RefreshWithContext is like Refresh but abandons the endpoint leaves
+	// and joins once ctx is done
+	RefreshWithContext(ctx context.Context, options ...SandboxOption) error
 	// SetKey updates the Sandbox Key
 	SetKey(key string) error
 	// Rename changes the name of all attached Endpoints
 	Rename(name string) error
 	// Delete destroys this container after detaching it from all connected endpoints.
 	Delete() error
+	// DeleteWithContext is like Delete but abandons the driver calls once
+	// ctx is done
+	DeleteWithContext(ctx context.Context) error
 	// ResolveName resolves a service name to an IPv4 or IPv6 address by searching
 	// the networks the sandbox is connected to. For IPv6 queries, second return
 	// value will be true if the name exists in docker domain but doesn't have an
@@ -50,6 +59,12 @@ type Sandbox interface {
 	ResolveService(name string) ([]*net.SRV, []net.IP, error)
 	// Endpoints returns all the endpoints connected to the sandbox
 	Endpoints() []Endpoint
+	// DNSQueryLog returns the queries recorded by the embedded DNS server,
+	// oldest first. The sandbox must be created with OptionDNSQueryLog.
+	DNSQueryLog() ([]DNSQueryLogEntry, error)
+	// WatchDNSQueryLog returns a channel the queries are sent to as the
+	// embedded DNS server records them, and the function to stop the watch
+	WatchDNSQueryLog() (<-chan DNSQueryLogEntry, func(), error)
 }
 
 // SandboxOption is an option setter function type used to pass various options to
@@ -72,10 +87,13 @@ type sandbox struct {
 	containerID        string
 	config             containerConfig
 	extDNS             []string
+	dnsSearchAdded     []string   // search domains added for the networks
+	resolvConfLock     sync.Mutex // serializes the resolv.conf updates, guards extDNS and dnsSearchAdded
 	osSbox             osl.Sandbox
 	controller         *controller
 	resolver           Resolver
 	resolverOnce       sync.Once
+	queryLog           *dnsQueryLog
 	refCnt             int
 	endpoints          epHeap
 	epPriority         map[string]int
@@ -118,6 +136,7 @@ type resolvConfPathConfig struct {
 	dnsList              []string
 	dnsSearchList        []string
 	dnsOptionsList       []string
+	dnsForwardRules      map[string][]string
 }
 
 type containerConfig struct {
@@ -176,10 +195,14 @@ func (sb *sandbox) Statistics() (map[string]*types.InterfaceStatistics, error) {
 }
 
 func (sb *sandbox) Delete() error {
-	return sb.delete(false)
+	return sb.delete(context.Background(), false)
 }
 
-func (sb *sandbox) delete(force bool) error {
+func (sb *sandbox) DeleteWithContext(ctx context.Context) error {
+	return sb.delete(ctx, false)
+}
+
+func (sb *sandbox) delete(ctx context.Context, force bool) error {
 	sb.Lock()
 	if sb.inDelete {
 		sb.Unlock()
@@ -213,14 +236,20 @@ func (sb *sandbox) delete(force bool) error {
 		}
 
 		if !force {
-			if err := ep.Leave(sb); err != nil {
+			if err := ep.LeaveWithContext(ctx, sb); err != nil {
 				log.Warnf("Failed detaching sandbox %s from endpoint %s: %v\n", sb.ID(), ep.ID(), err)
 			}
 		}
 
-		if err := ep.Delete(force); err != nil {
+		if err := ep.DeleteWithContext(ctx, force); err != nil {
 			log.Warnf("Failed deleting endpoint %s: %v\n", ep.ID(), err)
 		}
+
+		// Retain the sandbox if the driver cleanup was abandoned,
+		// so that the delete can be retried.
+		if ctx.Err() != nil {
+			retain = true
+		}
 	}
 
 	if retain {
@@ -236,6 +265,7 @@ func (sb *sandbox) delete(force bool) error {
 	if sb.resolver != nil {
 		sb.resolver.Stop()
 	}
+	sb.queryLog.close()
 
 	if sb.osSbox != nil && !sb.config.useDefaultSandBox {
 		sb.osSbox.Destroy()
@@ -252,6 +282,8 @@ func (sb *sandbox) delete(force bool) error {
 	delete(c.sandboxes, sb.ID())
 	c.Unlock()
 
+	c.publishEvent(Event{Type: EventSandboxDestroy, SandboxID: sb.ID(), ContainerID: sb.ContainerID()})
+
 	return nil
 }
 
@@ -280,12 +312,16 @@ func (sb *sandbox) Rename(name string) error {
 }
 
 func (sb *sandbox) Refresh(options ...SandboxOption) error {
+	return sb.RefreshWithContext(context.Background(), options...)
+}
+
+func (sb *sandbox) RefreshWithContext(ctx context.Context, options ...SandboxOption) error {
 	// Store connected endpoints
 	epList := sb.getConnectedEndpoints()
 
 	// Detach from all endpoints
 	for _, ep := range epList {
-		if err := ep.Leave(sb); err != nil {
+		if err := ep.LeaveWithContext(ctx, sb); err != nil {
 			log.Warnf("Failed detaching sandbox %s from endpoint %s: %v\n", sb.ID(), ep.ID(), err)
 		}
 	}
@@ -301,7 +337,7 @@ func (sb *sandbox) Refresh(options ...SandboxOption) error {
 
 	// Re-connect to all endpoints
 	for _, ep := range epList {
-		if err := ep.Join(sb); err != nil {
+		if err := ep.JoinWithContext(ctx, sb); err != nil {
 			log.Warnf("Failed attach sandbox %s to endpoint %s: %v\n", sb.ID(), ep.ID(), err)
 		}
 	}
@@ -352,6 +388,14 @@ func (sb *sandbox) getConnectedEndpoints() []*endpoint {
 	return eps
 }
 
+// getSortedEndpoints returns the connected endpoints by decreasing priority,
+// the order in which they are considered for the default gateway
+func (sb *sandbox) getSortedEndpoints() []*endpoint {
+	eps := epHeap(sb.getConnectedEndpoints())
+	sort.Sort(eps)
+	return eps
+}
+
 func (sb *sandbox) removeEndpoint(ep *endpoint) {
 	sb.Lock()
 	defer sb.Unlock()
@@ -423,12 +467,12 @@ func (sb *sandbox) ResolveIP(ip string) string {
 			continue
 		}
 
-		nwName := n.Name()
+		suffix := n.dnsSuffix()
 		n.Lock()
 		svc, ok = sr.ipMap[ip]
 		n.Unlock()
 		if ok {
-			return svc + "." + nwName
+			return svc + "." + suffix
 		}
 	}
 	return svc
@@ -459,22 +503,13 @@ func (sb *sandbox) ResolveService(name string) ([]*net.SRV, []net.IP, error) {
 	for _, ep := range sb.getConnectedEndpoints() {
 		n := ep.getNetwork()
 
+		suffix := n.dnsSuffix()
 		c := n.getController()
 
+		// The service records are updated under the controller lock
 		c.Lock()
-		sr, ok := c.svcRecords[n.ID()]
-		c.Unlock()
-
-		if !ok {
-			continue
-		}
-
-		svcs, ok := sr.service[svcName]
-		if !ok {
-			continue
-		}
-
-		for _, svc := range svcs {
+		sr := c.svcRecords[n.ID()]
+		for _, svc := range sr.service[svcName] {
 			if svc.portName != portName {
 				continue
 			}
@@ -482,15 +517,27 @@ func (sb *sandbox) ResolveService(name string) ([]*net.SRV, []net.IP, error) {
 				continue
 			}
 			for _, t := range svc.target {
+				target := t.name
+				if target == "" {
+					// The tasks of the DNS round robin services
+					// are the targets, named after the endpoints
+					name, ok := sr.ipMap[netutils.ReverseIP(t.ip.String())]
+					if !ok {
+						continue
+					}
+					target = name + "." + suffix + "."
+				}
 				srv = append(srv,
 					&net.SRV{
-						Target: t.name,
+						Target: target,
 						Port:   t.port,
 					})
 
 				ip = append(ip, t.ip)
 			}
 		}
+		c.Unlock()
+
 		if len(srv) > 0 {
 			break
 		}
@@ -541,23 +588,7 @@ func (sb *sandbox) ResolveName(name string, ipType int) ([]net.IP, bool) {
 	// {a in network b.c.d},
 
 	log.Debugf("Name To resolve: %v", name)
-	name = strings.TrimSuffix(name, ".")
-	reqName := []string{name}
-	networkName := []string{""}
-
-	if strings.Contains(name, ".") {
-		var i int
-		dup := name
-		for {
-			if i = strings.LastIndex(dup, "."); i == -1 {
-				break
-			}
-			networkName = append(networkName, name[i+1:])
-			reqName = append(reqName, name[:i])
-
-			dup = dup[:i]
-		}
-	}
+	reqName, networkName := splitDNSName(name)
 
 	epList := sb.getConnectedEndpoints()
 
@@ -605,7 +636,7 @@ func (sb *sandbox) resolveName(req string, networkName string, epList []*endpoin
 		name := req
 		n := ep.getNetwork()
 
-		if networkName != "" && networkName != n.Name() {
+		if networkName != "" && !n.matchesDNSSuffix(networkName) {
 			continue
 		}
 
@@ -789,9 +820,12 @@ func (sb *sandbox) restoreOslSandbox() error {
 		if len(i.llAddrs) != 0 {
 			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().LinkLocalAddresses(i.llAddrs))
 		}
+		ipAliases := i.ipAliases()
 		if len(ep.virtualIP) != 0 {
-			vipAlias := &net.IPNet{IP: ep.virtualIP, Mask: net.CIDRMask(32, 32)}
-			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().IPAliases([]*net.IPNet{vipAlias}))
+			ipAliases = append(ipAliases, &net.IPNet{IP: ep.virtualIP, Mask: net.CIDRMask(32, 32)})
+		}
+		if len(ipAliases) != 0 {
+			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().IPAliases(ipAliases))
 		}
 		Ifaces[fmt.Sprintf("%s+%s", i.srcName, i.dstPrefix)] = ifaceOptions
 		if joinInfo != nil {
@@ -846,9 +880,12 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 		if len(i.llAddrs) != 0 {
 			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().LinkLocalAddresses(i.llAddrs))
 		}
+		ipAliases := i.ipAliases()
 		if len(ep.virtualIP) != 0 {
-			vipAlias := &net.IPNet{IP: ep.virtualIP, Mask: net.CIDRMask(32, 32)}
-			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().IPAliases([]*net.IPNet{vipAlias}))
+			ipAliases = append(ipAliases, &net.IPNet{IP: ep.virtualIP, Mask: net.CIDRMask(32, 32)})
+		}
+		if len(ipAliases) != 0 {
+			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().IPAliases(ipAliases))
 		}
 		if i.mac != nil {
 			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().MacAddress(i.mac))
@@ -886,6 +923,11 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 	// place in the sandbox.
 	sb.populateLoadbalancers(ep)
 
+	sb.updateDNSForwardRules()
+	if err := sb.updateSearchDomains(); err != nil {
+		log.Warnf("Failed to update the DNS search domains of container %s: %v", sb.ContainerID(), err)
+	}
+
 	// Only update the store if we did not come here as part of
 	// sandbox delete. If we came here as part of delete then do
 	// not bother updating the store. The sandbox object will be
@@ -951,6 +993,11 @@ func (sb *sandbox) clearNetworkResources(origEp *endpoint) error {
 		sb.updateGateway(gwepAfter)
 	}
 
+	sb.updateDNSForwardRules()
+	if err := sb.updateSearchDomains(); err != nil {
+		log.Warnf("Failed to update the DNS search domains of container %s: %v", sb.ContainerID(), err)
+	}
+
 	// Only update the store if we did not come here as part of
 	// sandbox delete. If we came here as part of delete then do
 	// not bother updating the store. The sandbox object will be
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/etchosts"
@@ -42,7 +43,10 @@ func (sb *sandbox) startResolver(restore bool) {
 				return
 			}
 		}
+		sb.resolvConfLock.Lock()
 		sb.resolver.SetExtServers(sb.extDNS)
+		sb.resolvConfLock.Unlock()
+		sb.resolver.SetForwardRules(sb.dnsForwardRules())
 
 		sb.osSbox.InvokeFunc(sb.resolver.SetupFunc())
 		if err = sb.resolver.Start(); err != nil {
@@ -93,10 +97,10 @@ func (sb *sandbox) buildHostsFile() error {
 	return etchosts.Build(sb.config.hostsPath, "", sb.config.hostName, sb.config.domainName, extraContent)
 }
 
-func (sb *sandbox) updateHostsFile(ifaceIP string) error {
+func (sb *sandbox) updateHostsFile(ifaceIPs []string) error {
 	var mhost string
 
-	if ifaceIP == "" {
+	if len(ifaceIPs) == 0 {
 		return nil
 	}
 
@@ -111,7 +115,10 @@ func (sb *sandbox) updateHostsFile(ifaceIP string) error {
 		mhost = sb.config.hostName
 	}
 
-	extraContent := []etchosts.Record{{Hosts: mhost, IP: ifaceIP}}
+	extraContent := make([]etchosts.Record, 0, len(ifaceIPs))
+	for _, ip := range ifaceIPs {
+		extraContent = append(extraContent, etchosts.Record{Hosts: mhost, IP: ip})
+	}
 
 	sb.addHostsEntries(extraContent)
 	return nil
@@ -156,6 +163,9 @@ func (sb *sandbox) restorePath() {
 }
 
 func (sb *sandbox) setupDNS() error {
+	sb.resolvConfLock.Lock()
+	defer sb.resolvConfLock.Unlock()
+
 	var newRC *resolvconf.File
 
 	if sb.config.resolvConfPath == "" {
@@ -177,7 +187,7 @@ func (sb *sandbox) setupDNS() error {
 		return nil
 	}
 
-	currRC, err := resolvconf.Get()
+	currRC, err := resolvconf.GetSpecific(sb.controller.hostResolvConf())
 	if err != nil {
 		return err
 	}
@@ -236,6 +246,9 @@ func (sb *sandbox) updateDNS(ipv6Enabled bool) error {
 		return nil
 	}
 
+	sb.resolvConfLock.Lock()
+	defer sb.resolvConfLock.Unlock()
+
 	currRC, err := resolvconf.GetSpecific(sb.config.resolvConfPath)
 	if err != nil {
 		if !os.IsNotExist(err) {
@@ -269,6 +282,10 @@ func (sb *sandbox) updateDNS(ipv6Enabled bool) error {
 		return err
 	}
 
+	return sb.writeResolvConfHash(newRC.Hash)
+}
+
+func (sb *sandbox) writeResolvConfHash(hash string) error {
 	// write the new hash in a temp file and rename it to make the update atomic
 	dir := path.Dir(sb.config.resolvConfPath)
 	tmpHashFile, err := ioutil.TempFile(dir, "hash")
@@ -279,14 +296,83 @@ func (sb *sandbox) updateDNS(ipv6Enabled bool) error {
 		tmpHashFile.Close()
 		return err
 	}
-	_, err = tmpHashFile.Write([]byte(newRC.Hash))
+	_, err = tmpHashFile.Write([]byte(hash))
 	if err1 := tmpHashFile.Close(); err == nil {
 		err = err1
 	}
 	if err != nil {
 		return err
 	}
-	return os.Rename(tmpHashFile.Name(), hashFile)
+	return os.Rename(tmpHashFile.Name(), sb.config.resolvConfHashFile)
+}
+
+// updateHostDNS applies the nameservers of the changed host resolv.conf to
+// the sandbox: the embedded DNS server forwards to them and the container
+// resolv.conf lists them. The sandboxes configured with explicit
+// nameservers and the ones using the host resolv.conf are left untouched.
+func (sb *sandbox) updateHostDNS(hostRC *resolvconf.File) error {
+	sb.resolvConfLock.Lock()
+	defer sb.resolvConfLock.Unlock()
+
+	if sb.config.resolvConfPath == "" || sb.config.originResolvConfPath != "" || len(sb.config.dnsList) > 0 {
+		return nil
+	}
+
+	currRC, err := resolvconf.GetSpecific(sb.config.resolvConfPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// replace any localhost/127.* and remove IPv6 nameservers if IPv6 disabled.
+	newRC, err := resolvconf.FilterResolvDNS(hostRC.Content, sb.ipv6Enabled())
+	if err != nil {
+		return err
+	}
+
+	if sb.resolver != nil {
+		extDNS := resolvconf.GetNameservers(newRC.Content, types.IPv4)
+		dnsList := append([]string{sb.resolver.NameServer()}, resolvconf.GetNameservers(newRC.Content, types.IPv6)...)
+		if reflect.DeepEqual(dnsList, resolvconf.GetNameservers(currRC.Content, types.IP)) && reflect.DeepEqual(extDNS, sb.extDNS) {
+			return nil
+		}
+
+		sb.extDNS = extDNS
+		sb.resolver.SetExtServers(extDNS)
+		_, err = resolvconf.Build(sb.config.resolvConfPath, dnsList,
+			resolvconf.GetSearchDomains(currRC.Content), resolvconf.GetOptions(currRC.Content))
+		return err
+	}
+
+	h, err := ioutil.ReadFile(sb.config.resolvConfHashFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(h) > 0 && string(h) != currRC.Hash {
+		// The user has changed the container resolv.conf
+		return nil
+	}
+
+	if len(sb.config.dnsSearchList) > 0 || len(sb.config.dnsOptionsList) > 0 {
+		dnsSearchList := resolvconf.GetSearchDomains(newRC.Content)
+		if len(sb.config.dnsSearchList) > 0 {
+			dnsSearchList = sb.config.dnsSearchList
+		}
+		dnsOptionsList := resolvconf.GetOptions(newRC.Content)
+		if len(sb.config.dnsOptionsList) > 0 {
+			dnsOptionsList = sb.config.dnsOptionsList
+		}
+		if newRC, err = resolvconf.Build(sb.config.resolvConfPath, resolvconf.GetNameservers(newRC.Content, types.IP),
+			dnsSearchList, dnsOptionsList); err != nil {
+			return err
+		}
+	} else if err = ioutil.WriteFile(sb.config.resolvConfPath, newRC.Content, filePerm); err != nil {
+		return err
+	}
+
+	return sb.writeResolvConfHash(newRC.Hash)
 }
 
 // Embedded DNS server has to be enabled for this sandbox. Rebuild the container's
@@ -295,6 +381,9 @@ func (sb *sandbox) updateDNS(ipv6Enabled bool) error {
 // - Add only the embedded server's IP to container's resolv.conf
 // - If the embedded server needs any resolv.conf options add it to the current list
 func (sb *sandbox) rebuildDNS() error {
+	sb.resolvConfLock.Lock()
+	defer sb.resolvConfLock.Unlock()
+
 	currRC, err := resolvconf.GetSpecific(sb.config.resolvConfPath)
 	if err != nil {
 		return err
@@ -320,6 +409,38 @@ func (sb *sandbox) rebuildDNS() error {
 	return err
 }
 
+// updateSearchDomains puts the DNS domains of the connected networks at the
+// head of the container resolv.conf search list and drops the domains added
+// for the networks the container left. Only the resolv.conf managed for the
+// embedded DNS server is updated.
+func (sb *sandbox) updateSearchDomains() error {
+	if sb.resolver == nil || sb.config.originResolvConfPath != "" {
+		return nil
+	}
+
+	sb.resolvConfLock.Lock()
+	defer sb.resolvConfLock.Unlock()
+
+	currRC, err := resolvconf.GetSpecific(sb.config.resolvConfPath)
+	if err != nil {
+		return err
+	}
+
+	currSearchList := resolvconf.GetSearchDomains(currRC.Content)
+	dnsSearchList, added := mergeSearchDomains(sb.dnsSearchDomains(), currSearchList, sb.dnsSearchAdded)
+	if reflect.DeepEqual(dnsSearchList, currSearchList) || (len(dnsSearchList) == 0 && len(currSearchList) == 0) {
+		sb.dnsSearchAdded = added
+		return nil
+	}
+
+	if _, err = resolvconf.Build(sb.config.resolvConfPath, resolvconf.GetNameservers(currRC.Content, types.IP),
+		dnsSearchList, resolvconf.GetOptions(currRC.Content)); err != nil {
+		return err
+	}
+	sb.dnsSearchAdded = added
+	return nil
+}
+
 func createBasePath(dir string) error {
 	return os.MkdirAll(dir, dirPerm)
 }
@@ -4,6 +4,7 @@ package libnetwork
 
 import (
 	"github.com/docker/libnetwork/etchosts"
+	"github.com/docker/libnetwork/resolvconf"
 )
 
 // Stub implementations for DNS related functions
@@ -18,7 +19,7 @@ func (sb *sandbox) setupResolutionFiles() error {
 func (sb *sandbox) restorePath() {
 }
 
-func (sb *sandbox) updateHostsFile(ifaceIP string) error {
+func (sb *sandbox) updateHostsFile(ifaceIPs []string) error {
 	return nil
 }
 
@@ -33,3 +34,11 @@ func (sb *sandbox) deleteHostsEntries(recs []etchosts.Record) {
 func (sb *sandbox) updateDNS(ipv6Enabled bool) error {
 	return nil
 }
+
+func (sb *sandbox) updateSearchDomains() error {
+	return nil
+}
+
+func (sb *sandbox) updateHostDNS(hostRC *resolvconf.File) error {
+	return nil
+}
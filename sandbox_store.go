@@ -8,6 +8,7 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/osl"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -28,6 +29,7 @@ type sbState struct {
 	Eps        []epState
 	EpPriority map[string]int
 	ExtDNS     []string
+	DNSSearch  []string
 }
 
 func (sbs *sbState) Key() []string {
@@ -118,6 +120,10 @@ func (sbs *sbState) CopyTo(o datastore.KVObject) error {
 		dstSbs.ExtDNS = append(dstSbs.ExtDNS, dns)
 	}
 
+	for _, search := range sbs.DNSSearch {
+		dstSbs.DNSSearch = append(dstSbs.DNSSearch, search)
+	}
+
 	return nil
 }
 
@@ -126,12 +132,18 @@ func (sbs *sbState) DataScope() string {
 }
 
 func (sb *sandbox) storeUpdate() error {
+	sb.resolvConfLock.Lock()
+	extDNS := sb.extDNS
+	dnsSearch := sb.dnsSearchAdded
+	sb.resolvConfLock.Unlock()
+
 	sbs := &sbState{
 		c:          sb.controller,
 		ID:         sb.id,
 		Cid:        sb.containerID,
 		EpPriority: sb.epPriority,
-		ExtDNS:     sb.extDNS,
+		ExtDNS:     extDNS,
+		DNSSearch:  dnsSearch,
 	}
 
 retry:
@@ -206,6 +218,7 @@ func (c *controller) sandboxCleanup(activeSandboxes map[string]interface{}) {
 			isStub:             true,
 			dbExists:           true,
 			extDNS:             sbs.ExtDNS,
+			dnsSearchAdded:     sbs.DNSSearch,
 		}
 
 		msg := " for cleanup"
@@ -254,7 +267,7 @@ func (c *controller) sandboxCleanup(activeSandboxes map[string]interface{}) {
 
 		if _, ok := activeSandboxes[sb.ID()]; !ok {
 			logrus.Infof("Removing stale sandbox %s (%s)", sb.id, sb.containerID)
-			if err := sb.delete(true); err != nil {
+			if err := sb.delete(context.Background(), true); err != nil {
 				logrus.Errorf("Failed to delete sandbox %s while trying to cleanup: %v", sb.id, err)
 			}
 			continue
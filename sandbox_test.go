@@ -1,13 +1,19 @@
 package libnetwork
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/resolvconf"
 	"github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
 )
 
 func getTestEnv(t *testing.T, empty bool) (NetworkController, Network, Network) {
@@ -216,3 +222,192 @@ func TestSandboxAddSamePrio(t *testing.T) {
 
 	osl.GC()
 }
+
+type tstResolver struct {
+	extServers []string
+}
+
+func (r *tstResolver) Start() error                        { return nil }
+func (r *tstResolver) Stop()                               {}
+func (r *tstResolver) SetupFunc() func()                   { return func() {} }
+func (r *tstResolver) NameServer() string                  { return resolverIP }
+func (r *tstResolver) SetExtServers(servers []string)      { r.extServers = servers }
+func (r *tstResolver) SetForwardRules(map[string][]string) {}
+func (r *tstResolver) ResolverOptions() []string           { return []string{"ndots:0"} }
+
+func TestUpdateHostDNS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "host-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newSandbox := func(id, content string, options ...SandboxOption) *sandbox {
+		sb := &sandbox{id: id, epPriority: map[string]int{}}
+		sb.processOptions(options...)
+		sb.config.resolvConfPath = filepath.Join(dir, id, "resolv.conf")
+		sb.config.resolvConfHashFile = sb.config.resolvConfPath + ".hash"
+		if err := os.MkdirAll(filepath.Dir(sb.config.resolvConfPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(sb.config.resolvConfPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return sb
+	}
+	nameservers := func(sb *sandbox) []string {
+		rc, err := resolvconf.GetSpecific(sb.config.resolvConfPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resolvconf.GetNameservers(rc.Content, types.IP)
+	}
+
+	res := &tstResolver{}
+	sbRes := newSandbox("res", "search example.com\nnameserver 127.0.0.11\noptions ndots:0\n")
+	sbRes.resolver = res
+	sbRes.extDNS = []string{"1.1.1.1"}
+
+	sbPlain := newSandbox("plain", "nameserver 1.1.1.1\n")
+	sbExplicit := newSandbox("explicit", "nameserver 9.9.9.9\n", OptionDNS("9.9.9.9"))
+
+	sbEdited := newSandbox("edited", "nameserver 1.1.1.1\n")
+	if err := ioutil.WriteFile(sbEdited.config.resolvConfHashFile, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hostRC := &resolvconf.File{Content: []byte("nameserver 127.0.0.53\nnameserver 2.2.2.2\nnameserver 3.3.3.3\n")}
+	for _, sb := range []*sandbox{sbRes, sbPlain, sbExplicit, sbEdited} {
+		if err := sb.updateHostDNS(hostRC); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !reflect.DeepEqual(res.extServers, []string{"2.2.2.2", "3.3.3.3"}) || !reflect.DeepEqual(sbRes.extDNS, res.extServers) {
+		t.Fatalf("Unexpected embedded server upstreams: %v", res.extServers)
+	}
+	if ns := nameservers(sbRes); !reflect.DeepEqual(ns, []string{resolverIP}) {
+		t.Fatalf("Unexpected nameservers with the embedded server: %v", ns)
+	}
+	rc, _ := resolvconf.GetSpecific(sbRes.config.resolvConfPath)
+	if s := resolvconf.GetSearchDomains(rc.Content); !reflect.DeepEqual(s, []string{"example.com"}) {
+		t.Fatalf("Search domains not preserved: %v", s)
+	}
+
+	if ns := nameservers(sbPlain); !reflect.DeepEqual(ns, []string{"2.2.2.2", "3.3.3.3"}) {
+		t.Fatalf("Unexpected nameservers: %v", ns)
+	}
+	if ns := nameservers(sbExplicit); !reflect.DeepEqual(ns, []string{"9.9.9.9"}) {
+		t.Fatalf("Explicit nameservers must be left untouched: %v", ns)
+	}
+	if ns := nameservers(sbEdited); !reflect.DeepEqual(ns, []string{"1.1.1.1"}) {
+		t.Fatalf("User edited resolv.conf must be left untouched: %v", ns)
+	}
+}
+
+func TestUpdateSearchDomains(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search-domains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &controller{sandboxes: sandboxTable{}}
+	sb := &sandbox{id: "sb", controller: c, resolver: &tstResolver{}, epPriority: map[string]int{"ep2": 5, "ep3": 10}}
+	c.sandboxes[sb.id] = sb
+	sb.config.resolvConfPath = filepath.Join(dir, "resolv.conf")
+	if err := ioutil.WriteFile(sb.config.resolvConfPath, []byte("search svc.example example.com\nnameserver 127.0.0.11\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	searchDomains := func() []string {
+		rc, err := resolvconf.GetSpecific(sb.config.resolvConfPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resolvconf.GetSearchDomains(rc.Content)
+	}
+
+	// svc.example is in the search list regardless of the network
+	newEndpoint := func(id, nid, domain string) *endpoint {
+		return &endpoint{id: id, sandboxID: sb.id, network: &network{id: nid, name: nid, ctrlr: c, dnsDomain: domain}}
+	}
+	ep1 := newEndpoint("ep1", "n1", "svc.example")
+	ep2 := newEndpoint("ep2", "n2", "other.example")
+	ep3 := newEndpoint("ep3", "n3", "prio.example")
+	// The heap only keeps the highest priority endpoint first
+	sb.endpoints = epHeap{ep3, ep1, ep2}
+	if err := sb.updateSearchDomains(); err != nil {
+		t.Fatal(err)
+	}
+	if s := searchDomains(); !reflect.DeepEqual(s, []string{"prio.example", "other.example", "svc.example", "example.com"}) {
+		t.Fatalf("Unexpected search domains after join: %v", s)
+	}
+
+	sb.endpoints = epHeap{}
+	if err := sb.updateSearchDomains(); err != nil {
+		t.Fatal(err)
+	}
+	if s := searchDomains(); !reflect.DeepEqual(s, []string{"svc.example", "example.com"}) {
+		t.Fatalf("Unexpected search domains after leave: %v", s)
+	}
+}
+
+func TestDNSForwardRulesPriority(t *testing.T) {
+	c := &controller{sandboxes: sandboxTable{}}
+	sb := &sandbox{id: "sb", controller: c, epPriority: map[string]int{"ep1": 1, "ep2": 10, "ep3": 5}}
+	c.sandboxes[sb.id] = sb
+
+	newEndpoint := func(id, name string, rules map[string][]string) *endpoint {
+		n := &network{id: name, name: name, ctrlr: c, dnsForwardRules: rules}
+		return &endpoint{id: id, network: n, sandboxID: sb.id}
+	}
+	ep1 := newEndpoint("ep1", "n1", map[string][]string{
+		"example.com": {"1.1.1.1"},
+		"corp":        {"1.1.1.1"},
+		"low":         {"1.1.1.1"},
+	})
+	ep2 := newEndpoint("ep2", "n2", map[string][]string{
+		"example.com": {"2.2.2.2"},
+	})
+	ep3 := newEndpoint("ep3", "n3", map[string][]string{
+		"example.com": {"3.3.3.3"},
+		"corp":        {"3.3.3.3"},
+	})
+	// The heap only keeps the highest priority endpoint first
+	sb.endpoints = epHeap{ep2, ep1, ep3}
+
+	expected := map[string][]string{
+		"example.com": {"2.2.2.2"},
+		"corp":        {"3.3.3.3"},
+		"low":         {"1.1.1.1"},
+	}
+	if rules := sb.dnsForwardRules(); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("Unexpected forward rules: %v", rules)
+	}
+
+	sb.config.dnsForwardRules = map[string][]string{"corp": {"9.9.9.9"}}
+	expected["corp"] = []string{"9.9.9.9"}
+	if rules := sb.dnsForwardRules(); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("Unexpected forward rules with the sandbox rule: %v", rules)
+	}
+}
+
+func TestSandboxStateCopyTo(t *testing.T) {
+	sbs := &sbState{
+		ID:        "sb",
+		ExtDNS:    []string{"1.1.1.1"},
+		DNSSearch: []string{"svc.example"},
+	}
+	dst := sbs.New().(*sbState)
+	if err := sbs.CopyTo(dst); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst.ExtDNS, sbs.ExtDNS) || !reflect.DeepEqual(dst.DNSSearch, sbs.DNSSearch) {
+		t.Fatalf("Unexpected copy of the sandbox state: %+v", dst)
+	}
+
+	dst.DNSSearch[0] = "other.example"
+	if sbs.DNSSearch[0] != "svc.example" {
+		t.Fatal("The copy of the sandbox state shares the search domains")
+	}
+}
@@ -3,7 +3,10 @@ package libnetwork
 import (
 	"fmt"
 	"net"
+	"strings"
 	"sync"
+
+	"github.com/docker/libnetwork/types"
 )
 
 var (
@@ -29,6 +32,17 @@ func (p portConfigs) String() string {
 	return str
 }
 
+// ServiceMode is the way the clients of a service reach its tasks
+type ServiceMode string
+
+const (
+	// ServiceModeVIP load balances the service through a virtual IP
+	ServiceModeVIP ServiceMode = "vip"
+	// ServiceModeDNSRR resolves the service name to the task IPs. No
+	// virtual IP is used and no IPVS rules are programmed for the service.
+	ServiceModeDNSRR ServiceMode = "dnsrr"
+)
+
 type serviceKey struct {
 	id    string
 	ports string
@@ -62,3 +76,126 @@ type loadBalancer struct {
 	// Back pointer to service to which the loadbalancer belongs.
 	service *service
 }
+
+// validateServiceMode checks the service configuration of the endpoint
+// against the mode of the service
+func (ep *endpoint) validateServiceMode(n *network) error {
+	switch ep.svcMode {
+	case "", ServiceModeVIP:
+		return nil
+	case ServiceModeDNSRR:
+	default:
+		return types.BadRequestErrorf("invalid service mode %q for endpoint %s", ep.svcMode, ep.name)
+	}
+
+	if len(ep.virtualIP) != 0 {
+		return types.BadRequestErrorf("virtual IP %s set for service %s in %s mode", ep.virtualIP, ep.svcName, ep.svcMode)
+	}
+	if n.ingress {
+		return types.BadRequestErrorf("service %s in %s mode cannot be attached to the ingress network", ep.svcName, ep.svcMode)
+	}
+	for _, p := range ep.ingressPorts {
+		if p.PublishedPort != 0 {
+			return types.BadRequestErrorf("port %d of service %s in %s mode cannot be published through the ingress network",
+				p.PublishedPort, ep.svcName, ep.svcMode)
+		}
+	}
+	return nil
+}
+
+// addSvcPortRecords adds the task IP as a target of the SRV records for
+// the named ports of a DNS round robin service
+func (n *network) addSvcPortRecords(name string, aliases []string, ports []*PortConfig, ip net.IP) {
+	c := n.getController()
+	c.Lock()
+	defer c.Unlock()
+	sr, ok := c.svcRecords[n.ID()]
+	if !ok {
+		sr = svcInfo{
+			svcMap:     make(map[string][]net.IP),
+			svcIPv6Map: make(map[string][]net.IP),
+			ipMap:      make(map[string]string),
+		}
+	}
+	if sr.service == nil {
+		sr.service = make(map[string][]servicePorts)
+	}
+	c.svcRecords[n.ID()] = sr
+
+	for _, svcName := range append([]string{name}, aliases...) {
+		for _, p := range ports {
+			if p.Name == "" {
+				continue
+			}
+			portName, proto := srvPortNames(p)
+			sr.service[svcName] = addSvcTarget(sr.service[svcName], portName, proto,
+				serviceTarget{ip: ip, port: uint16(p.TargetPort)})
+		}
+	}
+}
+
+func (n *network) deleteSvcPortRecords(name string, aliases []string, ports []*PortConfig, ip net.IP) {
+	c := n.getController()
+	c.Lock()
+	defer c.Unlock()
+	sr, ok := c.svcRecords[n.ID()]
+	if !ok || sr.service == nil {
+		return
+	}
+
+	for _, svcName := range append([]string{name}, aliases...) {
+		for _, p := range ports {
+			if p.Name == "" {
+				continue
+			}
+			portName, proto := srvPortNames(p)
+			svcs := delSvcTarget(sr.service[svcName], portName, proto, ip)
+			if len(svcs) == 0 {
+				delete(sr.service, svcName)
+				continue
+			}
+			sr.service[svcName] = svcs
+		}
+	}
+}
+
+// srvPortNames returns the port name and protocol labels the port is
+// queried with, as in _name._proto.service
+func srvPortNames(p *PortConfig) (string, string) {
+	return "_" + p.Name, "_" + strings.ToLower(PortConfig_Protocol_name[int32(p.Protocol)])
+}
+
+func addSvcTarget(svcs []servicePorts, portName, proto string, t serviceTarget) []servicePorts {
+	for i, svc := range svcs {
+		if svc.portName != portName || svc.proto != proto {
+			continue
+		}
+		for _, st := range svc.target {
+			if st.ip.Equal(t.ip) {
+				return svcs
+			}
+		}
+		svcs[i].target = append(svcs[i].target, t)
+		return svcs
+	}
+	return append(svcs, servicePorts{portName: portName, proto: proto, target: []serviceTarget{t}})
+}
+
+func delSvcTarget(svcs []servicePorts, portName, proto string, ip net.IP) []servicePorts {
+	for i, svc := range svcs {
+		if svc.portName != portName || svc.proto != proto {
+			continue
+		}
+		for j, st := range svc.target {
+			if st.ip.Equal(ip) {
+				svcs[i].target = append(svc.target[:j], svc.target[j+1:]...)
+				break
+			}
+		}
+		if len(svcs[i].target) == 0 {
+			svcs = append(svcs[:i], svcs[i+1:]...)
+		}
+		break
+	}
+	return svcs
+}
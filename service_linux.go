@@ -117,6 +117,11 @@ func (c *controller) addServiceBinding(name, sid, nid, eid string, vip net.IP, i
 		n.(*network).addSvcRecords(alias, svcIP, nil, false)
 	}
 
+	// Without a vip the SRV records point straight to the tasks
+	if len(vip) == 0 {
+		n.(*network).addSvcPortRecords(name, aliases, ingressPorts, ip)
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
@@ -225,6 +230,7 @@ func (c *controller) rmServiceBinding(name, sid, nid, eid string, vip net.IP, in
 		for _, alias := range aliases {
 			n.(*network).deleteSvcRecords(alias, ip, nil, false)
 		}
+		n.(*network).deleteSvcPortRecords(name, aliases, ingressPorts, ip)
 	}
 
 	// Remove the DNS record for VIP only if we are removing the service
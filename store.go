@@ -9,6 +9,7 @@ import (
 	"github.com/docker/libkv/store/etcd"
 	"github.com/docker/libkv/store/zookeeper"
 	"github.com/docker/libnetwork/datastore"
+	"golang.org/x/net/context"
 )
 
 func registerKVStores() {
@@ -475,7 +476,7 @@ func (c *controller) networkCleanup() {
 
 var populateSpecial NetworkWalker = func(nw Network) bool {
 	if n := nw.(*network); n.hasSpecialDriver() {
-		if err := n.getController().addNetwork(n); err != nil {
+		if err := n.getController().addNetwork(context.Background(), n); err != nil {
 			log.Warnf("Failed to populate network %q with driver %q", nw.Name(), nw.Type())
 		}
 	}